@@ -6,6 +6,7 @@ require (
 	github.com/gofiber/fiber/v2 v2.52.0
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.19
+	golang.org/x/crypto v0.17.0
 	golang.org/x/oauth2 v0.15.0
 )
 
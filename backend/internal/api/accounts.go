@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"io"
 	"pooled-storage/internal/models"
 	"pooled-storage/internal/services"
 
@@ -34,8 +36,44 @@ func SetupAccountRoutes(router fiber.Router, service *services.AccountService) {
 		}
 
 		account, err := service.CreateAccount(&req)
+		if errors.Is(err, services.ErrDuplicateAccount) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.Status(201).JSON(account)
+	})
+
+	// Accepts either a JSON body or a multipart upload with the key file in
+	// "service_account_file" and the other fields as form values.
+	accounts.Post("/service", func(c *fiber.Ctx) error {
+		var req models.CreateServiceAccountRequest
+		if file, err := c.FormFile("service_account_file"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid upload"})
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid upload"})
+			}
+			req.ServiceAccountJSON = data
+			req.Name = c.FormValue("name")
+			req.Impersonate = c.FormValue("impersonate")
+			req.SharedDriveID = c.FormValue("shared_drive_id")
+		} else if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		account, err := service.CreateServiceAccount(&req)
+		if errors.Is(err, services.ErrDuplicateAccount) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 
 		return c.Status(201).JSON(account)
@@ -44,7 +82,7 @@ func SetupAccountRoutes(router fiber.Router, service *services.AccountService) {
 	accounts.Delete("/:id", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if err := service.DeleteAccount(id); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.JSON(fiber.Map{"message": "Account deleted successfully"})
 	})
@@ -59,6 +97,27 @@ func SetupAccountRoutes(router fiber.Router, service *services.AccountService) {
 		return c.JSON(account)
 	})
 
+	accounts.Get("/:id/shared-drives", func(c *fiber.Ctx) error {
+		drives, err := service.ListSharedDrives(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(drives)
+	})
+
+	accounts.Post("/:id/shared-drives", func(c *fiber.Ctx) error {
+		var req models.AddSharedDrivesRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		created, err := service.AddSharedDrives(c.Params("id"), &req)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error(), "created": created})
+		}
+		return c.Status(201).JSON(created)
+	})
+
 	accounts.Put("/:id/status", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		var req struct {
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"pooled-storage/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupDrainRoutes(router fiber.Router, service *services.DrainService) {
+	router.Post("/pools/:id/accounts/:accountId/drain", func(c *fiber.Ctx) error {
+		job, err := service.StartDrain(c.Params("id"), c.Params("accountId"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(job)
+	})
+
+	router.Get("/pools/:id/drains", func(c *fiber.Ctx) error {
+		jobs, err := service.ListDrains(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(jobs)
+	})
+
+	drains := router.Group("/drains")
+
+	drains.Get("/:id", func(c *fiber.Ctx) error {
+		job, err := service.GetDrain(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(job)
+	})
+
+	drains.Post("/:id/cancel", func(c *fiber.Ctx) error {
+		if err := service.CancelDrain(c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "Drain cancelled"})
+	})
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"pooled-storage/internal/services"
+)
+
+// errorStatus maps service errors to HTTP status codes.
+func errorStatus(err error) int {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		return 400
+	}
+	var uniqueErr *services.UniqueFilesError
+	if errors.As(err, &uniqueErr) {
+		return 409
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 404
+	}
+	return 500
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"io"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/services"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupImportRoutes(router fiber.Router, importService *services.ImportService) {
+	imports := router.Group("/accounts/import")
+
+	imports.Post("/preview", func(c *fiber.Ctx) error {
+		req, err := parseImportRequest(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		remotes, err := importService.Preview(c.Context(), req)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(remotes)
+	})
+
+	imports.Post("/", func(c *fiber.Ctx) error {
+		req, err := parseImportRequest(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		results, err := importService.Import(c.Context(), req)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(results)
+	})
+}
+
+// parseImportRequest accepts either a JSON body or a multipart upload with
+// the config in "config_file", the password in "password" and the remotes
+// to import as repeated or comma-separated "remotes" values.
+func parseImportRequest(c *fiber.Ctx) (*models.ImportConfigRequest, error) {
+	var req models.ImportConfigRequest
+
+	file, err := c.FormFile("config_file")
+	if err != nil {
+		if err := c.BodyParser(&req); err != nil {
+			return nil, fiber.NewError(400, "Invalid request")
+		}
+		return &req, nil
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return nil, fiber.NewError(400, "Invalid upload")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fiber.NewError(400, "Invalid upload")
+	}
+	req.Config = string(data)
+	req.Password = c.FormValue("password")
+
+	if form, err := c.MultipartForm(); err == nil {
+		for _, value := range form.Value["remotes"] {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					req.Remotes = append(req.Remotes, name)
+				}
+			}
+		}
+	}
+
+	return &req, nil
+}
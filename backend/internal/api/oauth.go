@@ -3,18 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"html"
 	"pooled-storage/internal/models"
 	"pooled-storage/internal/services"
 
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"golang.org/x/oauth2/microsoft"
 )
 
-func SetupOAuthRoutes(router fiber.Router, service *services.AccountService) {
+func SetupOAuthRoutes(router fiber.Router, service *services.AccountService, oauthService *services.OAuthService) {
 	oauth := router.Group("/oauth")
 
 	oauth.Post("/start", func(c *fiber.Ctx) error {
@@ -23,14 +21,11 @@ func SetupOAuthRoutes(router fiber.Router, service *services.AccountService) {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 		}
 
-		config, err := getOAuthConfig(req.Provider)
+		url, state, err := oauthService.BeginAuth(req.Provider)
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		state := fmt.Sprintf("%s_%d", req.Provider, c.Context().Time().Unix())
-		url := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
-
 		return c.JSON(fiber.Map{
 			"url":   url,
 			"state": state,
@@ -43,114 +38,98 @@ func SetupOAuthRoutes(router fiber.Router, service *services.AccountService) {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 		}
 
-		config, err := getOAuthConfig(req.Provider)
+		account, status, err := completeOAuth(service, oauthService, req.Provider, req.State, req.Code)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		token, err := config.Exchange(context.Background(), req.Code)
-		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "Failed to exchange token"})
-		}
+		return c.JSON(account)
+	})
 
-		// Get user email
-		email, err := getUserEmail(req.Provider, token.AccessToken)
-		if err != nil {
-			email = "unknown@example.com"
-		}
+	// Providers redirect the consent popup here with ?code=&state=
+	oauth.Get("/callback", func(c *fiber.Ctx) error {
+		c.Type("html")
 
-		// Create account
-		createReq := &models.CreateAccountRequest{
-			Name:  fmt.Sprintf("%s Account", req.Provider),
-			Type:  req.Provider,
-			Email: email,
-			Token: token.AccessToken,
+		if errMsg := c.Query("error"); errMsg != "" {
+			return c.Status(400).SendString(oauthResultPage("", errMsg))
 		}
 
-		account, err := service.CreateAccount(createReq)
+		account, status, err := completeOAuth(service, oauthService, "", c.Query("state"), c.Query("code"))
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(status).SendString(oauthResultPage("", err.Error()))
 		}
 
-		return c.JSON(account)
+		return c.SendString(oauthResultPage(account.ID, ""))
 	})
 
 	oauth.Get("/test", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"google_configured":    os.Getenv("GOOGLE_CLIENT_ID") != "",
-			"microsoft_configured": os.Getenv("MICROSOFT_CLIENT_ID") != "",
-		})
+		configs, err := oauthService.ListCredentials()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		status := fiber.Map{}
+		for provider, cfg := range configs {
+			status[provider+"_configured"] = cfg.Configured
+		}
+		return c.JSON(status)
 	})
 }
 
-func getOAuthConfig(provider string) (*oauth2.Config, error) {
-	hostIP := os.Getenv("HOST_IP")
-	if hostIP == "" {
-		hostIP = "192.168.100.14"
+func completeOAuth(service *services.AccountService, oauthService *services.OAuthService, provider, state, code string) (*models.Account, int, error) {
+	token, provider, err := oauthService.CompleteAuth(context.Background(), provider, state, code)
+	if errors.Is(err, services.ErrInvalidState) {
+		return nil, 400, fmt.Errorf("Invalid or expired OAuth state, please start the sign-in again")
+	}
+	if err != nil {
+		return nil, 400, fmt.Errorf("Failed to exchange token")
 	}
 
-	redirectURL := fmt.Sprintf("http://%s:20080/api/oauth/callback", hostIP)
-
-	switch provider {
-	case "google":
-		clientID := os.Getenv("GOOGLE_CLIENT_ID")
-		clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-		if clientID == "" || clientSecret == "" {
-			return nil, fmt.Errorf("Google OAuth not configured")
-		}
-
-		return &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			RedirectURL:  redirectURL,
-			Scopes:       []string{"https://www.googleapis.com/auth/drive"},
-			Endpoint:     google.Endpoint,
-		}, nil
-
-	case "microsoft":
-		clientID := os.Getenv("MICROSOFT_CLIENT_ID")
-		clientSecret := os.Getenv("MICROSOFT_CLIENT_SECRET")
-		if clientID == "" || clientSecret == "" {
-			return nil, fmt.Errorf("Microsoft OAuth not configured")
-		}
+	identity, err := oauthService.FetchIdentity(context.Background(), provider, token)
+	if err != nil {
+		return nil, 502, fmt.Errorf("Failed to look up account email: %w", err)
+	}
 
-		return &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			RedirectURL:  redirectURL,
-			Scopes:       []string{"https://graph.microsoft.com/Files.ReadWrite.All", "offline_access"},
-			Endpoint:     microsoft.AzureADEndpoint("common"),
-		}, nil
+	// Create account
+	createReq := &models.CreateAccountRequest{
+		Name:         identity.Name,
+		Type:         provider,
+		Email:        identity.Email,
+		Token:        token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		TokenExpiry:  token.Expiry,
+	}
 
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	account, err := service.CreateAccount(createReq)
+	if errors.Is(err, services.ErrDuplicateAccount) {
+		return nil, 409, err
+	}
+	if err != nil {
+		return nil, 500, err
 	}
-}
 
-func getUserEmail(provider, accessToken string) (string, error) {
-	// This is a simplified version - in production, you'd make actual API calls
-	return fmt.Sprintf("user@%s.com", provider), nil
+	return account, 200, nil
 }
 
-func SetupSettingsRoutes(router fiber.Router, db interface{}) {
-	settings := router.Group("/settings")
-
-	settings.Get("/oauth", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"google": fiber.Map{
-				"configured": os.Getenv("GOOGLE_CLIENT_ID") != "",
-			},
-			"microsoft": fiber.Map{
-				"configured": os.Getenv("MICROSOFT_CLIENT_ID") != "",
-			},
-		})
-	})
+// oauthResultPage tells the dashboard window that opened the consent popup
+// how the sign-in went, then closes the popup.
+func oauthResultPage(accountID, errMsg string) string {
+	message, _ := json.Marshal(fiber.Map{"type": "oauth-success", "account_id": accountID})
+	text := "Account connected. You can close this window."
+	if errMsg != "" {
+		message, _ = json.Marshal(fiber.Map{"type": "oauth-error", "error": errMsg})
+		text = "Sign-in failed: " + html.EscapeString(errMsg)
+	}
 
-	settings.Get("/system", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"host_ip":    os.Getenv("HOST_IP"),
-			"mount_path": os.Getenv("MOUNT_PATH"),
-			"version":    "1.0.0",
-		})
-	})
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html><body>
+<p>%s</p>
+<script>
+if (window.opener) {
+  window.opener.postMessage(%s, "*");
+  window.close();
+}
+</script>
+</body></html>`, text, message)
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"pooled-storage/internal/database"
+	"pooled-storage/internal/rclone"
+	"pooled-storage/internal/secrets"
+	"pooled-storage/internal/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestOAuthCallbackRejectsState(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "id")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "secret")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "pooled-storage.db"))
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := secrets.NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oauth := services.NewOAuthService(db, sealer)
+	app := fiber.New()
+	SetupOAuthRoutes(app.Group("/api"), services.NewAccountService(db, rclone.NewMemoryBackend(), sealer), oauth)
+
+	status := func(req *http.Request) int {
+		t.Helper()
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	post := func(provider, state string) int {
+		body := `{"provider":"` + provider + `","state":"` + state + `","code":"code"}`
+		req := httptest.NewRequest("POST", "/api/oauth/callback", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return status(req)
+	}
+	redirect := func(state string) int {
+		return status(httptest.NewRequest("GET", "/api/oauth/callback?code=code&state="+state, nil))
+	}
+	begin := func() string {
+		_, state, err := oauth.BeginAuth("google")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	if got := post("google", "made-up"); got != 400 {
+		t.Errorf("unknown state: status %d, want 400", got)
+	}
+	if got := redirect("made-up"); got != 400 {
+		t.Errorf("unknown state in the redirect: status %d, want 400", got)
+	}
+
+	expired := begin()
+	db.Exec("UPDATE oauth_states SET expires_at = ? WHERE state = ?", time.Now().Add(-time.Minute).UTC(), expired)
+	if got := redirect(expired); got != 400 {
+		t.Errorf("expired state: status %d, want 400", got)
+	}
+
+	// Rejected for the wrong provider, which uses the state up
+	reused := begin()
+	if got := post("microsoft", reused); got != 400 {
+		t.Errorf("state of another provider: status %d, want 400", got)
+	}
+	if got := post("google", reused); got != 400 {
+		t.Errorf("reused state: status %d, want 400", got)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupRebalanceRoutes(router fiber.Router, service *services.RebalanceService) {
+	router.Post("/pools/:id/rebalance", func(c *fiber.Ctx) error {
+		var req models.RebalanceRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+			}
+		}
+
+		job, err := service.PlanRebalance(c.Params("id"), &req)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(job)
+	})
+
+	router.Get("/pools/:id/rebalances", func(c *fiber.Ctx) error {
+		jobs, err := service.ListRebalances(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(jobs)
+	})
+
+	rebalances := router.Group("/rebalances")
+
+	rebalances.Get("/:id", func(c *fiber.Ctx) error {
+		job, err := service.GetRebalance(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(job)
+	})
+
+	rebalances.Post("/:id/start", func(c *fiber.Ctx) error {
+		job, err := service.StartRebalance(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(job)
+	})
+
+	rebalances.Post("/:id/cancel", func(c *fiber.Ctx) error {
+		if err := service.CancelRebalance(c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "Rebalance cancelled"})
+	})
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"pooled-storage/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupReconcileRoutes(router fiber.Router, reconciler *services.Reconciler) {
+	reconcile := router.Group("/reconcile")
+
+	reconcile.Get("/events", func(c *fiber.Ctx) error {
+		events, err := reconciler.ListDriftEvents(c.Query("pool_id"), c.QueryInt("limit", 100))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(events)
+	})
+
+	reconcile.Post("/", func(c *fiber.Ctx) error {
+		events, err := reconciler.Reconcile()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"events": events})
+	})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupServeRoutes(router fiber.Router, service *services.ServeService) {
+	router.Post("/pools/:id/serves", func(c *fiber.Ctx) error {
+		var req models.CreateServeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		serve, err := service.CreateServe(c.Params("id"), &req)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(serve)
+	})
+
+	router.Get("/pools/:id/serves", func(c *fiber.Ctx) error {
+		serves, err := service.ListServes(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(serves)
+	})
+
+	serves := router.Group("/serves")
+
+	serves.Get("/:id", func(c *fiber.Ctx) error {
+		serve, err := service.GetServe(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(serve)
+	})
+
+	serves.Delete("/:id", func(c *fiber.Ctx) error {
+		if err := service.DeleteServe(c.Params("id")); err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "Serve deleted"})
+	})
+}
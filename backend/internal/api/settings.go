@@ -0,0 +1,77 @@
+package api
+
+import (
+	"os"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupSettingsRoutes(router fiber.Router, oauthService *services.OAuthService, configService *services.ConfigService) {
+	settings := router.Group("/settings")
+
+	settings.Get("/oauth", func(c *fiber.Ctx) error {
+		configs, err := oauthService.ListCredentials()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(configs)
+	})
+
+	settings.Get("/oauth/:provider", func(c *fiber.Ctx) error {
+		configs, err := oauthService.ListCredentials()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		cfg, ok := configs[c.Params("provider")]
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "Unknown provider"})
+		}
+		return c.JSON(cfg)
+	})
+
+	settings.Put("/oauth/:provider", func(c *fiber.Ctx) error {
+		var req models.SaveOAuthConfigRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		cfg, err := oauthService.SaveCredentials(c.Params("provider"), &req)
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(cfg)
+	})
+
+	settings.Delete("/oauth/:provider", func(c *fiber.Ctx) error {
+		if err := oauthService.DeleteCredentials(c.Params("provider")); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "OAuth configuration deleted"})
+	})
+
+	settings.Get("/rclone-config", func(c *fiber.Ctx) error {
+		preview, err := configService.Preview()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(preview)
+	})
+
+	settings.Post("/rclone-config/regenerate", func(c *fiber.Ctx) error {
+		if err := configService.Regenerate(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "rclone config regenerated"})
+	})
+
+	settings.Get("/system", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"host_ip":    os.Getenv("HOST_IP"),
+			"mount_path": os.Getenv("MOUNT_PATH"),
+			"version":    "1.0.0",
+		})
+	})
+}
@@ -33,6 +33,22 @@ func SetupStatsRoutes(router fiber.Router, service *services.StatsService) {
 		return c.JSON(stats)
 	})
 
+	stats.Get("/pools/:id/size", func(c *fiber.Ctx) error {
+		size, err := service.GetPoolSize(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(size)
+	})
+
+	stats.Get("/transfers", func(c *fiber.Ctx) error {
+		stats, err := service.GetTransferStats()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(stats)
+	})
+
 	stats.Post("/refresh", func(c *fiber.Ctx) error {
 		if err := service.RefreshAllQuotas(); err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
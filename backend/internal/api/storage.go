@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"pooled-storage/internal/models"
 	"pooled-storage/internal/services"
 
@@ -8,6 +9,10 @@ import (
 )
 
 func SetupStorageRoutes(router fiber.Router, service *services.StorageService) {
+	router.Get("/mount-profiles", func(c *fiber.Ctx) error {
+		return c.JSON(service.MountProfiles())
+	})
+
 	pools := router.Group("/pools")
 
 	pools.Get("/", func(c *fiber.Ctx) error {
@@ -35,16 +40,29 @@ func SetupStorageRoutes(router fiber.Router, service *services.StorageService) {
 
 		pool, err := service.CreatePool(&req)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 
 		return c.Status(201).JSON(pool)
 	})
 
+	pools.Put("/:id", func(c *fiber.Ctx) error {
+		var req models.UpdatePoolRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		result, err := service.UpdatePool(c.Params("id"), &req)
+		if err != nil {
+			return membershipError(c, err)
+		}
+		return c.JSON(result)
+	})
+
 	pools.Delete("/:id", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if err := service.DeletePool(id); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.JSON(fiber.Map{"message": "Pool deleted successfully"})
 	})
@@ -52,7 +70,7 @@ func SetupStorageRoutes(router fiber.Router, service *services.StorageService) {
 	pools.Post("/:id/start", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if err := service.StartPool(id); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 		
 		pool, _ := service.GetPool(id)
@@ -62,13 +80,30 @@ func SetupStorageRoutes(router fiber.Router, service *services.StorageService) {
 	pools.Post("/:id/stop", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if err := service.StopPool(id); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 		
 		pool, _ := service.GetPool(id)
 		return c.JSON(pool)
 	})
 
+	pools.Get("/:id/logs", func(c *fiber.Ctx) error {
+		logs, err := service.GetPoolLogs(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"lines": logs})
+	})
+
+	pools.Get("/:id/crypt-keys", func(c *fiber.Ctx) error {
+		keys, err := service.ExportCryptKeys(c.Params("id"))
+		if err != nil {
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(keys)
+	})
+
 	pools.Post("/:id/accounts", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		var req struct {
@@ -79,20 +114,36 @@ func SetupStorageRoutes(router fiber.Router, service *services.StorageService) {
 		}
 
 		if err := service.AddAccountToPool(id, req.AccountID); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		return c.JSON(fiber.Map{"message": "Account added to pool"})
+		pool, _ := service.GetPool(id)
+		return c.JSON(fiber.Map{"message": "Account added to pool", "pool": pool})
 	})
 
 	pools.Delete("/:id/accounts/:accountId", func(c *fiber.Ctx) error {
 		poolId := c.Params("id")
 		accountId := c.Params("accountId")
 
-		if err := service.RemoveAccountFromPool(poolId, accountId); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		if err := service.RemoveAccountFromPool(poolId, accountId, c.QueryBool("force")); err != nil {
+			return membershipError(c, err)
 		}
 
-		return c.JSON(fiber.Map{"message": "Account removed from pool"})
+		pool, _ := service.GetPool(poolId)
+		return c.JSON(fiber.Map{"message": "Account removed from pool", "pool": pool})
 	})
 }
+
+// membershipError answers a failed membership change, listing the files that
+// kept an account from being removed.
+func membershipError(c *fiber.Ctx, err error) error {
+	var uniqueErr *services.UniqueFilesError
+	if errors.As(err, &uniqueErr) {
+		return c.Status(409).JSON(fiber.Map{
+			"error":        err.Error(),
+			"unique_files": uniqueErr.Count,
+			"files":        uniqueErr.Files,
+		})
+	}
+	return c.Status(errorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
 
@@ -24,6 +25,10 @@ func InitDB() (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
 	log.Println("Database initialized successfully")
 	return db, nil
 }
@@ -35,9 +40,19 @@ func createTables(db *sql.DB) error {
 		name TEXT NOT NULL,
 		type TEXT NOT NULL,
 		email TEXT NOT NULL,
+		auth_method TEXT DEFAULT 'oauth',
 		access_token TEXT,
 		refresh_token TEXT,
 		token_expiry DATETIME,
+		token_type TEXT,
+		service_account TEXT,
+		impersonate TEXT,
+		shared_drive_id TEXT,
+		parent_account_id TEXT,
+		credentials TEXT,
+		capacity INTEGER DEFAULT 0,
+		oauth_client_id TEXT,
+		oauth_client_secret TEXT,
 		quota_total INTEGER DEFAULT 0,
 		quota_used INTEGER DEFAULT 0,
 		status TEXT DEFAULT 'active',
@@ -49,11 +64,29 @@ func createTables(db *sql.DB) error {
 		id TEXT PRIMARY KEY,
 		name TEXT NOT NULL,
 		strategy TEXT NOT NULL,
+		action_policy TEXT,
+		create_policy TEXT,
+		search_policy TEXT,
+		cache_time INTEGER DEFAULT 120,
+		encryption TEXT,
+		filename_encryption TEXT,
+		crypt_password TEXT,
+		crypt_salt TEXT,
+		enable_compression BOOLEAN DEFAULT 0,
+		compression_level INTEGER DEFAULT -1,
+		compression_ram_cache_limit TEXT DEFAULT '20M',
 		enable_chunker BOOLEAN DEFAULT 0,
 		allow_large_files BOOLEAN DEFAULT 0,
 		chunk_size TEXT DEFAULT '100M',
+		chunker_mode TEXT DEFAULT 'upstream',
+		chunker_hash_type TEXT DEFAULT 'md5',
+		chunker_name_format TEXT DEFAULT '*.rclone_chunk.###',
+		chunker_fail_hard BOOLEAN DEFAULT 0,
 		mount_path TEXT,
 		status TEXT DEFAULT 'stopped',
+		auto_start BOOLEAN DEFAULT 0,
+		mount_profile TEXT DEFAULT 'default',
+		mount_options TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
@@ -62,6 +95,7 @@ func createTables(db *sql.DB) error {
 		pool_id TEXT NOT NULL,
 		account_id TEXT NOT NULL,
 		priority INTEGER DEFAULT 0,
+		mode TEXT DEFAULT 'rw',
 		PRIMARY KEY (pool_id, account_id),
 		FOREIGN KEY (pool_id) REFERENCES storage_pools(id) ON DELETE CASCADE,
 		FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE
@@ -81,11 +115,166 @@ func createTables(db *sql.DB) error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	CREATE TABLE IF NOT EXISTS oauth_states (
+		state TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS drain_jobs (
+		id TEXT PRIMARY KEY,
+		pool_id TEXT NOT NULL,
+		account_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		bytes_done INTEGER DEFAULT 0,
+		bytes_total INTEGER DEFAULT 0,
+		files_done INTEGER DEFAULT 0,
+		files_total INTEGER DEFAULT 0,
+		errors INTEGER DEFAULT 0,
+		hash_type TEXT,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME,
+		FOREIGN KEY (pool_id) REFERENCES storage_pools(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS rebalance_jobs (
+		id TEXT PRIMARY KEY,
+		pool_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		target_spread REAL NOT NULL,
+		bandwidth_limit TEXT,
+		accounts TEXT,
+		moves TEXT,
+		moves_done INTEGER DEFAULT 0,
+		moves_total INTEGER DEFAULT 0,
+		bytes_done INTEGER DEFAULT 0,
+		bytes_total INTEGER DEFAULT 0,
+		errors INTEGER DEFAULT 0,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME,
+		FOREIGN KEY (pool_id) REFERENCES storage_pools(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS pool_serves (
+		id TEXT PRIMARY KEY,
+		pool_id TEXT NOT NULL,
+		protocol TEXT NOT NULL,
+		bind_address TEXT NOT NULL,
+		port INTEGER NOT NULL UNIQUE,
+		username TEXT,
+		password TEXT,
+		read_only BOOLEAN DEFAULT 0,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (pool_id) REFERENCES storage_pools(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS drift_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pool_id TEXT,
+		kind TEXT NOT NULL,
+		message TEXT NOT NULL,
+		action TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_accounts_email ON accounts(email);
 	CREATE INDEX IF NOT EXISTS idx_accounts_status ON accounts(status);
 	CREATE INDEX IF NOT EXISTS idx_storage_pools_status ON storage_pools(status);
+	CREATE INDEX IF NOT EXISTS idx_drift_events_created ON drift_events(created_at);
+	CREATE INDEX IF NOT EXISTS idx_drain_jobs_pool ON drain_jobs(pool_id);
+	CREATE INDEX IF NOT EXISTS idx_rebalance_jobs_pool ON rebalance_jobs(pool_id);
+	CREATE INDEX IF NOT EXISTS idx_pool_serves_pool ON pool_serves(pool_id);
 	`
 
 	_, err := db.Exec(schema)
 	return err
 }
+
+// Columns added after the first release. CREATE TABLE IF NOT EXISTS leaves
+// existing tables alone, so these are added to older databases here.
+var migrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"accounts", "token_type", "TEXT"},
+	{"accounts", "auth_method", "TEXT DEFAULT 'oauth'"},
+	{"accounts", "service_account", "TEXT"},
+	{"accounts", "impersonate", "TEXT"},
+	{"accounts", "shared_drive_id", "TEXT"},
+	{"accounts", "parent_account_id", "TEXT"},
+	{"accounts", "credentials", "TEXT"},
+	{"accounts", "capacity", "INTEGER DEFAULT 0"},
+	{"accounts", "oauth_client_id", "TEXT"},
+	{"accounts", "oauth_client_secret", "TEXT"},
+	{"storage_pools", "auto_start", "BOOLEAN DEFAULT 0"},
+	{"pool_accounts", "mode", "TEXT DEFAULT 'rw'"},
+	{"storage_pools", "action_policy", "TEXT"},
+	{"storage_pools", "create_policy", "TEXT"},
+	{"storage_pools", "search_policy", "TEXT"},
+	{"storage_pools", "cache_time", "INTEGER DEFAULT 120"},
+	{"storage_pools", "encryption", "TEXT"},
+	{"storage_pools", "filename_encryption", "TEXT"},
+	{"storage_pools", "crypt_password", "TEXT"},
+	{"storage_pools", "crypt_salt", "TEXT"},
+	{"storage_pools", "enable_compression", "BOOLEAN DEFAULT 0"},
+	{"storage_pools", "compression_level", "INTEGER DEFAULT -1"},
+	{"storage_pools", "compression_ram_cache_limit", "TEXT DEFAULT '20M'"},
+	{"storage_pools", "chunker_mode", "TEXT DEFAULT 'upstream'"},
+	{"storage_pools", "chunker_hash_type", "TEXT DEFAULT 'md5'"},
+	{"storage_pools", "chunker_name_format", "TEXT DEFAULT '*.rclone_chunk.###'"},
+	{"storage_pools", "chunker_fail_hard", "BOOLEAN DEFAULT 0"},
+	{"storage_pools", "mount_profile", "TEXT DEFAULT 'default'"},
+	{"storage_pools", "mount_options", "TEXT"},
+}
+
+func migrate(db *sql.DB) error {
+	for _, m := range migrations {
+		exists, err := columnExists(db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", m.table, m.column, err)
+		}
+	}
+	return nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
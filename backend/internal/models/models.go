@@ -1,34 +1,136 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Account struct {
 	ID           string    `json:"id"`
 	Name         string    `json:"name"`
-	Type         string    `json:"type"` // google, microsoft
+	Type         string    `json:"type"` // provider ID, see internal/providers
 	Email        string    `json:"email"`
+	AuthMethod   string    `json:"auth_method"` // oauth, service_account, credentials, imported
 	AccessToken  string    `json:"-"`
 	RefreshToken string    `json:"-"`
+	TokenType    string    `json:"-"`
 	TokenExpiry  time.Time `json:"token_expiry"`
 	QuotaTotal   int64     `json:"quota_total"`
 	QuotaUsed    int64     `json:"quota_used"`
-	Status       string    `json:"status"` // active, inactive, error
+	Capacity     int64     `json:"capacity,omitempty"` // configured size for backends without a quota API
+	Status       string    `json:"status"`             // active, inactive, error
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Google service accounts and shared drives
+	ServiceAccountJSON string `json:"-"`
+	Impersonate        string `json:"impersonate,omitempty"`
+	SharedDriveID      string `json:"shared_drive_id,omitempty"`
+	ParentAccountID    string `json:"parent_account_id,omitempty"` // account a shared drive was registered from
+
+	// S3, WebDAV and SFTP: the backend's credential payload as JSON
+	Credentials string `json:"-"`
+
+	// OAuth client of tokens imported from an rclone.conf; empty means
+	// rclone's built-in client
+	ClientID     string `json:"-"`
+	ClientSecret string `json:"-"`
+
+	// Only set on the members of a pool: rw, or ro while being drained
+	PoolMode string `json:"pool_mode,omitempty"`
+}
+
+// S3Credentials configure an S3-compatible bucket (AWS, MinIO, ...).
+type S3Credentials struct {
+	Provider        string `json:"provider,omitempty"` // rclone s3 provider, default Other
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	Bucket          string `json:"bucket"`
+	Path            string `json:"path,omitempty"` // prefix inside the bucket
+}
+
+// WebDAVCredentials configure a WebDAV share (Nextcloud, ownCloud, ...).
+type WebDAVCredentials struct {
+	URL      string `json:"url"`
+	Vendor   string `json:"vendor,omitempty"` // nextcloud, owncloud, sharepoint, other
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// SFTPCredentials configure an SFTP server; either a password or a private
+// key is required.
+type SFTPCredentials struct {
+	Host                 string `json:"host"`
+	Port                 int    `json:"port,omitempty"`
+	User                 string `json:"user"`
+	Password             string `json:"password,omitempty"`
+	PrivateKey           string `json:"private_key,omitempty"` // PEM
+	PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty"`
+	Path                 string `json:"path,omitempty"` // directory to use, default the login directory
 }
 
 type StoragePool struct {
-	ID              string    `json:"id"`
-	Name            string    `json:"name"`
-	Strategy        string    `json:"strategy"` // union, eplus, epall, epff
-	EnableChunker   bool      `json:"enable_chunker"`
-	AllowLargeFiles bool      `json:"allow_large_files"`
-	ChunkSize       string    `json:"chunk_size"`
-	MountPath       string    `json:"mount_path"`
-	Status          string    `json:"status"` // stopped, starting, running, error
-	Accounts        []Account `json:"accounts,omitempty"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Strategy     string `json:"strategy"` // union, eplus, epff, mirror or custom
+	ActionPolicy string `json:"action_policy"`
+	CreatePolicy string `json:"create_policy"`
+	SearchPolicy string `json:"search_policy"`
+	CacheTime    int    `json:"cache_time"` // seconds the union caches quotas for
+	// Encryption wraps the union ("union") or each member ("upstream") in an
+	// rclone crypt remote; empty means files are stored as they are.
+	Encryption         string `json:"encryption,omitempty"`
+	FilenameEncryption string `json:"filename_encryption,omitempty"` // standard, obfuscate or off
+	CryptPassword      string `json:"-"`
+	CryptSalt          string `json:"-"`
+	// EnableCompression gzips files in an rclone compress remote on top of
+	// the pool, above any encryption.
+	EnableCompression        bool   `json:"enable_compression"`
+	CompressionLevel         int    `json:"compression_level"`           // gzip level, -2 to 9
+	CompressionRAMCacheLimit string `json:"compression_ram_cache_limit"` // files up to this size are compressed in memory
+	EnableChunker            bool   `json:"enable_chunker"`
+	AllowLargeFiles          bool   `json:"allow_large_files"`
+	ChunkSize                string `json:"chunk_size"`
+	// ChunkerMode wraps each member in a chunker ("upstream"), keeping the
+	// chunks of a file together, or the union ("union"), so they can spread
+	// over members and files can outgrow any one account.
+	ChunkerMode       string      `json:"chunker_mode"`
+	ChunkerHashType   string      `json:"chunker_hash_type"`   // none, md5, sha1, md5all, sha1all, md5quick or sha1quick
+	ChunkerNameFormat string      `json:"chunker_name_format"` // e.g. *.rclone_chunk.###
+	ChunkerFailHard   bool        `json:"chunker_fail_hard"`   // refuse to list directories with broken chunks
+	MountPath         string      `json:"mount_path"`
+	Status            string      `json:"status"`     // stopped, starting, running, error
+	AutoStart         bool        `json:"auto_start"` // remount after restarts and lost mounts
+	Accounts          []Account   `json:"accounts,omitempty"`
+	Serves            []PoolServe `json:"serves,omitempty"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+
+	// MountProfile names the preset of mount options the pool is mounted
+	// with; MountOptions overrides single options of it.
+	MountProfile string       `json:"mount_profile"`
+	MountOptions MountOptions `json:"mount_options"`
+}
+
+// MountOptions tune the VFS layer and FUSE mount of a pool. Empty fields
+// are left to the profile, or to rclone's defaults. Sizes and durations use
+// rclone's syntax, e.g. 10G and 72h.
+type MountOptions struct {
+	CacheMode    string `json:"cache_mode,omitempty"` // off, minimal, writes or full
+	CacheMaxSize string `json:"cache_max_size,omitempty"`
+	CacheMaxAge  string `json:"cache_max_age,omitempty"`
+	BufferSize   string `json:"buffer_size,omitempty"`
+	ReadAhead    string `json:"read_ahead,omitempty"`
+	DirCacheTime string `json:"dir_cache_time,omitempty"`
+	UID          *int   `json:"uid,omitempty"`
+	GID          *int   `json:"gid,omitempty"`
+	Umask        string `json:"umask,omitempty"` // octal, e.g. 002
+	PollInterval string `json:"poll_interval,omitempty"`
+	// BwLimit limits the mount's transfers, e.g. 10M, or 5M:20M for
+	// upload:download
+	BwLimit string `json:"bw_limit,omitempty"`
 }
 
 type PoolAccount struct {
@@ -39,9 +141,12 @@ type PoolAccount struct {
 
 type OAuthConfig struct {
 	Provider     string    `json:"provider"`
+	Label        string    `json:"label"`
 	ClientID     string    `json:"client_id"`
 	ClientSecret string    `json:"-"`
 	RedirectURI  string    `json:"redirect_uri"`
+	Configured   bool      `json:"configured"`
+	Source       string    `json:"source,omitempty"` // database, environment
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
@@ -55,6 +160,7 @@ type StorageStats struct {
 
 type AccountStats struct {
 	AccountID     string  `json:"account_id"`
+	SharedDriveID string  `json:"shared_drive_id,omitempty"`
 	Name          string  `json:"name"`
 	Email         string  `json:"email"`
 	Type          string  `json:"type"`
@@ -76,24 +182,258 @@ type PoolStats struct {
 	AccountCount  int     `json:"account_count"`
 }
 
+// PoolSize compares what a pool holds with what its members store for it.
+// The stored size includes encryption overhead, the savings of compression
+// and every copy a mirroring pool keeps.
+type PoolSize struct {
+	PoolID       string    `json:"pool_id"`
+	Files        int64     `json:"files"`
+	LogicalBytes int64     `json:"logical_bytes"`
+	StoredBytes  int64     `json:"stored_bytes"`
+	Ratio        float64   `json:"ratio"` // stored / logical, 0 for an empty pool
+	MeasuredAt   time.Time `json:"measured_at"`
+}
+
 type CreateAccountRequest struct {
-	Name  string `json:"name"`
-	Type  string `json:"type"` // google, microsoft
-	Email string `json:"email"`
-	Token string `json:"token,omitempty"` // Manual token
+	Name         string    `json:"name"`
+	Type         string    `json:"type"` // provider ID, see internal/providers
+	Email        string    `json:"email"`
+	Token        string    `json:"token,omitempty"` // Manual token: access token or `rclone authorize` JSON
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	TokenExpiry  time.Time `json:"token_expiry,omitempty"`
+
+	// Non-OAuth backends (s3, webdav, sftp): *Credentials matching Type
+	Credentials json.RawMessage `json:"credentials,omitempty"`
+	Capacity    int64           `json:"capacity,omitempty"` // bytes; used when the backend reports no quota
+}
+
+type CreateServiceAccountRequest struct {
+	Name               string          `json:"name"`
+	ServiceAccountJSON json.RawMessage `json:"service_account_json"`
+	Impersonate        string          `json:"impersonate,omitempty"`     // user to act as (domain-wide delegation)
+	SharedDriveID      string          `json:"shared_drive_id,omitempty"` // root the remote at a shared drive
+}
+
+type SharedDrive struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AccountID string `json:"account_id,omitempty"` // set once registered as an account
+}
+
+type AddSharedDrivesRequest struct {
+	DriveIDs []string `json:"drive_ids"`
+	Capacity int64    `json:"capacity,omitempty"` // bytes per drive; shared drives report no quota
+}
+
+type ImportConfigRequest struct {
+	Config   string   `json:"config"`             // rclone.conf contents
+	Password string   `json:"password,omitempty"` // for encrypted configs
+	Remotes  []string `json:"remotes,omitempty"`  // remotes to import
 }
 
+// ImportRemote describes one remote of an imported rclone.conf.
+type ImportRemote struct {
+	Remote     string `json:"remote"`
+	RcloneType string `json:"rclone_type"`
+	Type       string `json:"type,omitempty"` // account type it maps to
+	Email      string `json:"email,omitempty"`
+	Status     string `json:"status"` // importable, unsupported, exists, imported, failed
+	Reason     string `json:"reason,omitempty"`
+	AccountID  string `json:"account_id,omitempty"`
+}
+
+// CreatePoolRequest creates a pool. Strategy picks a preset of union
+// policies; policies given explicitly override the preset's.
 type CreatePoolRequest struct {
-	Name            string   `json:"name"`
-	Strategy        string   `json:"strategy"`
-	EnableChunker   bool     `json:"enable_chunker"`
-	AllowLargeFiles bool     `json:"allow_large_files"`
-	ChunkSize       string   `json:"chunk_size"`
-	AccountIDs      []string `json:"account_ids"`
+	Name                     string   `json:"name"`
+	Strategy                 string   `json:"strategy"`
+	ActionPolicy             string   `json:"action_policy"`
+	CreatePolicy             string   `json:"create_policy"`
+	SearchPolicy             string   `json:"search_policy"`
+	CacheTime                int      `json:"cache_time"`
+	Encryption               string   `json:"encryption"`          // union or upstream, empty for none
+	FilenameEncryption       string   `json:"filename_encryption"` // default standard
+	EnableCompression        bool     `json:"enable_compression"`
+	CompressionLevel         *int     `json:"compression_level"`           // default -1, gzip's own default
+	CompressionRAMCacheLimit string   `json:"compression_ram_cache_limit"` // default 20M
+	EnableChunker            bool     `json:"enable_chunker"`
+	AllowLargeFiles          bool     `json:"allow_large_files"`
+	ChunkSize                string   `json:"chunk_size"`
+	ChunkerMode              string   `json:"chunker_mode"`        // default upstream
+	ChunkerHashType          string   `json:"chunker_hash_type"`   // default md5
+	ChunkerNameFormat        string   `json:"chunker_name_format"` // default *.rclone_chunk.###
+	ChunkerFailHard          bool     `json:"chunker_fail_hard"`
+	AutoStart                bool     `json:"auto_start"`
+	AccountIDs               []string `json:"account_ids"`
+	// Mount options: a profile, default "default", and overrides of it
+	MountProfile string       `json:"mount_profile"`
+	MountOptions MountOptions `json:"mount_options"`
+}
+
+// UpdatePoolRequest changes the settings of a pool. Nil fields are left as
+// they are; AccountIDs replaces the members, in priority order.
+type UpdatePoolRequest struct {
+	Name                     *string  `json:"name,omitempty"`
+	Strategy                 *string  `json:"strategy,omitempty"`
+	ActionPolicy             *string  `json:"action_policy,omitempty"`
+	CreatePolicy             *string  `json:"create_policy,omitempty"`
+	SearchPolicy             *string  `json:"search_policy,omitempty"`
+	CacheTime                *int     `json:"cache_time,omitempty"`
+	CompressionLevel         *int     `json:"compression_level,omitempty"`
+	CompressionRAMCacheLimit *string  `json:"compression_ram_cache_limit,omitempty"`
+	EnableChunker            *bool    `json:"enable_chunker,omitempty"`
+	AllowLargeFiles          *bool    `json:"allow_large_files,omitempty"`
+	ChunkSize                *string  `json:"chunk_size,omitempty"`
+	ChunkerMode              *string  `json:"chunker_mode,omitempty"`
+	ChunkerHashType          *string  `json:"chunker_hash_type,omitempty"`
+	ChunkerNameFormat        *string  `json:"chunker_name_format,omitempty"`
+	ChunkerFailHard          *bool    `json:"chunker_fail_hard,omitempty"`
+	AutoStart                *bool    `json:"auto_start,omitempty"`
+	AccountIDs               []string `json:"account_ids,omitempty"`
+	// MountProfile switches the preset; MountOptions replaces all overrides
+	MountProfile *string       `json:"mount_profile,omitempty"`
+	MountOptions *MountOptions `json:"mount_options,omitempty"`
+	// Force removes members even if they hold files no other member has
+	Force bool `json:"force,omitempty"`
+}
+
+type UpdatePoolResult struct {
+	Pool    *StoragePool `json:"pool"`
+	Changed []string     `json:"changed"` // fields that changed
+	// Changes that only take effect on a new mount. They were applied by
+	// remounting if the pool was running.
+	RemountRequired []string `json:"remount_required"`
+	Remounted       bool     `json:"remounted"`
+}
+
+// DrainJob moves the files of one pool member to the others so it can leave
+// the pool without data loss.
+type DrainJob struct {
+	ID         string     `json:"id"`
+	PoolID     string     `json:"pool_id"`
+	AccountID  string     `json:"account_id"`
+	Status     string     `json:"status"` // copying, verifying, deleting, completed, failed, cancelled
+	BytesDone  int64      `json:"bytes_done"`
+	BytesTotal int64      `json:"bytes_total"`
+	FilesDone  int64      `json:"files_done"`
+	FilesTotal int64      `json:"files_total"`
+	Errors     int64      `json:"errors"`
+	HashType   string     `json:"hash_type,omitempty"` // hash the copy was verified with; empty means size only
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// PoolServe exposes a pool over a network protocol with `rclone serve`. It
+// runs whenever the pool is mounted.
+type PoolServe struct {
+	ID          string    `json:"id"`
+	PoolID      string    `json:"pool_id"`
+	Protocol    string    `json:"protocol"` // webdav, sftp, ftp, http or s3
+	BindAddress string    `json:"bind_address"`
+	Port        int       `json:"port"`
+	Username    string    `json:"username,omitempty"` // the access key for s3
+	Password    string    `json:"-"`                  // the secret key for s3
+	ReadOnly    bool      `json:"read_only"`
+	Status      string    `json:"status"`          // running, stopped or error
+	Error       string    `json:"error,omitempty"` // why it last failed to start
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CreateServeRequest adds a serve to a pool. A zero port is allocated from
+// the serve port range.
+type CreateServeRequest struct {
+	Protocol    string `json:"protocol"`
+	BindAddress string `json:"bind_address"` // default all interfaces
+	Port        int    `json:"port"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	ReadOnly    bool   `json:"read_only"`
+}
+
+// CryptKeys is what it takes to read an encrypted pool's files without this
+// service: the passwords and the pool's remotes in rclone.conf syntax.
+type CryptKeys struct {
+	PoolID             string `json:"pool_id"`
+	Encryption         string `json:"encryption"`
+	FilenameEncryption string `json:"filename_encryption"`
+	Password           string `json:"password"`
+	Salt               string `json:"salt"`
+	Config             string `json:"config,omitempty"` // empty while the pool has no accounts
+}
+
+// RebalanceJob moves files between the members of a pool until their fill
+// levels are within TargetSpread percentage points of each other. It is
+// planned first and only moves files once started.
+type RebalanceJob struct {
+	ID             string             `json:"id"`
+	PoolID         string             `json:"pool_id"`
+	Status         string             `json:"status"` // planned, running, completed, failed, cancelled
+	TargetSpread   float64            `json:"target_spread"`
+	BandwidthLimit string             `json:"bandwidth_limit,omitempty"`
+	Accounts       []RebalanceAccount `json:"accounts"`
+	Moves          []RebalanceMove    `json:"moves,omitempty"`
+	MovesDone      int                `json:"moves_done"`
+	MovesTotal     int                `json:"moves_total"`
+	BytesDone      int64              `json:"bytes_done"`
+	BytesTotal     int64              `json:"bytes_total"`
+	Errors         int                `json:"errors"`
+	Error          string             `json:"error,omitempty"` // last failure
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+	FinishedAt     *time.Time         `json:"finished_at,omitempty"`
+}
+
+// RebalanceAccount is one member's usage when a rebalance was planned and
+// what it is expected to be after the planned moves.
+type RebalanceAccount struct {
+	AccountID      string  `json:"account_id"`
+	Name           string  `json:"name"`
+	QuotaTotal     int64   `json:"quota_total"`
+	QuotaUsed      int64   `json:"quota_used"`
+	PoolBytes      int64   `json:"pool_bytes"` // bytes of pool files on the account
+	PoolFiles      int     `json:"pool_files"`
+	UsagePercent   float64 `json:"usage_percent"`
+	PlannedPercent float64 `json:"planned_percent"`
+	Skipped        string  `json:"skipped,omitempty"` // why the account isn't balanced
+}
+
+type RebalanceMove struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	From string `json:"from"` // account IDs
+	To   string `json:"to"`
+}
+
+type RebalanceRequest struct {
+	TargetSpread   float64 `json:"target_spread"`   // percentage points, default 10
+	BandwidthLimit string  `json:"bandwidth_limit"` // rclone --bwlimit syntax, e.g. 10M
+}
+
+// DriftEvent records a difference the reconciler found between a pool's
+// stored state and what is actually mounted or configured, and what it did
+// about it.
+type DriftEvent struct {
+	ID        int64     `json:"id"`
+	PoolID    string    `json:"pool_id,omitempty"`
+	Kind      string    `json:"kind"` // mount_lost, unexpected_mount, stuck_starting, orphan_remote, orphan_mount_dir
+	Message   string    `json:"message"`
+	Action    string    `json:"action"` // remounted, marked_stopped, marked_running, removed, failed
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SaveOAuthConfigRequest struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"` // empty keeps the stored secret
+	RedirectURI  string `json:"redirect_uri,omitempty"`
 }
 
 type OAuthStartRequest struct {
-	Provider string `json:"provider"` // google, microsoft
+	Provider string `json:"provider"` // provider ID, see internal/providers
 }
 
 type OAuthCallbackRequest struct {
@@ -0,0 +1,207 @@
+// Package providers is the registry of cloud storage services accounts can
+// be added from: how to authorize against them, how to find out whose
+// account a token belongs to, and which rclone backend serves them.
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+)
+
+type Provider struct {
+	ID         string // account type, e.g. "google"
+	Label      string
+	RcloneType string // rclone backend the remote is created with
+
+	// OAuth settings; providers without an Endpoint take a credential
+	// payload instead (see models.S3Credentials and friends).
+	Scopes   []string
+	Endpoint oauth2.Endpoint
+	// AuthParams are extra query parameters for the consent URL, mostly
+	// whatever the provider needs to hand out a refresh token.
+	AuthParams map[string]string
+
+	// IdentityURL is queried with a fresh token to find out whose account
+	// it is; IdentityMethod defaults to GET.
+	IdentityURL    string
+	IdentityMethod string
+	ParseIdentity  func(body []byte) (email, name string, err error)
+}
+
+var registry = []*Provider{
+	{
+		ID:         "google",
+		Label:      "Google",
+		RcloneType: "drive",
+		Scopes:     []string{"https://www.googleapis.com/auth/drive"},
+		Endpoint:   google.Endpoint,
+		AuthParams: map[string]string{"access_type": "offline", "prompt": "consent"},
+
+		IdentityURL:   "https://www.googleapis.com/drive/v3/about?fields=user",
+		ParseIdentity: parseGoogleIdentity,
+	},
+	{
+		ID:         "microsoft",
+		Label:      "Microsoft",
+		RcloneType: "onedrive",
+		Scopes:     []string{"https://graph.microsoft.com/Files.ReadWrite.All", "https://graph.microsoft.com/User.Read", "offline_access"},
+		Endpoint:   microsoft.AzureADEndpoint("common"),
+		AuthParams: map[string]string{"prompt": "consent"},
+
+		IdentityURL:   "https://graph.microsoft.com/v1.0/me",
+		ParseIdentity: parseMicrosoftIdentity,
+	},
+	{
+		ID:         "dropbox",
+		Label:      "Dropbox",
+		RcloneType: "dropbox",
+		Scopes: []string{"account_info.read", "files.metadata.read", "files.metadata.write",
+			"files.content.read", "files.content.write"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.dropbox.com/oauth2/authorize",
+			TokenURL: "https://api.dropboxapi.com/oauth2/token",
+		},
+		// Without this Dropbox only issues short-lived access tokens
+		AuthParams: map[string]string{"token_access_type": "offline"},
+
+		IdentityURL:    "https://api.dropboxapi.com/2/users/get_current_account",
+		IdentityMethod: "POST",
+		ParseIdentity:  parseDropboxIdentity,
+	},
+	{
+		ID:         "box",
+		Label:      "Box",
+		RcloneType: "box",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://account.box.com/api/oauth2/authorize",
+			TokenURL: "https://api.box.com/oauth2/token",
+		},
+
+		IdentityURL:   "https://api.box.com/2.0/users/me",
+		ParseIdentity: parseBoxIdentity,
+	},
+	{
+		// Only US-region accounts: EU accounts live on eapi.pcloud.com
+		ID:         "pcloud",
+		Label:      "pCloud",
+		RcloneType: "pcloud",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   "https://my.pcloud.com/oauth2/authorize",
+			TokenURL:  "https://api.pcloud.com/oauth2_token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+
+		IdentityURL:   "https://api.pcloud.com/userinfo",
+		ParseIdentity: parsePCloudIdentity,
+	},
+	{ID: "s3", Label: "S3", RcloneType: "s3"},
+	{ID: "webdav", Label: "WebDAV", RcloneType: "webdav"},
+	{ID: "sftp", Label: "SFTP", RcloneType: "sftp"},
+}
+
+// Get returns the provider for an account type.
+func Get(id string) (*Provider, bool) {
+	for _, p := range registry {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// All returns every supported provider in display order.
+func All() []*Provider {
+	return append([]*Provider(nil), registry...)
+}
+
+// UsesOAuth reports whether accounts are added through the OAuth consent
+// flow rather than with credentials.
+func (p *Provider) UsesOAuth() bool {
+	return p.Endpoint.AuthURL != ""
+}
+
+// AuthCodeOptions turns AuthParams into options for oauth2.Config.AuthCodeURL.
+func (p *Provider) AuthCodeOptions() []oauth2.AuthCodeOption {
+	opts := make([]oauth2.AuthCodeOption, 0, len(p.AuthParams))
+	for key, value := range p.AuthParams {
+		opts = append(opts, oauth2.SetAuthURLParam(key, value))
+	}
+	return opts
+}
+
+func parseGoogleIdentity(body []byte) (string, string, error) {
+	var about struct {
+		User struct {
+			DisplayName  string `json:"displayName"`
+			EmailAddress string `json:"emailAddress"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal(body, &about); err != nil {
+		return "", "", err
+	}
+	return about.User.EmailAddress, about.User.DisplayName, nil
+}
+
+func parseMicrosoftIdentity(body []byte) (string, string, error) {
+	var me struct {
+		DisplayName       string `json:"displayName"`
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	if err := json.Unmarshal(body, &me); err != nil {
+		return "", "", err
+	}
+	// Personal accounts often have no mail, only the UPN
+	if me.Mail == "" {
+		return me.UserPrincipalName, me.DisplayName, nil
+	}
+	return me.Mail, me.DisplayName, nil
+}
+
+func parseDropboxIdentity(body []byte) (string, string, error) {
+	var account struct {
+		Email string `json:"email"`
+		Name  struct {
+			DisplayName string `json:"display_name"`
+		} `json:"name"`
+	}
+	if err := json.Unmarshal(body, &account); err != nil {
+		return "", "", err
+	}
+	return account.Email, account.Name.DisplayName, nil
+}
+
+func parseBoxIdentity(body []byte) (string, string, error) {
+	var user struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return "", "", err
+	}
+	return user.Login, user.Name, nil
+}
+
+func parsePCloudIdentity(body []byte) (string, string, error) {
+	// pCloud answers 200 with a non-zero result code on failure
+	var info struct {
+		Result int    `json:"result"`
+		Error  string `json:"error"`
+		Email  string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", "", err
+	}
+	if info.Result != 0 {
+		if info.Error == "" {
+			return "", "", fmt.Errorf("pCloud error %d", info.Result)
+		}
+		return "", "", errors.New(info.Error)
+	}
+	return info.Email, "", nil
+}
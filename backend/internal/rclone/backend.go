@@ -0,0 +1,69 @@
+package rclone
+
+import (
+	"context"
+	"pooled-storage/internal/models"
+)
+
+// StorageBackend is everything the services layer needs from rclone.
+// Manager talks to a real rclone rc daemon; MemoryBackend simulates one.
+type StorageBackend interface {
+	AddRemote(account *models.Account) error
+	RemoveRemote(accountID, accountType string) error
+	UpdateToken(account *models.Account) error
+	TestConnection(accountID, accountType string) error
+	GetQuota(accountID, accountType string) (int64, int64, error)
+	GetUsage(accountID, accountType string) (int64, error)
+	ListSharedDrives(accountID, accountType string) ([]SharedDrive, error)
+	// ListFiles lists every file on one member of a pool as the pool sees
+	// it, i.e. through the pool's chunker when it has one.
+	ListFiles(pool *models.StoragePool, account *models.Account) ([]ListItem, error)
+
+	CreateUnion(pool *models.StoragePool) error
+	DeleteUnion(poolID string) error
+	// DrainMember moves the files of a member to the pool's other writable
+	// members, reporting progress as it goes.
+	DrainMember(ctx context.Context, pool *models.StoragePool, account *models.Account, progress func(DrainProgress)) (*DrainResult, error)
+	// MoveFile moves one file, by its path in the pool, between two members.
+	MoveFile(ctx context.Context, pool *models.StoragePool, from, to *models.Account, path string) error
+	// SetBandwidthLimit sets the limit on transfers rclone runs for us, such
+	// as DrainMember and MoveFile, and returns the previous limit.
+	SetBandwidthLimit(rate string) (string, error)
+	// PoolSize measures the logical size of a pool's files and the bytes its
+	// members store for them.
+	PoolSize(ctx context.Context, pool *models.StoragePool) (*PoolSize, error)
+	// PruneRemotes deletes the union remotes of pools not in poolIDs and the
+	// chunker remotes no union uses, returning the names it removed.
+	PruneRemotes(poolIDs []string) ([]string, error)
+
+	// Rebuild replaces all remotes with those of the given accounts and
+	// pools; PreviewConfig shows what that would change.
+	Rebuild(accounts []models.Account, pools []models.StoragePool) error
+	PreviewConfig(accounts []models.Account, pools []models.StoragePool) (*ConfigPreview, error)
+
+	MountPool(pool *models.StoragePool) error
+	UnmountPool(pool *models.StoragePool) error
+	IsMounted(path string) bool
+	// MountRestarting reports whether a pool's mount died and is being
+	// brought back by its supervisor.
+	MountRestarting(poolID string) bool
+	// MountLogs returns the recent output of a pool's mount.
+	MountLogs(poolID string) ([]string, error)
+	PoolMountPath(poolID string) string
+	// StartServe serves a mounted pool over a network protocol; StopServe
+	// stops it again.
+	StartServe(pool *models.StoragePool, serve *models.PoolServe) error
+	StopServe(serveID string) error
+	ServeRunning(serveID string) bool
+	// MountDirs lists the pool IDs that have a directory under the mount
+	// path; RemoveMountDir deletes one if it is empty.
+	MountDirs() ([]string, error)
+	RemoveMountDir(poolID string) error
+
+	Stats() (*Stats, error)
+}
+
+var (
+	_ StorageBackend = (*Manager)(nil)
+	_ StorageBackend = (*MemoryBackend)(nil)
+)
@@ -0,0 +1,135 @@
+package rclone
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ConfigSection is one remote of an rclone.conf file.
+type ConfigSection struct {
+	Name   string
+	Params map[string]string // includes "type"
+}
+
+func (s *ConfigSection) Type() string {
+	return s.Params["type"]
+}
+
+// ParseConfig reads the remotes of an rclone.conf file in file order. An
+// encrypted config is decrypted with pass first.
+func ParseConfig(data []byte, pass string) ([]ConfigSection, error) {
+	if isEncryptedConfig(data) {
+		if pass == "" {
+			return nil, fmt.Errorf("rclone config is encrypted, a password is required")
+		}
+		plaintext, err := decryptConfig(data, pass)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt rclone config: wrong password or corrupted file")
+		}
+		data = plaintext
+	}
+
+	var sections []ConfigSection
+	index := map[string]int{}
+	current := -1
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section header", lineNo)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty section name", lineNo)
+			}
+			// A repeated section continues the earlier one, as in rclone
+			if i, ok := index[name]; ok {
+				current = i
+				continue
+			}
+			index[name] = len(sections)
+			current = len(sections)
+			sections = append(sections, ConfigSection{Name: name, Params: map[string]string{}})
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		if current < 0 {
+			return nil, fmt.Errorf("line %d: key outside of a section", lineNo)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		sections[current].Params[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+// rclone obscures passwords in its config with AES-CTR under this fixed
+// key. It only keeps them from being read over someone's shoulder.
+var obscureKey = []byte{
+	0x9c, 0x93, 0x5b, 0x48, 0x73, 0x0a, 0x55, 0x4d,
+	0x6b, 0xfd, 0x7c, 0x63, 0xc8, 0x86, 0xa9, 0x2b,
+	0xd3, 0x90, 0x19, 0x8e, 0xb8, 0x12, 0x8a, 0xfb,
+	0xf4, 0xde, 0x16, 0x2b, 0x8b, 0x95, 0xf6, 0x38,
+}
+
+// cryptRand is the source of Obscure's IVs; tests replace it.
+var cryptRand io.Reader = rand.Reader
+
+// Obscure encodes a password the way rclone stores it in its config.
+func Obscure(plain string) (string, error) {
+	block, err := aes.NewCipher(obscureKey)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, aes.BlockSize+len(plain))
+	iv := buf[:aes.BlockSize]
+	if _, err := io.ReadFull(cryptRand, iv); err != nil {
+		return "", err
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(buf[aes.BlockSize:], []byte(plain))
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Reveal decodes a password obscured by rclone.
+func Reveal(obscured string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(obscured)
+	if err != nil {
+		return "", fmt.Errorf("obscured password is not valid base64: %w", err)
+	}
+	if len(data) < aes.BlockSize {
+		return "", fmt.Errorf("obscured password is too short")
+	}
+
+	block, err := aes.NewCipher(obscureKey)
+	if err != nil {
+		return "", err
+	}
+	iv, ciphertext := data[:aes.BlockSize], data[aes.BlockSize:]
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+	return string(plaintext), nil
+}
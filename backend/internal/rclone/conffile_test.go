@@ -0,0 +1,102 @@
+package rclone
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	data := []byte(`# rclone.conf
+[a]
+type = drive
+token = {"access_token":"x"}
+
+; comment
+[b]
+type = "webdav"
+url=https://example.com
+
+[a]
+team_drive = 0A
+`)
+	sections, err := ParseConfig(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfigSection{
+		{Name: "a", Params: map[string]string{"type": "drive", "token": `{"access_token":"x"}`, "team_drive": "0A"}},
+		{Name: "b", Params: map[string]string{"type": "webdav", "url": "https://example.com"}},
+	}
+	if !reflect.DeepEqual(sections, want) {
+		t.Errorf("ParseConfig = %+v, want %+v", sections, want)
+	}
+
+	encrypted, err := encryptConfig(data, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sections, err := ParseConfig(encrypted, "pass"); err != nil || !reflect.DeepEqual(sections, want) {
+		t.Errorf("ParseConfig of the encrypted config = %+v, %v", sections, err)
+	}
+	for _, pass := range []string{"", "wrong"} {
+		if _, err := ParseConfig(encrypted, pass); err == nil {
+			t.Errorf("ParseConfig of the encrypted config with password %q succeeded", pass)
+		}
+	}
+
+	for _, bad := range []string{"key = outside", "[a\ntype = drive", "[]", "[a]\nnot a pair"} {
+		if _, err := ParseConfig([]byte(bad), ""); err == nil {
+			t.Errorf("ParseConfig(%q) succeeded", bad)
+		}
+	}
+}
+
+// Vectors from rclone's fs/config/obscure tests.
+func TestReveal(t *testing.T) {
+	for _, tt := range []struct{ obscured, want string }{
+		{"YWFhYWFhYWFhYWFhYWFhYQ", ""},
+		{"YWFhYWFhYWFhYWFhYWFhYXMaGgIlEQ", "potato"},
+		{"YmJiYmJiYmJiYmJiYmJiYp3gcEWbAw", "potato"},
+	} {
+		got, err := Reveal(tt.obscured)
+		if err != nil {
+			t.Errorf("Reveal(%q): %v", tt.obscured, err)
+		} else if got != tt.want {
+			t.Errorf("Reveal(%q) = %q, want %q", tt.obscured, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"not base64!", "YWFh"} {
+		if _, err := Reveal(bad); err == nil {
+			t.Errorf("Reveal(%q) succeeded", bad)
+		}
+	}
+}
+
+// Vectors from rclone's fs/config/obscure tests, which fix the IV the same way.
+func TestObscure(t *testing.T) {
+	defer func(r io.Reader) { cryptRand = r }(cryptRand)
+
+	for _, tt := range []struct{ iv, plain, want string }{
+		{"aaaaaaaaaaaaaaaa", "", "YWFhYWFhYWFhYWFhYWFhYQ"},
+		{"aaaaaaaaaaaaaaaa", "potato", "YWFhYWFhYWFhYWFhYWFhYXMaGgIlEQ"},
+		{"bbbbbbbbbbbbbbbb", "potato", "YmJiYmJiYmJiYmJiYmJiYp3gcEWbAw"},
+	} {
+		cryptRand = strings.NewReader(tt.iv)
+		got, err := Obscure(tt.plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Obscure(%q) with IV %q = %q, want %q", tt.plain, tt.iv, got, tt.want)
+		}
+	}
+
+	// A short read of the IV is an error, not a weak IV
+	cryptRand = strings.NewReader("short")
+	if _, err := Obscure("potato"); err == nil {
+		t.Error("Obscure with a short IV succeeded")
+	}
+}
@@ -0,0 +1,640 @@
+package rclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/providers"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remotes maps remote name -> parameters (including "type"): the contents
+// of an rclone.conf.
+type remotes map[string]map[string]string
+
+// passwordOptions are the options rclone expects obscured in its config.
+var passwordOptions = map[string][]string{
+	"webdav": {"pass"},
+	"sftp":   {"pass", "key_file_pass"},
+	"crypt":  {"password", "password2"},
+}
+
+// secretOptions are redacted when a config is shown.
+var secretOptions = map[string]bool{
+	"token":                       true,
+	"client_secret":               true,
+	"pass":                        true,
+	"key_file_pass":               true,
+	"key_pem":                     true,
+	"secret_access_key":           true,
+	"service_account_credentials": true,
+	"password":                    true,
+	"password2":                   true,
+}
+
+// newRemote builds the section of one remote, obscuring its passwords.
+func newRemote(remoteType string, params map[string]string) (map[string]string, error) {
+	section := make(map[string]string, len(params)+1)
+	for k, v := range params {
+		section[k] = v
+	}
+	section["type"] = remoteType
+
+	for _, key := range passwordOptions[remoteType] {
+		if section[key] == "" {
+			continue
+		}
+		obscured, err := Obscure(section[key])
+		if err != nil {
+			return nil, err
+		}
+		section[key] = obscured
+	}
+	return section, nil
+}
+
+// accountRemotes returns the remotes of an account: usually one, two when
+// the backend remote is wrapped in an alias. saDir is where service-account
+// keys are written.
+func accountRemotes(account *models.Account, saDir string) (remotes, error) {
+	provider, ok := providers.Get(account.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported account type: %s", account.Type)
+	}
+	name := remoteName(account.ID, account.Type)
+
+	var params map[string]string
+	var root string
+	var err error
+	switch {
+	case account.Type == "google":
+		params, err = driveParams(account, saDir)
+	case account.AuthMethod == "credentials":
+		params, root, err = credentialParams(account)
+	default:
+		var token string
+		token, err = tokenJSON(account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode token: %w", err)
+		}
+		params = map[string]string{
+			"token": token,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Imported tokens keep refreshing through the client that issued them
+	if account.ClientID != "" {
+		params["client_id"] = account.ClientID
+		params["client_secret"] = account.ClientSecret
+	}
+
+	section, err := newRemote(provider.RcloneType, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remotes rooted below the backend's top level (an S3 bucket, an SFTP
+	// directory) are wrapped in an alias so the rest of the code can keep
+	// using "<name>:".
+	if root == "" {
+		return remotes{name: section}, nil
+	}
+	return remotes{
+		baseRemoteName(name): section,
+		name:                 {"type": "alias", "remote": baseRemoteName(name) + ":" + root},
+	}, nil
+}
+
+func driveParams(account *models.Account, saDir string) (map[string]string, error) {
+	params := map[string]string{
+		"scope": "drive",
+	}
+	if account.SharedDriveID != "" {
+		params["team_drive"] = account.SharedDriveID
+	}
+
+	if account.AuthMethod == "service_account" {
+		params["service_account_file"] = serviceAccountFile(saDir, account.ID)
+		if account.Impersonate != "" {
+			params["impersonate"] = account.Impersonate
+		}
+		return params, nil
+	}
+
+	token, err := tokenJSON(account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token: %w", err)
+	}
+	params["token"] = token
+	return params, nil
+}
+
+// serviceAccountFile is where the key of a service-account remote lives,
+// next to rclone.conf and readable only by us.
+func serviceAccountFile(saDir, accountID string) string {
+	return filepath.Join(saDir, accountID+".json")
+}
+
+func writeServiceAccountFile(saDir string, account *models.Account) error {
+	if err := os.MkdirAll(saDir, 0700); err != nil {
+		return fmt.Errorf("failed to create service account directory: %w", err)
+	}
+	if err := os.WriteFile(serviceAccountFile(saDir, account.ID), []byte(account.ServiceAccountJSON), 0600); err != nil {
+		return fmt.Errorf("failed to write service account file: %w", err)
+	}
+	return nil
+}
+
+// unionRemotes returns the union remote of a pool and the chunker, crypt and
+// compress remotes layered below or above it.
+func unionRemotes(pool *models.StoragePool) (remotes, error) {
+	if len(pool.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts in pool")
+	}
+
+	result := remotes{}
+	var upstreams []string
+	for i := range pool.Accounts {
+		account := &pool.Accounts[i]
+		if chunksUpstreams(pool) {
+			result[chunkerName(pool.ID, account.ID)] = chunkerParams(pool, remoteName(account.ID, account.Type)+":")
+		}
+
+		upstream := upstreamRemote(pool, account)
+		if pool.Encryption == "upstream" {
+			name := cryptName(pool.ID, account.ID)
+			crypt, err := cryptParams(pool, upstream)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = crypt
+			upstream = name + ":"
+		}
+		if account.PoolMode == "ro" {
+			// Still readable through the union, but nothing new is written
+			upstream += ":ro"
+		}
+		upstreams = append(upstreams, upstream)
+	}
+
+	top := fmt.Sprintf("union_%s", pool.ID)
+	result[top] = unionParams(pool, upstreams)
+	// Chunks of one file may land on different members
+	if pool.EnableChunker && !chunksUpstreams(pool) {
+		name := chunkerName(pool.ID, "")
+		result[name] = chunkerParams(pool, top+":")
+		top = name
+	}
+	if pool.Encryption == "union" {
+		crypt, err := cryptParams(pool, top+":")
+		if err != nil {
+			return nil, err
+		}
+		top = cryptName(pool.ID, "")
+		result[top] = crypt
+	}
+	// Above crypt: encrypted data doesn't compress
+	if pool.EnableCompression {
+		result[compressName(pool.ID)] = map[string]string{
+			"type":            "compress",
+			"remote":          top + ":",
+			"mode":            "gzip",
+			"level":           strconv.Itoa(pool.CompressionLevel),
+			"ram_cache_limit": pool.CompressionRAMCacheLimit,
+		}
+	}
+	return result, nil
+}
+
+func compressName(poolID string) string {
+	return fmt.Sprintf("compress_%s", poolID)
+}
+
+// cryptName is the crypt remote of an encrypted pool, or of one of its
+// members when each member is encrypted on its own.
+func cryptName(poolID, accountID string) string {
+	if accountID == "" {
+		return fmt.Sprintf("crypt_%s", poolID)
+	}
+	return fmt.Sprintf("crypt_%s_%s", poolID, accountID)
+}
+
+// layerPoolID returns the pool a chunker, crypt or compress remote name
+// belongs to.
+func layerPoolID(name string) (string, bool) {
+	for _, prefix := range []string{"chunk_", "crypt_", "compress_"} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			poolID, _, _ := strings.Cut(rest, "_")
+			return poolID, true
+		}
+	}
+	return "", false
+}
+
+func cryptParams(pool *models.StoragePool, remote string) (map[string]string, error) {
+	if pool.CryptPassword == "" || pool.CryptSalt == "" {
+		return nil, fmt.Errorf("pool %s is encrypted but has no keys", pool.ID)
+	}
+	return newRemote("crypt", map[string]string{
+		"remote":              remote,
+		"filename_encryption": pool.FilenameEncryption,
+		"password":            pool.CryptPassword,
+		"password2":           pool.CryptSalt,
+	})
+}
+
+// poolRemote is what a pool's mount serves: the top of its chain of
+// compress, crypt, chunker and union remotes.
+func poolRemote(pool *models.StoragePool) string {
+	switch {
+	case pool.EnableCompression:
+		return compressName(pool.ID) + ":"
+	case pool.Encryption == "union":
+		return cryptName(pool.ID, "") + ":"
+	case pool.EnableChunker && !chunksUpstreams(pool):
+		return chunkerName(pool.ID, "") + ":"
+	}
+	return fmt.Sprintf("union_%s:", pool.ID)
+}
+
+// PoolConfig renders the rclone.conf sections of a pool, keys included, so
+// its files can be read with plain rclone given the account remotes.
+func PoolConfig(pool *models.StoragePool) (string, error) {
+	r, err := unionRemotes(pool)
+	if err != nil {
+		return "", err
+	}
+	return string(r.render(false)), nil
+}
+
+// deletePool drops the union of a pool and the remotes layered on it.
+// Chunkers named before they were per pool are left to pruneChunkers.
+func (r remotes) deletePool(poolID string) {
+	delete(r, fmt.Sprintf("union_%s", poolID))
+	for name := range r {
+		if id, ok := layerPoolID(name); ok && id == poolID {
+			delete(r, name)
+		}
+	}
+}
+
+// chunkerName is the chunker remote of a pool that chunks above its union,
+// or of one of its members when each member is chunked on its own. The
+// pool is part of the name as an account can be in pools with different
+// chunk sizes.
+func chunkerName(poolID, accountID string) string {
+	if accountID == "" {
+		return fmt.Sprintf("chunk_%s", poolID)
+	}
+	return fmt.Sprintf("chunk_%s_%s", poolID, accountID)
+}
+
+// chunksUpstreams reports whether a pool wraps each member in a chunker
+// rather than the union as a whole.
+func chunksUpstreams(pool *models.StoragePool) bool {
+	return pool.EnableChunker && pool.ChunkerMode != "union"
+}
+
+func chunkerParams(pool *models.StoragePool, remote string) map[string]string {
+	params := map[string]string{
+		"type":       "chunker",
+		"remote":     remote,
+		"chunk_size": pool.ChunkSize,
+	}
+	// Left to rclone's defaults when unset
+	if pool.ChunkerHashType != "" {
+		params["hash_type"] = pool.ChunkerHashType
+	}
+	if pool.ChunkerNameFormat != "" {
+		params["name_format"] = pool.ChunkerNameFormat
+	}
+	if pool.ChunkerFailHard {
+		params["fail_hard"] = "true"
+	}
+	return params
+}
+
+// upstreamRemote is the named remote of a pool member in the union: its
+// chunker, or the account's own remote.
+func upstreamRemote(pool *models.StoragePool, account *models.Account) string {
+	if chunksUpstreams(pool) {
+		return chunkerName(pool.ID, account.ID) + ":"
+	}
+	return remoteName(account.ID, account.Type) + ":"
+}
+
+// UnionPolicies are the policies rclone's union backend accepts. Any of
+// them can be used for the action, create and search categories.
+var UnionPolicies = map[string]bool{
+	"all":    true,
+	"epall":  true,
+	"epff":   true,
+	"eplfs":  true,
+	"eplno":  true,
+	"eplus":  true,
+	"epmfs":  true,
+	"eprand": true,
+	"ff":     true,
+	"lfs":    true,
+	"lno":    true,
+	"lus":    true,
+	"mfs":    true,
+	"newest": true,
+	"rand":   true,
+}
+
+// UnionPreset is a named set of union policies offered as a pool strategy.
+type UnionPreset struct {
+	Action string
+	Create string
+	Search string
+}
+
+var UnionPresets = map[string]UnionPreset{
+	"union":  {Action: "epall", Create: "epmfs", Search: "ff"},
+	"eplus":  {Action: "epall", Create: "eplus", Search: "ff"},
+	"epff":   {Action: "epall", Create: "epff", Search: "ff"},
+	"mirror": {Action: "all", Create: "all", Search: "ff"},
+}
+
+// FillUnionPolicies sets the policies a pool has none of from its strategy,
+// or from the union preset if the strategy isn't one. Pools stored before
+// policies were only have a strategy.
+func FillUnionPolicies(pool *models.StoragePool) {
+	preset, ok := UnionPresets[pool.Strategy]
+	if !ok {
+		preset = UnionPresets["union"]
+	}
+	if pool.ActionPolicy == "" {
+		pool.ActionPolicy = preset.Action
+	}
+	if pool.CreatePolicy == "" {
+		pool.CreatePolicy = preset.Create
+	}
+	if pool.SearchPolicy == "" {
+		pool.SearchPolicy = preset.Search
+	}
+}
+
+// unionParams is a union remote over upstreams with the pool's policies.
+func unionParams(pool *models.StoragePool, upstreams []string) map[string]string {
+	policies := *pool
+	FillUnionPolicies(&policies)
+
+	params := map[string]string{
+		"type":          "union",
+		"upstreams":     strings.Join(upstreams, " "),
+		"action_policy": policies.ActionPolicy,
+		"create_policy": policies.CreatePolicy,
+		"search_policy": policies.SearchPolicy,
+	}
+	if pool.CacheTime > 0 {
+		params["cache_time"] = strconv.Itoa(pool.CacheTime)
+	}
+	return params
+}
+
+// upstreamFs is the fs string of a pool member as the union sees it. It does
+// not depend on the pool's remotes existing: the chunker is given inline.
+func upstreamFs(pool *models.StoragePool, account *models.Account) string {
+	upstream := remoteName(account.ID, account.Type) + ":"
+	if !chunksUpstreams(pool) {
+		return upstream
+	}
+	params := chunkerParams(pool, upstream)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "type" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	fs := ":chunker"
+	for _, k := range keys {
+		// Quoted as values contain colons; quotes inside are doubled
+		fs += fmt.Sprintf(",%s=\"%s\"", k, strings.ReplaceAll(params[k], `"`, `""`))
+	}
+	return fs + ":"
+}
+
+// buildRemotes renders the complete config for the given accounts and the
+// pools whose unions should exist.
+func buildRemotes(accounts []models.Account, pools []models.StoragePool, saDir string) (remotes, error) {
+	all := remotes{}
+	for i := range accounts {
+		r, err := accountRemotes(&accounts[i], saDir)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", accounts[i].ID, err)
+		}
+		all.merge(r)
+	}
+	for i := range pools {
+		r, err := unionRemotes(&pools[i])
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", pools[i].ID, err)
+		}
+		all.merge(r)
+	}
+	return all, nil
+}
+
+func (r remotes) merge(other remotes) {
+	for name, params := range other {
+		r[name] = params
+	}
+}
+
+func (r remotes) clone() remotes {
+	cp := make(remotes, len(r))
+	for name, params := range r {
+		section := make(map[string]string, len(params))
+		for k, v := range params {
+			section[k] = v
+		}
+		cp[name] = section
+	}
+	return cp
+}
+
+// pruneChunkers drops chunker remotes of members nothing refers to any
+// more. Chunkers over a union are the top of their pool and go with it.
+func (r remotes) pruneChunkers() {
+	used := map[string]bool{}
+	for name, params := range r {
+		switch params["type"] {
+		case "union":
+			for _, upstream := range strings.Fields(params["upstreams"]) {
+				used[upstreamName(upstream)] = true
+			}
+		case "crypt", "compress":
+			used[upstreamName(params["remote"])] = true
+		case "chunker":
+			// Left behind if saving its union failed
+			if _, ok := r[upstreamName(params["remote"])]; ok && strings.HasPrefix(params["remote"], "union_") {
+				used[name] = true
+			}
+		}
+	}
+	for name := range r {
+		if strings.HasPrefix(name, "chunk_") && !used[name] {
+			delete(r, name)
+		}
+	}
+}
+
+// upstreamName returns the remote an entry of a union's upstreams refers
+// to, e.g. "chunk_x" for "chunk_x::ro".
+func upstreamName(upstream string) string {
+	for _, tag := range []string{":ro", ":nc", ":writeback"} {
+		upstream = strings.TrimSuffix(upstream, tag)
+	}
+	name, _, _ := strings.Cut(upstream, ":")
+	return name
+}
+
+// render writes the remotes in rclone.conf syntax, sorted by name with
+// "type" first in every section. Secret values are replaced when redact
+// is set.
+func (r remotes) render(redact bool) []byte {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		params := r[name]
+		fmt.Fprintf(&buf, "[%s]\n", name)
+		fmt.Fprintf(&buf, "type = %s\n", params["type"])
+
+		keys := make([]string, 0, len(params))
+		for k := range params {
+			if k != "type" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value := params[k]
+			if redact && secretOptions[k] && value != "" {
+				value = "<redacted>"
+			}
+			fmt.Fprintf(&buf, "%s = %s\n", k, value)
+		}
+	}
+	return buf.Bytes()
+}
+
+// keepRefreshedTokens copies tokens from the config on disk that are newer
+// than ours: rclone refreshes tokens by itself and saves them to the file,
+// and overwriting them with older ones would break providers that rotate
+// refresh tokens.
+func (r remotes) keepRefreshedTokens(onDisk remotes) {
+	for name, params := range r {
+		current, ok := onDisk[name]
+		if !ok || params["token"] == "" || current["token"] == "" || current["token"] == params["token"] {
+			continue
+		}
+		if tokenExpiry(current["token"]).After(tokenExpiry(params["token"])) {
+			params["token"] = current["token"]
+		}
+	}
+}
+
+func tokenExpiry(token string) time.Time {
+	var t struct {
+		Expiry time.Time `json:"expiry"`
+	}
+	json.Unmarshal([]byte(token), &t)
+	return t.Expiry
+}
+
+func remotesFromSections(sections []ConfigSection) remotes {
+	r := make(remotes, len(sections))
+	for _, s := range sections {
+		r[s.Name] = s.Params
+	}
+	return r
+}
+
+// ConfigPreview compares the config that would be generated from the
+// database with the one on disk. Secrets are redacted in both.
+type ConfigPreview struct {
+	Generated string `json:"generated"`
+	Current   string `json:"current"`
+	Diff      string `json:"diff"`
+	Changed   bool   `json:"changed"`
+}
+
+func newConfigPreview(generated, current remotes) *ConfigPreview {
+	preview := &ConfigPreview{
+		Generated: string(generated.render(true)),
+		Current:   string(current.render(true)),
+	}
+	preview.Diff, preview.Changed = diffLines(preview.Current, preview.Generated)
+	return preview
+}
+
+// diffLines returns a line diff from a to b, each line prefixed with " ",
+// "-" or "+", and whether anything differs.
+func diffLines(a, b string) (string, bool) {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	if a == "" {
+		x = nil
+	}
+	if b == "" {
+		y = nil
+	}
+
+	// lcs[i][j] = length of the longest common subsequence of x[i:], y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var buf strings.Builder
+	changed := false
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			buf.WriteString("  " + x[i] + "\n")
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			buf.WriteString("+ " + y[j] + "\n")
+			j++
+			changed = true
+		default:
+			buf.WriteString("- " + x[i] + "\n")
+			i++
+			changed = true
+		}
+	}
+	return buf.String(), changed
+}
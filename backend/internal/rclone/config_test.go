@@ -0,0 +1,254 @@
+package rclone
+
+import (
+	"pooled-storage/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestCredentialRemotes(t *testing.T) {
+	tests := []struct {
+		name         string
+		account      models.Account
+		passwords    map[string]string // remote.option -> plaintext it must obscure
+		want         string            // with obscured passwords as <obscured>
+		wantRedacted string
+	}{
+		{
+			name: "s3",
+			account: models.Account{ID: "a1", Type: "s3", Credentials: `{"endpoint":"https://s3.example.com","region":"eu-1",
+				"access_key_id":"AKID","secret_access_key":"s3-secret","bucket":"bucket","path":"backups/"}`},
+			want: `[s3_a1]
+type = alias
+remote = s3_a1_base:bucket/backups
+
+[s3_a1_base]
+type = s3
+access_key_id = AKID
+endpoint = https://s3.example.com
+provider = Other
+region = eu-1
+secret_access_key = s3-secret
+`,
+			wantRedacted: `[s3_a1]
+type = alias
+remote = s3_a1_base:bucket/backups
+
+[s3_a1_base]
+type = s3
+access_key_id = AKID
+endpoint = https://s3.example.com
+provider = Other
+region = eu-1
+secret_access_key = <redacted>
+`,
+		},
+		{
+			name: "webdav",
+			account: models.Account{ID: "a2", Type: "webdav", Credentials: `{"url":"https://cloud.example.com/remote.php/dav/files/ann",
+				"vendor":"nextcloud","user":"ann","password":"dav-secret"}`},
+			passwords: map[string]string{"webdav_a2.pass": "dav-secret"},
+			want: `[webdav_a2]
+type = webdav
+pass = <obscured>
+url = https://cloud.example.com/remote.php/dav/files/ann
+user = ann
+vendor = nextcloud
+`,
+			wantRedacted: `[webdav_a2]
+type = webdav
+pass = <redacted>
+url = https://cloud.example.com/remote.php/dav/files/ann
+user = ann
+vendor = nextcloud
+`,
+		},
+		{
+			name: "sftp",
+			account: models.Account{ID: "a3", Type: "sftp", Credentials: `{"host":"files.example.com","port":2222,"user":"ann",
+				"password":"sftp-secret","private_key":"-----BEGIN KEY-----\r\nabc\r\n-----END KEY-----\n",
+				"private_key_passphrase":"key-secret","path":"/srv/data/"}`},
+			passwords: map[string]string{"sftp_a3_base.pass": "sftp-secret", "sftp_a3_base.key_file_pass": "key-secret"},
+			want: `[sftp_a3]
+type = alias
+remote = sftp_a3_base:/srv/data
+
+[sftp_a3_base]
+type = sftp
+host = files.example.com
+key_file_pass = <obscured>
+key_pem = -----BEGIN KEY-----\nabc\n-----END KEY-----
+pass = <obscured>
+port = 2222
+user = ann
+`,
+			wantRedacted: `[sftp_a3]
+type = alias
+remote = sftp_a3_base:/srv/data
+
+[sftp_a3_base]
+type = sftp
+host = files.example.com
+key_file_pass = <redacted>
+key_pem = <redacted>
+pass = <redacted>
+port = 2222
+user = ann
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.account.AuthMethod = "credentials"
+			r, err := accountRemotes(&tt.account, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(r.render(true)); got != tt.wantRedacted {
+				t.Errorf("redacted config:\n%s\nwant:\n%s", got, tt.wantRedacted)
+			}
+
+			// Obscuring is salted, so check each password reveals to what
+			// was given before comparing the rest
+			for name, params := range r {
+				for _, key := range passwordOptions[params["type"]] {
+					if params[key] == "" {
+						continue
+					}
+					plain, ok := tt.passwords[name+"."+key]
+					if !ok {
+						t.Errorf("unexpected %s in %s", key, name)
+						continue
+					}
+					if params[key] == plain {
+						t.Errorf("%s of %s is not obscured", key, name)
+					} else if revealed, err := Reveal(params[key]); err != nil || revealed != plain {
+						t.Errorf("%s of %s reveals to %q (%v), want %q", key, name, revealed, err, plain)
+					}
+					params[key] = "<obscured>"
+				}
+			}
+			if got := string(r.render(false)); got != tt.want {
+				t.Errorf("config:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderRedacts(t *testing.T) {
+	r := remotes{"remote": {"type": "test", "user": "ann", "empty_secret": ""}}
+	for key := range secretOptions {
+		r["remote"][key] = "secret-" + key
+	}
+
+	got := string(r.render(true))
+	if strings.Contains(got, "secret-") {
+		t.Errorf("redacted config leaks a secret:\n%s", got)
+	}
+	for key := range secretOptions {
+		if !strings.Contains(got, key+" = <redacted>\n") {
+			t.Errorf("%s is not redacted:\n%s", key, got)
+		}
+	}
+	if !strings.Contains(got, "user = ann\n") {
+		t.Errorf("redacted config lost a plain option:\n%s", got)
+	}
+
+	if got := string(r.render(false)); !strings.Contains(got, "token = secret-token\n") {
+		t.Errorf("unredacted config:\n%s", got)
+	}
+}
+
+// chain follows the "remote" option of the layer remotes from top down to
+// the union and returns the types passed on the way.
+func chain(r remotes, top string) []string {
+	var types []string
+	for name := strings.TrimSuffix(top, ":"); ; {
+		params, ok := r[name]
+		if !ok {
+			return append(types, "missing "+name)
+		}
+		types = append(types, params["type"])
+		if params["type"] == "union" {
+			return types
+		}
+		name = strings.TrimSuffix(params["remote"], ":")
+	}
+}
+
+func TestCompressLayer(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption string
+		chunker    string // chunker mode, none if empty
+		want       []string
+		upstream   string // first union upstream
+	}{
+		{"plain", "", "", []string{"compress", "union"}, "google_a:"},
+		{"upstream chunker", "", "upstream", []string{"compress", "union"}, "chunk_p_a:"},
+		{"union chunker", "", "union", []string{"compress", "chunker", "union"}, "google_a:"},
+		{"union encryption", "union", "", []string{"compress", "crypt", "union"}, "google_a:"},
+		{"union encryption and chunker", "union", "union", []string{"compress", "crypt", "chunker", "union"}, "google_a:"},
+		{"upstream encryption", "upstream", "upstream", []string{"compress", "union"}, "crypt_p_a:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &models.StoragePool{ID: "p", Accounts: []models.Account{{ID: "a", Type: "google"}},
+				EnableChunker: tt.chunker != "", ChunkerMode: tt.chunker, ChunkSize: "100M", Encryption: tt.encryption,
+				CryptPassword: "pass", CryptSalt: "salt", FilenameEncryption: "standard",
+				EnableCompression: true, CompressionLevel: 6, CompressionRAMCacheLimit: "20M"}
+			r, err := unionRemotes(pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			top := poolRemote(pool)
+			if top != "compress_p:" {
+				t.Errorf("pool remote = %q, want compress_p:", top)
+			}
+			if got := chain(r, top); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("chain = %v, want %v", got, tt.want)
+			}
+			if upstreams := strings.Fields(r["union_p"]["upstreams"]); len(upstreams) != 1 || upstreams[0] != tt.upstream {
+				t.Errorf("union upstreams = %v, want %s", upstreams, tt.upstream)
+			}
+			if c := r["compress_p"]; c["mode"] != "gzip" || c["level"] != "6" || c["ram_cache_limit"] != "20M" {
+				t.Errorf("compress remote = %v", c)
+			}
+
+			// Without compression the pool is served from the next layer down
+			pool.EnableCompression = false
+			r, err = unionRemotes(pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := r["compress_p"]; ok {
+				t.Error("compress remote without compression")
+			}
+			if got := chain(r, poolRemote(pool)); strings.Join(got, " ") != strings.Join(tt.want[1:], " ") {
+				t.Errorf("chain without compression = %v, want %v", got, tt.want[1:])
+			}
+		})
+	}
+}
+
+func TestPruneChunkers(t *testing.T) {
+	pool := &models.StoragePool{ID: "p", Accounts: []models.Account{{ID: "a", Type: "google"}},
+		EnableChunker: true, ChunkerMode: "union", ChunkSize: "100M"}
+	r, err := unionRemotes(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.pruneChunkers()
+	if _, ok := r["chunk_p"]; !ok {
+		t.Fatal("chunker over an existing union pruned")
+	}
+
+	// A chunker whose union is gone is of no use
+	delete(r, "union_p")
+	r.pruneChunkers()
+	if _, ok := r["chunk_p"]; ok {
+		t.Error("chunker over a missing union kept")
+	}
+}
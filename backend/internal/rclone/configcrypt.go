@@ -0,0 +1,80 @@
+package rclone
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// rclone's encrypted config format: a comment header, the marker line and
+// base64(nonce || secretbox(config)) keyed by sha256("[pass][rclone-config]").
+const (
+	encryptedConfigHeader = "# Encrypted rclone configuration File\n\n"
+	encryptedConfigMarker = "RCLONE_ENCRYPT_V0:"
+)
+
+func configKey(pass string) *[32]byte {
+	key := sha256.Sum256([]byte("[" + strings.TrimSpace(pass) + "][rclone-config]"))
+	return &key
+}
+
+func isEncryptedConfig(data []byte) bool {
+	return bytes.Contains(data, []byte(encryptedConfigMarker))
+}
+
+func encryptConfig(plaintext []byte, pass string) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	sealed := secretbox.Seal(nonce[:], plaintext, &nonce, configKey(pass))
+
+	var buf bytes.Buffer
+	buf.WriteString(encryptedConfigHeader)
+	buf.WriteString(encryptedConfigMarker + "\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(sealed))
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func decryptConfig(data []byte, pass string) ([]byte, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+
+	var encoded strings.Builder
+	seenMarker := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case !seenMarker && (line == "" || strings.HasPrefix(line, "#")):
+		case !seenMarker && line == encryptedConfigMarker:
+			seenMarker = true
+		case !seenMarker:
+			return nil, fmt.Errorf("not an encrypted rclone config")
+		default:
+			encoded.WriteString(line)
+		}
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted rclone config: %w", err)
+	}
+	if len(sealed) < 24 {
+		return nil, fmt.Errorf("malformed encrypted rclone config")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	plaintext, ok := secretbox.Open(nil, sealed[24:], &nonce, configKey(pass))
+	if !ok {
+		return nil, fmt.Errorf("wrong RCLONE_CONFIG_PASS for rclone config")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,63 @@
+package rclone
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestEncryptConfig(t *testing.T) {
+	plaintext := []byte("[gdrive]\ntype = drive\ntoken = {\"access_token\":\"secret\"}\n")
+
+	data, err := encryptConfig(plaintext, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rclone only recognises the file by this exact preamble
+	const preamble = "# Encrypted rclone configuration File\n\nRCLONE_ENCRYPT_V0:\n"
+	if !strings.HasPrefix(string(data), preamble) {
+		t.Fatalf("encrypted config does not start with %q:\n%s", preamble, data)
+	}
+	if !isEncryptedConfig(data) || isEncryptedConfig(plaintext) {
+		t.Error("isEncryptedConfig does not tell encrypted and plain configs apart")
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("encrypted config contains the plaintext")
+	}
+
+	// The body is base64(nonce || secretbox) under sha256("[pass][rclone-config]"),
+	// which is what rclone derives from RCLONE_CONFIG_PASS
+	body := strings.TrimSpace(strings.TrimPrefix(string(data), preamble))
+	sealed, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed)
+	key := sha256.Sum256([]byte("[hunter2][rclone-config]"))
+	opened, ok := secretbox.Open(nil, sealed[24:], &nonce, &key)
+	if !ok || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("body does not open with rclone's key derivation: %q", opened)
+	}
+
+	// Surrounding whitespace in the password is ignored, as rclone does
+	got, err := decryptConfig(data, " hunter2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("decryptConfig = %q, want %q", got, plaintext)
+	}
+
+	if _, err := decryptConfig(data, "hunter3"); err == nil {
+		t.Error("decryptConfig with the wrong password succeeded")
+	}
+	if _, err := decryptConfig(plaintext, "hunter2"); err == nil {
+		t.Error("decryptConfig of a plain config succeeded")
+	}
+}
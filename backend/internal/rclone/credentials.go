@@ -0,0 +1,81 @@
+package rclone
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"pooled-storage/internal/models"
+	"strconv"
+	"strings"
+)
+
+// credentialParams turns the credential payload of an S3, WebDAV or SFTP
+// account into rclone remote parameters. root is the path inside the
+// backend the account is confined to, if any.
+func credentialParams(account *models.Account) (map[string]string, string, error) {
+	switch account.Type {
+	case "s3":
+		var c models.S3Credentials
+		if err := json.Unmarshal([]byte(account.Credentials), &c); err != nil {
+			return nil, "", fmt.Errorf("invalid s3 credentials: %w", err)
+		}
+		provider := c.Provider
+		if provider == "" {
+			provider = "Other"
+		}
+		params := map[string]string{
+			"provider":          provider,
+			"access_key_id":     c.AccessKeyID,
+			"secret_access_key": c.SecretAccessKey,
+		}
+		if c.Endpoint != "" {
+			params["endpoint"] = c.Endpoint
+		}
+		if c.Region != "" {
+			params["region"] = c.Region
+		}
+		return params, path.Join(c.Bucket, c.Path), nil
+
+	case "webdav":
+		var c models.WebDAVCredentials
+		if err := json.Unmarshal([]byte(account.Credentials), &c); err != nil {
+			return nil, "", fmt.Errorf("invalid webdav credentials: %w", err)
+		}
+		params := map[string]string{
+			"url":    c.URL,
+			"vendor": c.Vendor,
+		}
+		if c.User != "" {
+			params["user"] = c.User
+		}
+		if c.Password != "" {
+			params["pass"] = c.Password
+		}
+		return params, "", nil
+
+	case "sftp":
+		var c models.SFTPCredentials
+		if err := json.Unmarshal([]byte(account.Credentials), &c); err != nil {
+			return nil, "", fmt.Errorf("invalid sftp credentials: %w", err)
+		}
+		params := map[string]string{
+			"host": c.Host,
+			"port": strconv.Itoa(c.Port),
+			"user": c.User,
+		}
+		if c.Password != "" {
+			params["pass"] = c.Password
+		}
+		if c.PrivateKey != "" {
+			// rclone wants the PEM on a single line with literal \n separators
+			pem := strings.ReplaceAll(strings.TrimSpace(c.PrivateKey), "\r\n", "\n")
+			params["key_pem"] = strings.ReplaceAll(pem, "\n", `\n`)
+			if c.PrivateKeyPassphrase != "" {
+				params["key_file_pass"] = c.PrivateKeyPassphrase
+			}
+		}
+		return params, strings.TrimRight(c.Path, "/"), nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported account type: %s", account.Type)
+}
@@ -0,0 +1,72 @@
+package rclone
+
+import (
+	"pooled-storage/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestCredentialParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		account    models.Account
+		wantParams map[string]string
+		wantRoot   string
+		wantErr    bool
+	}{
+		{
+			name: "s3",
+			account: models.Account{Type: "s3", Credentials: `{"endpoint":"https://s3.example.com","region":"eu-1",
+				"access_key_id":"AKID","secret_access_key":"s3-secret","bucket":"bucket","path":"backups/"}`},
+			wantParams: map[string]string{"provider": "Other", "access_key_id": "AKID",
+				"secret_access_key": "s3-secret", "endpoint": "https://s3.example.com", "region": "eu-1"},
+			wantRoot: "bucket/backups",
+		},
+		{
+			name: "webdav",
+			account: models.Account{Type: "webdav", Credentials: `{"url":"https://cloud.example.com/remote.php/dav/files/ann",
+				"vendor":"nextcloud","user":"ann","password":"dav-secret"}`},
+			wantParams: map[string]string{"url": "https://cloud.example.com/remote.php/dav/files/ann",
+				"vendor": "nextcloud", "user": "ann", "pass": "dav-secret"},
+		},
+		{
+			name: "sftp",
+			account: models.Account{Type: "sftp", Credentials: `{"host":"files.example.com","port":2222,"user":"ann",
+				"private_key":"-----BEGIN KEY-----\r\nabc\r\n-----END KEY-----\n","private_key_passphrase":"key-secret",
+				"path":"/srv/data/"}`},
+			wantParams: map[string]string{"host": "files.example.com", "port": "2222", "user": "ann",
+				"key_pem": `-----BEGIN KEY-----\nabc\n-----END KEY-----`, "key_file_pass": "key-secret"},
+			wantRoot: "/srv/data",
+		},
+		{
+			name:    "invalid payload",
+			account: models.Account{Type: "webdav", Credentials: `{"url":`},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			account: models.Account{Type: "google", Credentials: `{}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, root, err := credentialParams(&tt.account)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("credentialParams succeeded with %v", params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("params = %v, want %v", params, tt.wantParams)
+			}
+			if root != tt.wantRoot {
+				t.Errorf("root = %q, want %q", root, tt.wantRoot)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package rclone
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	daemonMinBackoff = time.Second
+	daemonMaxBackoff = 30 * time.Second
+)
+
+// daemon runs a single `rclone rcd` process and restarts it if it exits
+// while the manager still needs it.
+type daemon struct {
+	args   []string
+	logger *log.Logger
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+	done     chan struct{}
+}
+
+func newDaemon(addr, user, pass, configPath string) *daemon {
+	args := []string{"rcd",
+		"--rc-addr", addr,
+		"--config", configPath,
+	}
+	if user != "" {
+		args = append(args, "--rc-user", user, "--rc-pass", pass)
+	} else {
+		args = append(args, "--rc-no-auth")
+	}
+
+	return &daemon{
+		args:   args,
+		logger: log.New(os.Stderr, "[rclone rcd] ", log.LstdFlags),
+		done:   make(chan struct{}),
+	}
+}
+
+func (d *daemon) start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.spawn(); err != nil {
+		return err
+	}
+	go d.supervise()
+	return nil
+}
+
+// spawn must be called with d.mu held.
+func (d *daemon) spawn() error {
+	cmd := exec.Command("rclone", d.args...)
+	cmd.Stdout = d.logger.Writer()
+	cmd.Stderr = d.logger.Writer()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start rclone rcd: %w", err)
+	}
+	d.cmd = cmd
+	return nil
+}
+
+func (d *daemon) supervise() {
+	defer close(d.done)
+
+	backoff := daemonMinBackoff
+	for {
+		d.mu.Lock()
+		cmd := d.cmd
+		d.mu.Unlock()
+
+		started := time.Now()
+		err := cmd.Wait()
+
+		d.mu.Lock()
+		if d.stopping {
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		if time.Since(started) > daemonMaxBackoff {
+			backoff = daemonMinBackoff
+		}
+		d.logger.Printf("exited unexpectedly (%v), restarting in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > daemonMaxBackoff {
+			backoff = daemonMaxBackoff
+		}
+
+		d.mu.Lock()
+		if d.stopping {
+			d.mu.Unlock()
+			return
+		}
+		if err := d.spawn(); err != nil {
+			d.logger.Print(err)
+			d.mu.Unlock()
+			continue
+		}
+		d.mu.Unlock()
+	}
+}
+
+// stop asks rclone to quit via rc and kills it if it has not exited before
+// ctx expires.
+func (d *daemon) stop(ctx context.Context, client *Client) error {
+	d.mu.Lock()
+	d.stopping = true
+	cmd := d.cmd
+	d.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	client.Call(ctx, "core/quit", nil, nil)
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-d.done
+		return ctx.Err()
+	}
+}
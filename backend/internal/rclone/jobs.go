@@ -0,0 +1,251 @@
+package rclone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pooled-storage/internal/models"
+	"time"
+)
+
+const jobPollInterval = 2 * time.Second
+
+// DrainProgress reports on a running DrainMember.
+type DrainProgress struct {
+	Phase      string // copying, verifying, deleting
+	Bytes      int64
+	TotalBytes int64
+	Files      int64
+	TotalFiles int64
+	Errors     int64
+}
+
+// DrainResult describes how a drained member's files were verified.
+type DrainResult struct {
+	HashType string // empty when only sizes could be compared
+}
+
+// PoolSize compares the files of a pool with what its members store.
+type PoolSize struct {
+	Files   int64
+	Logical int64 // bytes as the pool's mount shows them
+	Stored  int64 // bytes on the members, summed over them
+}
+
+type jobStatus struct {
+	Finished bool            `json:"finished"`
+	Success  bool            `json:"success"`
+	Error    string          `json:"error"`
+	Output   json.RawMessage `json:"output"`
+}
+
+// runJob starts method as an rc background job and polls it until it
+// finishes, passing the job's transfer stats to onStats. Cancelling ctx
+// stops the job.
+func (m *Manager) runJob(ctx context.Context, method string, params map[string]interface{}, onStats func(*Stats)) (json.RawMessage, error) {
+	in := make(map[string]interface{}, len(params)+1)
+	for k, v := range params {
+		in[k] = v
+	}
+	in["_async"] = true
+
+	var started struct {
+		JobID int64 `json:"jobid"`
+	}
+	if err := m.call(method, in, &started); err != nil {
+		return nil, err
+	}
+	jobID := map[string]interface{}{"jobid": started.JobID}
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		var status jobStatus
+		if err := m.call("job/status", jobID, &status); err != nil {
+			return nil, err
+		}
+		if onStats != nil {
+			var stats Stats
+			group := fmt.Sprintf("job/%d", started.JobID)
+			if err := m.call("core/stats", map[string]interface{}{"group": group}, &stats); err == nil {
+				onStats(&stats)
+			}
+		}
+		if status.Finished {
+			if !status.Success {
+				return status.Output, errors.New(status.Error)
+			}
+			return status.Output, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			m.call("job/stop", jobID, nil)
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// DrainMember moves the files of one pool member to the pool's other
+// writable members. Files are copied, checked against the copies and only
+// then deleted from the member, so a failure at any point loses nothing.
+// The pool must be running: its chunker remotes are used as they are.
+func (m *Manager) DrainMember(ctx context.Context, pool *models.StoragePool, account *models.Account, progress func(DrainProgress)) (*DrainResult, error) {
+	src := upstreamRemote(pool, account)
+	var others []string
+	for i := range pool.Accounts {
+		other := &pool.Accounts[i]
+		if other.ID != account.ID && other.PoolMode != "ro" {
+			others = append(others, upstreamRemote(pool, other))
+		}
+	}
+	if len(others) == 0 {
+		return nil, fmt.Errorf("no other writable members to drain to")
+	}
+
+	// The destination is the pool without the member, so the pool's create
+	// policy decides where each file goes
+	dstName := fmt.Sprintf("drain_%s_%s", pool.ID, account.ID)
+	err := m.updateRemotes(func(all remotes) error {
+		all[dstName] = unionParams(pool, others)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer m.updateRemotes(func(all remotes) error {
+		delete(all, dstName)
+		return nil
+	})
+	dst := dstName + ":"
+
+	progress(DrainProgress{Phase: "copying"})
+	_, err = m.runJob(ctx, "sync/copy", map[string]interface{}{
+		"srcFs":              src,
+		"dstFs":              dst,
+		"createEmptySrcDirs": true,
+	}, func(stats *Stats) {
+		progress(DrainProgress{
+			Phase:      "copying",
+			Bytes:      stats.Bytes,
+			TotalBytes: stats.TotalBytes,
+			Files:      stats.Transfers,
+			TotalFiles: stats.TotalTransfers,
+			Errors:     stats.Errors,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("copy failed: %w", err)
+	}
+
+	progress(DrainProgress{Phase: "verifying"})
+	out, err := m.runJob(ctx, "operations/check", map[string]interface{}{
+		"srcFs":        src,
+		"dstFs":        dst,
+		"oneWay":       true,
+		"missingOnDst": true,
+		"differ":       true,
+	}, nil)
+	var check struct {
+		Success      bool     `json:"success"`
+		Status       string   `json:"status"`
+		HashType     string   `json:"hashType"`
+		MissingOnDst []string `json:"missingOnDst"`
+		Differ       []string `json:"differ"`
+	}
+	if out != nil {
+		json.Unmarshal(out, &check)
+	}
+	if err != nil && len(check.MissingOnDst)+len(check.Differ) == 0 {
+		return nil, fmt.Errorf("check failed: %w", err)
+	}
+	if len(check.MissingOnDst)+len(check.Differ) > 0 {
+		return nil, fmt.Errorf("check found %d missing and %d differing files", len(check.MissingOnDst), len(check.Differ))
+	}
+
+	// Everything is copied, so the move only deletes from the source. Files
+	// changed on the destination since are newer and are left alone.
+	progress(DrainProgress{Phase: "deleting"})
+	_, err = m.runJob(ctx, "sync/move", map[string]interface{}{
+		"srcFs":              src,
+		"dstFs":              dst,
+		"deleteEmptySrcDirs": true,
+		"_config":            map[string]interface{}{"UpdateOlder": true},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("delete failed: %w", err)
+	}
+
+	result := &DrainResult{HashType: check.HashType}
+	if result.HashType == "none" {
+		result.HashType = ""
+	}
+	return result, nil
+}
+
+// MoveFile moves one file between two members of a pool, through the pool's
+// chunker when it has one so chunked files move whole.
+func (m *Manager) MoveFile(ctx context.Context, pool *models.StoragePool, from, to *models.Account, path string) error {
+	_, err := m.runJob(ctx, "operations/movefile", map[string]interface{}{
+		"srcFs":     upstreamFs(pool, from),
+		"srcRemote": path,
+		"dstFs":     upstreamFs(pool, to),
+		"dstRemote": path,
+	}, nil)
+	return err
+}
+
+// SetBandwidthLimit sets the bandwidth limit of the rc daemon. The limit is
+// process wide: it also applies to mounts when they run inside an external
+// daemon rather than as their own processes. An empty rate means "off".
+func (m *Manager) SetBandwidthLimit(rate string) (string, error) {
+	var current struct {
+		Rate string `json:"rate"`
+	}
+	if err := m.call("core/bwlimit", map[string]interface{}{}, &current); err != nil {
+		return "", err
+	}
+	if rate == "" {
+		rate = "off"
+	}
+	if err := m.call("core/bwlimit", map[string]interface{}{"rate": rate}, nil); err != nil {
+		return "", err
+	}
+	return current.Rate, nil
+}
+
+// PoolSize walks a pool through its mounted remote for the logical size and
+// each member below any compression, encryption and chunking for the
+// stored size. The pool's remotes must exist. Both walks list every file,
+// so this is slow on large pools.
+func (m *Manager) PoolSize(ctx context.Context, pool *models.StoragePool) (*PoolSize, error) {
+	size := func(fs string) (*Size, error) {
+		out, err := m.runJob(ctx, "operations/size", map[string]interface{}{"fs": fs}, nil)
+		if err != nil {
+			return nil, err
+		}
+		var s Size
+		if err := json.Unmarshal(out, &s); err != nil {
+			return nil, fmt.Errorf("invalid size of %s: %w", fs, err)
+		}
+		return &s, nil
+	}
+
+	logical, err := size(poolRemote(pool))
+	if err != nil {
+		return nil, err
+	}
+	result := &PoolSize{Files: logical.Count, Logical: logical.Bytes}
+	for i := range pool.Accounts {
+		// The bare remote, not upstreamFs: a chunker would add the chunks
+		// back up to the logical size
+		stored, err := size(remoteName(pool.Accounts[i].ID, pool.Accounts[i].Type) + ":")
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", pool.Accounts[i].ID, err)
+		}
+		result.Stored += stored.Bytes
+	}
+	return result, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package rclone
+
+// lockFile is a no-op where flock is unavailable; writes within this
+// process are still serialized by Manager.mu.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -1,20 +1,48 @@
 package rclone
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"pooled-storage/internal/models"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	rcCallTimeout  = 2 * time.Minute
+	rcStartTimeout = 15 * time.Second
+)
+
 type Manager struct {
 	configPath string
 	mountPath  string
+	rc         *Client
+	daemon     *daemon // nil when an external rc server is used
+
+	// mu serializes config changes; remotes is what rclone.conf holds.
+	mu      sync.Mutex
+	remotes remotes
+
+	// mounts holds the supervised `rclone mount` processes by pool ID. Only
+	// used with our own daemon; an external rc server mounts by itself.
+	mountsMu  sync.Mutex
+	mounts    map[string]*rcloneProcess
+	mountLogs map[string]*logBuffer // kept after a process stops
+
+	// serves holds the supervised `rclone serve` processes by serve ID;
+	// rcServes maps serve IDs to the IDs an external rc server gave them.
+	// Both are guarded by mountsMu.
+	serves    map[string]*rcloneProcess
+	serveLogs map[string]*logBuffer
+	rcServes  map[string]string
 }
 
 func NewManager() *Manager {
@@ -32,122 +60,308 @@ func NewManager() *Manager {
 	os.MkdirAll(filepath.Dir(configPath), 0755)
 	os.MkdirAll(mountPath, 0755)
 
-	return &Manager{
+	m := &Manager{
 		configPath: configPath,
 		mountPath:  mountPath,
+		mounts:     make(map[string]*rcloneProcess),
+		mountLogs:  make(map[string]*logBuffer),
+		serves:     make(map[string]*rcloneProcess),
+		serveLogs:  make(map[string]*logBuffer),
+		rcServes:   make(map[string]string),
 	}
-}
 
-func (m *Manager) AddRemote(account *models.Account) error {
-	remoteName := fmt.Sprintf("%s_%s", account.Type, account.ID)
+	// Until Rebuild renders it from the database, start from what is on disk
+	current, err := m.readConfigFile()
+	if err != nil {
+		log.Printf("Failed to read rclone config %s: %v", configPath, err)
+		current = remotes{}
+	}
+	m.remotes = current
 
-	var cmd *exec.Cmd
-	switch account.Type {
-	case "google":
-		cmd = exec.Command("rclone", "config", "create", remoteName, "drive",
-			"--drive-token", account.AccessToken,
-			"--drive-scope", "drive",
-			"--config", m.configPath)
-	case "microsoft":
-		cmd = exec.Command("rclone", "config", "create", remoteName, "onedrive",
-			"--onedrive-token", account.AccessToken,
-			"--config", m.configPath)
-	default:
-		return fmt.Errorf("unsupported account type: %s", account.Type)
+	// RCLONE_RC_URL points at an rc server we don't own (an externally
+	// managed rcd, or a stand-in server in tests).
+	if rcURL := os.Getenv("RCLONE_RC_URL"); rcURL != "" {
+		m.rc = NewClient(rcURL, os.Getenv("RCLONE_RC_USER"), os.Getenv("RCLONE_RC_PASS"))
+		return m
 	}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to add remote: %s - %s", err, string(output))
+	addr := os.Getenv("RCLONE_RC_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:5572"
 	}
+	user, pass := "pooled-storage", randomToken()
+	m.rc = NewClient("http://"+addr, user, pass)
+	m.daemon = newDaemon(addr, user, pass, configPath)
 
-	return nil
+	return m
 }
 
-func (m *Manager) RemoveRemote(accountID, accountType string) error {
-	remoteName := fmt.Sprintf("%s_%s", accountType, accountID)
-	cmd := exec.Command("rclone", "config", "delete", remoteName, "--config", m.configPath)
-	return cmd.Run()
+// Start launches the rclone rc daemon (unless an external one is configured)
+// and waits until it answers requests.
+func (m *Manager) Start() error {
+	if m.daemon != nil {
+		if err := m.daemon.start(); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(rcStartTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := m.rc.Ping(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("rclone rc server not ready: %w", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
 }
 
-func (m *Manager) CreateUnion(pool *models.StoragePool) error {
-	if len(pool.Accounts) == 0 {
-		return fmt.Errorf("no accounts in pool")
-	}
-
-	var upstreams []string
-	for _, account := range pool.Accounts {
-		remoteName := fmt.Sprintf("%s_%s:", account.Type, account.ID)
-		
-		if pool.EnableChunker {
-			// Wrap in chunker
-			chunkRemote := fmt.Sprintf("chunk_%s", account.ID)
-			chunkerCmd := exec.Command("rclone", "config", "create", chunkRemote, "chunker",
-				"--chunker-remote", remoteName,
-				"--chunker-chunk-size", pool.ChunkSize,
-				"--config", m.configPath)
-			if err := chunkerCmd.Run(); err != nil {
-				return fmt.Errorf("failed to create chunker: %w", err)
+// Close stops every serve, unmounts every pool and stops the rc daemon if
+// this manager started it.
+func (m *Manager) Close() error {
+	if m.daemon == nil {
+		return m.closeExternal()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	m.mountsMu.Lock()
+	mounts := m.mounts
+	m.mounts = make(map[string]*rcloneProcess)
+	serves := m.serves
+	m.serves = make(map[string]*rcloneProcess)
+	m.mountsMu.Unlock()
+
+	var wg sync.WaitGroup
+	for serveID, p := range serves {
+		wg.Add(1)
+		go func(serveID string, p *rcloneProcess) {
+			defer wg.Done()
+			if err := p.stop(ctx); err != nil {
+				log.Printf("Failed to stop serve %s cleanly: %v", serveID, err)
 			}
-			upstreams = append(upstreams, chunkRemote+":")
-		} else {
-			upstreams = append(upstreams, remoteName)
-		}
+		}(serveID, p)
+	}
+	wg.Wait()
+
+	for poolID, p := range mounts {
+		wg.Add(1)
+		go func(poolID string, p *rcloneProcess) {
+			defer wg.Done()
+			if err := p.stop(ctx); err != nil {
+				log.Printf("Failed to unmount pool %s cleanly: %v", poolID, err)
+			}
+		}(poolID, p)
 	}
+	wg.Wait()
 
-	unionRemote := fmt.Sprintf("union_%s", pool.ID)
-	upstreamStr := strings.Join(upstreams, " ")
+	return m.daemon.stop(ctx, m.rc)
+}
 
-	var policyArgs []string
-	switch pool.Strategy {
-	case "union":
-		policyArgs = []string{
-			"--union-action-policy", "epall",
-			"--union-create-policy", "epmfs",
-			"--union-search-policy", "ff",
-		}
-	case "eplus":
-		policyArgs = []string{
-			"--union-action-policy", "epall",
-			"--union-create-policy", "eplus",
-			"--union-search-policy", "ff",
+// closeExternal stops the serves and mounts an external rc server runs for
+// us; they would outlive us otherwise.
+func (m *Manager) closeExternal() error {
+	m.mountsMu.Lock()
+	serves := m.rcServes
+	m.rcServes = make(map[string]string)
+	m.mountsMu.Unlock()
+
+	for serveID, rcID := range serves {
+		if err := m.call("serve/stop", map[string]interface{}{"id": rcID}, nil); err != nil {
+			log.Printf("Failed to stop serve %s cleanly: %v", serveID, err)
 		}
-	case "epff":
-		policyArgs = []string{
-			"--union-action-policy", "epall",
-			"--union-create-policy", "epff",
-			"--union-search-policy", "ff",
+	}
+	if err := m.call("mount/unmountall", nil, nil); err != nil {
+		return fmt.Errorf("failed to unmount pools: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) call(method string, in, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rcCallTimeout)
+	defer cancel()
+	return m.rc.Call(ctx, method, in, out)
+}
+
+// updateRemotes applies change to a copy of the config and saves it.
+func (m *Manager) updateRemotes(change func(r remotes) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.remotes.clone()
+	if err := change(r); err != nil {
+		return err
+	}
+	return m.saveLocked(r)
+}
+
+// saveLocked writes r as the new rclone.conf. Must be called with m.mu held.
+func (m *Manager) saveLocked(r remotes) error {
+	unlock, err := lockFile(m.configPath + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock rclone config: %w", err)
+	}
+	defer unlock()
+
+	if onDisk, err := m.readConfigFile(); err == nil {
+		r.keepRefreshedTokens(onDisk)
+	}
+
+	data := r.render(false)
+	if pass := os.Getenv("RCLONE_CONFIG_PASS"); pass != "" {
+		if data, err = encryptConfig(data, pass); err != nil {
+			return fmt.Errorf("failed to encrypt rclone config: %w", err)
 		}
-	case "mirror":
-		policyArgs = []string{
-			"--union-action-policy", "all",
-			"--union-create-policy", "all",
-			"--union-search-policy", "ff",
+	}
+	if err := writeFileAtomic(m.configPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write rclone config: %w", err)
+	}
+	m.remotes = r
+
+	// rclone rereads the file when it changes, but keeps backends it has
+	// already created; this fails harmlessly while the daemon is down.
+	m.call("fscache/clear", nil, nil)
+	return nil
+}
+
+// readConfigFile parses rclone.conf, decrypting it with RCLONE_CONFIG_PASS
+// like rclone does. A missing file is an empty config.
+func (m *Manager) readConfigFile() (remotes, error) {
+	data, err := os.ReadFile(m.configPath)
+	if os.IsNotExist(err) {
+		return remotes{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := ParseConfig(data, os.Getenv("RCLONE_CONFIG_PASS"))
+	if err != nil {
+		return nil, err
+	}
+	return remotesFromSections(sections), nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// serviceAccountDir holds the keys of service-account remotes.
+func (m *Manager) serviceAccountDir() string {
+	return filepath.Join(filepath.Dir(m.configPath), "service-accounts")
+}
+
+func (m *Manager) AddRemote(account *models.Account) error {
+	if account.AuthMethod == "service_account" {
+		if err := writeServiceAccountFile(m.serviceAccountDir(), account); err != nil {
+			return err
 		}
-	default:
-		policyArgs = []string{
-			"--union-action-policy", "epall",
-			"--union-create-policy", "epmfs",
-			"--union-search-policy", "ff",
+	}
+
+	r, err := accountRemotes(account, m.serviceAccountDir())
+	if err != nil {
+		return err
+	}
+
+	err = m.updateRemotes(func(all remotes) error {
+		all.merge(r)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add remote: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateToken replaces the OAuth token of an existing remote after renewal.
+func (m *Manager) UpdateToken(account *models.Account) error {
+	token, err := tokenJSON(account)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	name := remoteName(account.ID, account.Type)
+	return m.updateRemotes(func(all remotes) error {
+		section, ok := all[name]
+		if !ok {
+			return fmt.Errorf("remote %s not found", name)
 		}
+		section["token"] = token
+		return nil
+	})
+}
+
+func (m *Manager) RemoveRemote(accountID, accountType string) error {
+	name := remoteName(accountID, accountType)
+	err := m.updateRemotes(func(all remotes) error {
+		delete(all, name)
+		delete(all, baseRemoteName(name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(serviceAccountFile(m.serviceAccountDir(), accountID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove service account file: %w", err)
 	}
+	return nil
+}
 
-	args := append([]string{"config", "create", unionRemote, "union",
-		"--union-upstreams", upstreamStr,
-		"--config", m.configPath}, policyArgs...)
+func (m *Manager) CreateUnion(pool *models.StoragePool) error {
+	r, err := unionRemotes(pool)
+	if err != nil {
+		return err
+	}
 
-	cmd := exec.Command("rclone", args...)
-	output, err := cmd.CombinedOutput()
+	err = m.updateRemotes(func(all remotes) error {
+		// Drops the layers of members that left and of settings turned off
+		all.deletePool(pool.ID)
+		all.merge(r)
+		all.pruneChunkers()
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create union: %s - %s", err, string(output))
+		return fmt.Errorf("failed to create union: %w", err)
 	}
 
 	return nil
 }
 
+// PoolMountPath returns the directory a pool is mounted on.
+func (m *Manager) PoolMountPath(poolID string) string {
+	return filepath.Join(m.mountPath, poolID)
+}
+
 func (m *Manager) MountPool(pool *models.StoragePool) error {
-	unionRemote := fmt.Sprintf("union_%s:", pool.ID)
-	poolMountPath := filepath.Join(m.mountPath, pool.ID)
+	unionRemote := poolRemote(pool)
+	poolMountPath := m.PoolMountPath(pool.ID)
 
 	// Create mount directory
 	if err := os.MkdirAll(poolMountPath, 0755); err != nil {
@@ -159,33 +373,83 @@ func (m *Manager) MountPool(pool *models.StoragePool) error {
 		return fmt.Errorf("already mounted")
 	}
 
-	args := []string{
-		"mount", unionRemote, poolMountPath,
+	if m.daemon == nil {
+		return m.rcMount(pool, unionRemote, poolMountPath)
+	}
+
+	args := append([]string{"mount", unionRemote, poolMountPath,
 		"--config", m.configPath,
 		"--allow-other",
-		"--vfs-cache-mode", "writes",
-		"--daemon",
+		"--log-level", "INFO",
+	}, mountFlags(EffectiveMountOptions(pool))...)
+
+	m.mountsMu.Lock()
+	old := m.mounts[pool.ID]
+	delete(m.mounts, pool.ID)
+	logs, ok := m.mountLogs[pool.ID]
+	if !ok {
+		logs = newLogBuffer(mountLogLines, log.New(os.Stderr, fmt.Sprintf("[rclone mount %s] ", pool.ID), log.LstdFlags))
+		m.mountLogs[pool.ID] = logs
 	}
+	m.mountsMu.Unlock()
 
-	if pool.AllowLargeFiles {
-		args = append(args, "--vfs-cache-max-size", "50G")
+	if old != nil {
+		// Supervised but not mounted: crashed and waiting to be restarted
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		old.stop(ctx)
+		cancel()
 	}
 
-	cmd := exec.Command("rclone", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to mount: %s - %s", err, string(output))
+	p := newMountProcess(poolMountPath, args, logs, m.IsMounted)
+	if err := p.start(); err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
 	}
 
-	// Wait for mount to be ready
-	time.Sleep(2 * time.Second)
+	m.mountsMu.Lock()
+	m.mounts[pool.ID] = p
+	m.mountsMu.Unlock()
+	return nil
+}
+
+// rcMount mounts through the rc server; mount/mount returns once the mount
+// is serving requests.
+func (m *Manager) rcMount(pool *models.StoragePool, unionRemote, poolMountPath string) error {
+	vfsOpt := rcVfsOpt(pool.ID, EffectiveMountOptions(pool))
+
+	err := m.call("mount/mount", map[string]interface{}{
+		"fs":         unionRemote,
+		"mountPoint": poolMountPath,
+		"mountOpt": map[string]interface{}{
+			"AllowOther": true,
+		},
+		"vfsOpt": vfsOpt,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
+	}
 
 	return nil
 }
 
 func (m *Manager) UnmountPool(pool *models.StoragePool) error {
-	poolMountPath := filepath.Join(m.mountPath, pool.ID)
+	poolMountPath := m.PoolMountPath(pool.ID)
+
+	m.mountsMu.Lock()
+	p, ok := m.mounts[pool.ID]
+	delete(m.mounts, pool.ID)
+	m.mountsMu.Unlock()
+	if ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return p.stop(ctx)
+	}
 
+	err := m.call("mount/unmount", map[string]interface{}{"mountPoint": poolMountPath}, nil)
+	if err == nil {
+		return nil
+	}
+
+	// Not mounted by us (e.g. left over from an older --daemon mount)
 	cmd := exec.Command("fusermount", "-u", poolMountPath)
 	if err := cmd.Run(); err != nil {
 		// Try umount as fallback
@@ -196,46 +460,239 @@ func (m *Manager) UnmountPool(pool *models.StoragePool) error {
 	return nil
 }
 
+// MountRestarting reports whether the pool's supervised rclone mount is
+// backing off or starting again after it exited. Always false with an
+// external rc server, which doesn't restart mounts.
+func (m *Manager) MountRestarting(poolID string) bool {
+	m.mountsMu.Lock()
+	p, ok := m.mounts[poolID]
+	m.mountsMu.Unlock()
+	return ok && p.restarting() && !m.IsMounted(m.PoolMountPath(poolID))
+}
+
+// MountLogs returns the recent output of a pool's rclone mount process.
+func (m *Manager) MountLogs(poolID string) ([]string, error) {
+	if m.daemon == nil {
+		return nil, fmt.Errorf("pools are mounted by an external rc server, see its logs")
+	}
+
+	m.mountsMu.Lock()
+	logs, ok := m.mountLogs[poolID]
+	m.mountsMu.Unlock()
+	if !ok {
+		return []string{}, nil
+	}
+	return logs.Lines(), nil
+}
+
+func (m *Manager) MountDirs() ([]string, error) {
+	entries, err := os.ReadDir(m.mountPath)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+func (m *Manager) RemoveMountDir(poolID string) error {
+	// os.Remove refuses non-empty directories, so files written to an
+	// unmounted directory are never lost
+	return os.Remove(m.PoolMountPath(poolID))
+}
+
 func (m *Manager) IsMounted(path string) bool {
 	cmd := exec.Command("mountpoint", "-q", path)
 	return cmd.Run() == nil
 }
 
 func (m *Manager) GetQuota(accountID, accountType string) (int64, int64, error) {
-	remoteName := fmt.Sprintf("%s_%s:", accountType, accountID)
-	cmd := exec.Command("rclone", "about", remoteName,
-		"--json",
-		"--config", m.configPath)
+	fs := remoteName(accountID, accountType) + ":"
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
+	var about About
+	if err := m.call("operations/about", map[string]interface{}{"fs": fs}, &about); err != nil {
 		return 0, 0, err
 	}
 
-	var result struct {
-		Total int64 `json:"total"`
-		Used  int64 `json:"used"`
+	return about.Total, about.Used, nil
+}
+
+// GetUsage returns the bytes stored on an account's remote by walking it.
+// Used where about has no answer, e.g. shared drives.
+func (m *Manager) GetUsage(accountID, accountType string) (int64, error) {
+	var size Size
+	err := m.call("operations/size", map[string]interface{}{
+		"fs": remoteName(accountID, accountType) + ":",
+	}, &size)
+	if err != nil {
+		return 0, err
 	}
+	return size.Bytes, nil
+}
 
-	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
-		return 0, 0, err
+// ListSharedDrives lists the Google shared drives the account can see.
+func (m *Manager) ListSharedDrives(accountID, accountType string) ([]SharedDrive, error) {
+	var out struct {
+		Result []SharedDrive `json:"result"`
+	}
+	err := m.call("backend/command", map[string]interface{}{
+		"command": "drives",
+		"fs":      remoteName(accountID, accountType) + ":",
+	}, &out)
+	if err != nil {
+		return nil, err
 	}
+	return out.Result, nil
+}
 
-	return result.Total, result.Used, nil
+func (m *Manager) ListFiles(pool *models.StoragePool, account *models.Account) ([]ListItem, error) {
+	var out struct {
+		List []ListItem `json:"list"`
+	}
+	err := m.call("operations/list", map[string]interface{}{
+		"fs":     upstreamFs(pool, account),
+		"remote": "",
+		"opt": map[string]interface{}{
+			"recurse":    true,
+			"filesOnly":  true,
+			"noModTime":  true,
+			"noMimeType": true,
+		},
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.List, nil
 }
 
 func (m *Manager) TestConnection(accountID, accountType string) error {
-	remoteName := fmt.Sprintf("%s_%s:", accountType, accountID)
-	cmd := exec.Command("rclone", "lsd", remoteName,
-		"--max-depth", "1",
-		"--config", m.configPath)
-
-	return cmd.Run()
+	fs := remoteName(accountID, accountType) + ":"
+	return m.call("operations/list", map[string]interface{}{
+		"fs":     fs,
+		"remote": "",
+		"opt": map[string]interface{}{
+			"dirsOnly": true,
+		},
+	}, nil)
 }
 
 func (m *Manager) DeleteUnion(poolID string) error {
-	unionRemote := fmt.Sprintf("union_%s", poolID)
-	cmd := exec.Command("rclone", "config", "delete", unionRemote, "--config", m.configPath)
-	return cmd.Run()
+	return m.updateRemotes(func(all remotes) error {
+		all.deletePool(poolID)
+		all.pruneChunkers()
+		return nil
+	})
+}
+
+func (m *Manager) PruneRemotes(poolIDs []string) ([]string, error) {
+	keep := make(map[string]bool, len(poolIDs))
+	for _, id := range poolIDs {
+		keep[id] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.remotes.clone()
+	for name, params := range r {
+		if id, ok := strings.CutPrefix(name, "union_"); ok && params["type"] == "union" && !keep[id] {
+			r.deletePool(id)
+		}
+		if id, ok := layerPoolID(name); ok && (params["type"] == "crypt" || params["type"] == "compress") && !keep[id] {
+			r.deletePool(id)
+		}
+	}
+	r.pruneChunkers()
+
+	var removed []string
+	for name := range m.remotes {
+		if _, ok := r[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(removed)
+	if err := m.saveLocked(r); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// Rebuild replaces rclone.conf with the remotes of the given accounts and
+// the unions of the given pools, so the config matches the database.
+func (m *Manager) Rebuild(accounts []models.Account, pools []models.StoragePool) error {
+	saDir := m.serviceAccountDir()
+	keep := map[string]bool{}
+	for i := range accounts {
+		if accounts[i].AuthMethod != "service_account" {
+			continue
+		}
+		if err := writeServiceAccountFile(saDir, &accounts[i]); err != nil {
+			return err
+		}
+		keep[filepath.Base(serviceAccountFile(saDir, accounts[i].ID))] = true
+	}
+
+	r, err := buildRemotes(accounts, pools, saDir)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveLocked(r); err != nil {
+		return err
+	}
+
+	// Keys of accounts that no longer exist
+	entries, _ := os.ReadDir(saDir)
+	for _, entry := range entries {
+		if !keep[entry.Name()] {
+			os.Remove(filepath.Join(saDir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// PreviewConfig compares the config Rebuild would write with the one on disk.
+func (m *Manager) PreviewConfig(accounts []models.Account, pools []models.StoragePool) (*ConfigPreview, error) {
+	generated, err := buildRemotes(accounts, pools, m.serviceAccountDir())
+	if err != nil {
+		return nil, err
+	}
+	current, err := m.readConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rclone config: %w", err)
+	}
+	return newConfigPreview(generated, current), nil
+}
+
+// Stats returns the global transfer statistics of the rc daemon.
+func (m *Manager) Stats() (*Stats, error) {
+	var stats Stats
+	if err := m.call("core/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func remoteName(accountID, accountType string) string {
+	return fmt.Sprintf("%s_%s", accountType, accountID)
+}
+
+// baseRemoteName is the backend remote behind an aliased account remote.
+func baseRemoteName(name string) string {
+	return name + "_base"
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,592 @@
+package rclone
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"pooled-storage/internal/models"
+	"sort"
+	"sync"
+)
+
+// MemoryBackend is an in-memory StorageBackend. It keeps remotes, unions,
+// quotas and mounts in maps so services can be exercised without rclone.
+// Failures can be injected per operation with Fail.
+type MemoryBackend struct {
+	mountPath string
+
+	mu       sync.Mutex
+	remotes  map[string]models.Account
+	unions   map[string][]string
+	pools    map[string]models.StoragePool
+	mounts   map[string]string
+	serves   map[string]string // serve ID -> port
+	restarts map[string]bool   // pool IDs set with SetMountRestarting
+	dirs     map[string]bool   // pool IDs with a mount directory
+	quotas   map[string]About
+	drives   map[string][]SharedDrive
+	files    map[string][]ListItem
+	logical  map[string]int64 // pool sizes set with SetLogicalSize
+	bwlimit  string
+	failures map[string]error
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		mountPath: "/mnt/pooled-storage",
+		remotes:   make(map[string]models.Account),
+		unions:    make(map[string][]string),
+		pools:     make(map[string]models.StoragePool),
+		mounts:    make(map[string]string),
+		serves:    make(map[string]string),
+		restarts:  make(map[string]bool),
+		dirs:      make(map[string]bool),
+		quotas:    make(map[string]About),
+		drives:    make(map[string][]SharedDrive),
+		files:     make(map[string][]ListItem),
+		logical:   make(map[string]int64),
+		failures:  make(map[string]error),
+	}
+}
+
+// SetQuota sets the quota GetQuota reports for an account.
+func (b *MemoryBackend) SetQuota(accountID string, total, used int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.quotas[accountID] = About{Total: total, Used: used, Free: total - used}
+}
+
+// SetSharedDrives sets the shared drives ListSharedDrives reports for an account.
+func (b *MemoryBackend) SetSharedDrives(accountID string, drives []SharedDrive) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drives[accountID] = drives
+}
+
+// SetFiles sets the files ListFiles reports for an account.
+func (b *MemoryBackend) SetFiles(accountID string, files []ListItem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files[accountID] = files
+}
+
+// SetLogicalSize sets the logical size PoolSize reports for a pool. Without
+// it the logical size equals the stored size.
+func (b *MemoryBackend) SetLogicalSize(poolID string, bytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.logical[poolID] = bytes
+}
+
+// Fail makes every call to op (a StorageBackend method name such as
+// "MountPool") return err until ClearFailure is called.
+func (b *MemoryBackend) Fail(op string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures[op] = err
+}
+
+func (b *MemoryBackend) ClearFailure(op string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, op)
+}
+
+// HasRemote reports whether a remote exists for the account.
+func (b *MemoryBackend) HasRemote(accountID, accountType string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.remotes[remoteName(accountID, accountType)]
+	return ok
+}
+
+// Union returns the upstream remotes of a pool's union, if it exists.
+func (b *MemoryBackend) Union(poolID string) ([]string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	upstreams, ok := b.unions[poolID]
+	return append([]string(nil), upstreams...), ok
+}
+
+// must be called with b.mu held
+func (b *MemoryBackend) failure(op string) error {
+	return b.failures[op]
+}
+
+func (b *MemoryBackend) AddRemote(account *models.Account) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("AddRemote"); err != nil {
+		return err
+	}
+	b.remotes[remoteName(account.ID, account.Type)] = *account
+	return nil
+}
+
+func (b *MemoryBackend) RemoveRemote(accountID, accountType string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("RemoveRemote"); err != nil {
+		return err
+	}
+	delete(b.remotes, remoteName(accountID, accountType))
+	return nil
+}
+
+func (b *MemoryBackend) UpdateToken(account *models.Account) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("UpdateToken"); err != nil {
+		return err
+	}
+	name := remoteName(account.ID, account.Type)
+	if _, ok := b.remotes[name]; !ok {
+		return fmt.Errorf("remote %s not found", name)
+	}
+	b.remotes[name] = *account
+	return nil
+}
+
+func (b *MemoryBackend) TestConnection(accountID, accountType string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("TestConnection"); err != nil {
+		return err
+	}
+	if _, ok := b.remotes[remoteName(accountID, accountType)]; !ok {
+		return fmt.Errorf("remote %s not found", remoteName(accountID, accountType))
+	}
+	return nil
+}
+
+func (b *MemoryBackend) GetQuota(accountID, accountType string) (int64, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("GetQuota"); err != nil {
+		return 0, 0, err
+	}
+	if _, ok := b.remotes[remoteName(accountID, accountType)]; !ok {
+		return 0, 0, fmt.Errorf("remote %s not found", remoteName(accountID, accountType))
+	}
+	q := b.quotas[accountID]
+	return q.Total, q.Used, nil
+}
+
+func (b *MemoryBackend) GetUsage(accountID, accountType string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("GetUsage"); err != nil {
+		return 0, err
+	}
+	if _, ok := b.remotes[remoteName(accountID, accountType)]; !ok {
+		return 0, fmt.Errorf("remote %s not found", remoteName(accountID, accountType))
+	}
+	return b.quotas[accountID].Used, nil
+}
+
+func (b *MemoryBackend) ListSharedDrives(accountID, accountType string) ([]SharedDrive, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("ListSharedDrives"); err != nil {
+		return nil, err
+	}
+	if _, ok := b.remotes[remoteName(accountID, accountType)]; !ok {
+		return nil, fmt.Errorf("remote %s not found", remoteName(accountID, accountType))
+	}
+	return append([]SharedDrive(nil), b.drives[accountID]...), nil
+}
+
+func (b *MemoryBackend) ListFiles(pool *models.StoragePool, account *models.Account) ([]ListItem, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("ListFiles"); err != nil {
+		return nil, err
+	}
+	if _, ok := b.remotes[remoteName(account.ID, account.Type)]; !ok {
+		return nil, fmt.Errorf("remote %s not found", remoteName(account.ID, account.Type))
+	}
+	return append([]ListItem(nil), b.files[account.ID]...), nil
+}
+
+func (b *MemoryBackend) CreateUnion(pool *models.StoragePool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("CreateUnion"); err != nil {
+		return err
+	}
+	if len(pool.Accounts) == 0 {
+		return fmt.Errorf("no accounts in pool")
+	}
+	if pool.Encryption != "" && (pool.CryptPassword == "" || pool.CryptSalt == "") {
+		return fmt.Errorf("pool %s is encrypted but has no keys", pool.ID)
+	}
+
+	var upstreams []string
+	for _, account := range pool.Accounts {
+		name := remoteName(account.ID, account.Type)
+		if _, ok := b.remotes[name]; !ok {
+			return fmt.Errorf("remote %s not found", name)
+		}
+		upstream := name + ":"
+		if account.PoolMode == "ro" {
+			upstream += ":ro"
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	b.unions[pool.ID] = upstreams
+	b.pools[pool.ID] = *pool
+	return nil
+}
+
+func (b *MemoryBackend) DeleteUnion(poolID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("DeleteUnion"); err != nil {
+		return err
+	}
+	delete(b.unions, poolID)
+	delete(b.pools, poolID)
+	return nil
+}
+
+func (b *MemoryBackend) DrainMember(ctx context.Context, pool *models.StoragePool, account *models.Account, progress func(DrainProgress)) (*DrainResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("DrainMember"); err != nil {
+		return nil, err
+	}
+
+	var target string
+	for _, other := range pool.Accounts {
+		if other.ID != account.ID && other.PoolMode != "ro" {
+			target = other.ID
+			break
+		}
+	}
+	if target == "" {
+		return nil, fmt.Errorf("no other writable members to drain to")
+	}
+
+	files := b.files[account.ID]
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	progress(DrainProgress{Phase: "copying", TotalBytes: total, TotalFiles: int64(len(files))})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.files[target] = append(b.files[target], files...)
+	progress(DrainProgress{Phase: "copying", Bytes: total, TotalBytes: total, Files: int64(len(files)), TotalFiles: int64(len(files))})
+	progress(DrainProgress{Phase: "verifying"})
+	progress(DrainProgress{Phase: "deleting"})
+	delete(b.files, account.ID)
+	return &DrainResult{HashType: "md5"}, nil
+}
+
+// MoveFile moves the file between the accounts' SetFiles lists and adjusts
+// their quotas.
+func (b *MemoryBackend) MoveFile(ctx context.Context, pool *models.StoragePool, from, to *models.Account, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("MoveFile"); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	files := b.files[from.ID]
+	for i, file := range files {
+		if file.Path != path {
+			continue
+		}
+		b.files[from.ID] = append(files[:i:i], files[i+1:]...)
+		b.files[to.ID] = append(b.files[to.ID], file)
+		if q, ok := b.quotas[from.ID]; ok {
+			q.Used -= file.Size
+			q.Free += file.Size
+			b.quotas[from.ID] = q
+		}
+		if q, ok := b.quotas[to.ID]; ok {
+			q.Used += file.Size
+			q.Free -= file.Size
+			b.quotas[to.ID] = q
+		}
+		return nil
+	}
+	return fmt.Errorf("file %s not found on %s", path, from.ID)
+}
+
+func (b *MemoryBackend) PoolSize(ctx context.Context, pool *models.StoragePool) (*PoolSize, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("PoolSize"); err != nil {
+		return nil, err
+	}
+	if _, ok := b.unions[pool.ID]; !ok {
+		return nil, fmt.Errorf("union for pool %s not found", pool.ID)
+	}
+
+	size := &PoolSize{}
+	for _, account := range pool.Accounts {
+		for _, file := range b.files[account.ID] {
+			size.Files++
+			size.Stored += file.Size
+		}
+	}
+	size.Logical = size.Stored
+	if logical, ok := b.logical[pool.ID]; ok {
+		size.Logical = logical
+	}
+	return size, nil
+}
+
+func (b *MemoryBackend) SetBandwidthLimit(rate string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("SetBandwidthLimit"); err != nil {
+		return "", err
+	}
+	previous := b.bwlimit
+	b.bwlimit = rate
+	return previous, nil
+}
+
+// BandwidthLimit returns the limit last set with SetBandwidthLimit.
+func (b *MemoryBackend) BandwidthLimit() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bwlimit
+}
+
+func (b *MemoryBackend) PruneRemotes(poolIDs []string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("PruneRemotes"); err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(poolIDs))
+	for _, id := range poolIDs {
+		keep[id] = true
+	}
+
+	var removed []string
+	for id := range b.unions {
+		if !keep[id] {
+			delete(b.unions, id)
+			delete(b.pools, id)
+			removed = append(removed, fmt.Sprintf("union_%s", id))
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// memoryServiceAccountDir stands in for the key directory in rendered configs.
+const memoryServiceAccountDir = "/config/service-accounts"
+
+func (b *MemoryBackend) Rebuild(accounts []models.Account, pools []models.StoragePool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("Rebuild"); err != nil {
+		return err
+	}
+	if _, err := buildRemotes(accounts, pools, memoryServiceAccountDir); err != nil {
+		return err
+	}
+
+	b.remotes = make(map[string]models.Account, len(accounts))
+	for _, account := range accounts {
+		b.remotes[remoteName(account.ID, account.Type)] = account
+	}
+	b.unions = make(map[string][]string, len(pools))
+	b.pools = make(map[string]models.StoragePool, len(pools))
+	for _, pool := range pools {
+		var upstreams []string
+		for _, account := range pool.Accounts {
+			upstreams = append(upstreams, remoteName(account.ID, account.Type)+":")
+		}
+		b.unions[pool.ID] = upstreams
+		b.pools[pool.ID] = pool
+	}
+	return nil
+}
+
+func (b *MemoryBackend) PreviewConfig(accounts []models.Account, pools []models.StoragePool) (*ConfigPreview, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("PreviewConfig"); err != nil {
+		return nil, err
+	}
+
+	generated, err := buildRemotes(accounts, pools, memoryServiceAccountDir)
+	if err != nil {
+		return nil, err
+	}
+
+	currentAccounts := make([]models.Account, 0, len(b.remotes))
+	for _, account := range b.remotes {
+		currentAccounts = append(currentAccounts, account)
+	}
+	currentPools := make([]models.StoragePool, 0, len(b.pools))
+	for _, pool := range b.pools {
+		currentPools = append(currentPools, pool)
+	}
+	current, err := buildRemotes(currentAccounts, currentPools, memoryServiceAccountDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConfigPreview(generated, current), nil
+}
+
+func (b *MemoryBackend) PoolMountPath(poolID string) string {
+	return path.Join(b.mountPath, poolID)
+}
+
+func (b *MemoryBackend) MountPool(pool *models.StoragePool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("MountPool"); err != nil {
+		return err
+	}
+	if _, ok := b.unions[pool.ID]; !ok {
+		return fmt.Errorf("union for pool %s not found", pool.ID)
+	}
+	mountPath := b.PoolMountPath(pool.ID)
+	if _, ok := b.mounts[mountPath]; ok {
+		return fmt.Errorf("already mounted")
+	}
+	b.mounts[mountPath] = pool.ID
+	b.dirs[pool.ID] = true
+	return nil
+}
+
+func (b *MemoryBackend) UnmountPool(pool *models.StoragePool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("UnmountPool"); err != nil {
+		return err
+	}
+	if b.restarts[pool.ID] {
+		// Stopping the supervisor is all there is to do
+		delete(b.restarts, pool.ID)
+		return nil
+	}
+	mountPath := b.PoolMountPath(pool.ID)
+	if _, ok := b.mounts[mountPath]; !ok {
+		return fmt.Errorf("not mounted")
+	}
+	delete(b.mounts, mountPath)
+	return nil
+}
+
+// AddMountDir creates an empty mount directory for a pool.
+func (b *MemoryBackend) AddMountDir(poolID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dirs[poolID] = true
+}
+
+func (b *MemoryBackend) MountDirs() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("MountDirs"); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for id := range b.dirs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (b *MemoryBackend) RemoveMountDir(poolID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("RemoveMountDir"); err != nil {
+		return err
+	}
+	if _, ok := b.mounts[b.PoolMountPath(poolID)]; ok {
+		return fmt.Errorf("%s is mounted", b.PoolMountPath(poolID))
+	}
+	delete(b.dirs, poolID)
+	return nil
+}
+
+func (b *MemoryBackend) MountLogs(poolID string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("MountLogs"); err != nil {
+		return nil, err
+	}
+	return []string{}, nil
+}
+
+func (b *MemoryBackend) StartServe(pool *models.StoragePool, serve *models.PoolServe) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("StartServe"); err != nil {
+		return err
+	}
+	if _, ok := b.unions[pool.ID]; !ok {
+		return fmt.Errorf("union for pool %s not found", pool.ID)
+	}
+	port := fmt.Sprint(serve.Port)
+	for id, p := range b.serves {
+		if p == port && id != serve.ID {
+			return fmt.Errorf("port %s is already in use", port)
+		}
+	}
+	b.serves[serve.ID] = port
+	return nil
+}
+
+func (b *MemoryBackend) StopServe(serveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("StopServe"); err != nil {
+		return err
+	}
+	delete(b.serves, serveID)
+	return nil
+}
+
+func (b *MemoryBackend) ServeRunning(serveID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.serves[serveID]
+	return ok
+}
+
+// SetMountRestarting makes a pool's mount look like it died and is being
+// restarted by its supervisor, as it is while the pool isn't mounted.
+func (b *MemoryBackend) SetMountRestarting(poolID string, restarting bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.restarts[poolID] = restarting
+}
+
+func (b *MemoryBackend) MountRestarting(poolID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.restarts[poolID]
+}
+
+func (b *MemoryBackend) IsMounted(path string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.mounts[path]
+	return ok
+}
+
+func (b *MemoryBackend) Stats() (*Stats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.failure("Stats"); err != nil {
+		return nil, err
+	}
+	return &Stats{}, nil
+}
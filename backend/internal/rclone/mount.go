@@ -0,0 +1,333 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	mountReadyTimeout = 30 * time.Second
+	mountMinBackoff   = time.Second
+	mountMaxBackoff   = 5 * time.Minute
+	// Lines of rclone output kept per mount for the logs endpoint.
+	mountLogLines = 500
+)
+
+// rcloneProcess runs a long-lived rclone command, `rclone mount` or `rclone
+// serve`, and restarts it with exponential backoff if it exits while it
+// should be running.
+type rcloneProcess struct {
+	what string   // "mount" or "serve", for messages
+	args []string // after "rclone"
+	env  []string // added to ours, for secrets kept off the command line
+	logs *logBuffer
+	// ready reports whether the process is serving requests; clean, if set,
+	// undoes what a dead process left behind, before it is (re)started or
+	// after it was killed
+	ready func() bool
+	clean func(killed bool)
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{} // closed when cmd exits
+	stopping bool
+	quit     chan struct{} // closed by stop
+	done     chan struct{} // closed when supervision ends
+}
+
+func newMountProcess(mountPoint string, args []string, logs *logBuffer, isMounted func(string) bool) *rcloneProcess {
+	return &rcloneProcess{
+		what: "mount",
+		args: args,
+		logs: logs,
+		ready: func() bool {
+			if !isMounted(mountPoint) {
+				return false
+			}
+			_, err := os.ReadDir(mountPoint)
+			return err == nil
+		},
+		// A mount left behind by a crashed rclone doesn't count as mounted
+		// but makes the directory unusable until it is detached
+		clean: func(killed bool) {
+			if killed || !isMounted(mountPoint) {
+				exec.Command("fusermount", "-uz", mountPoint).Run()
+			}
+		},
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// newServeProcess supervises `rclone serve`, which is ready once addr
+// accepts connections.
+func newServeProcess(addr string, args, env []string, logs *logBuffer) *rcloneProcess {
+	return &rcloneProcess{
+		what: "serve",
+		args: args,
+		env:  env,
+		logs: logs,
+		ready: func() bool {
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		},
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// start launches rclone and waits until it is serving requests. The process
+// is supervised from then on.
+func (p *rcloneProcess) start() error {
+	p.mu.Lock()
+	err := p.spawn()
+	exited := p.exited
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := p.waitReady(exited); err != nil {
+		p.kill()
+		return err
+	}
+	go p.supervise()
+	return nil
+}
+
+// spawn must be called with p.mu held.
+func (p *rcloneProcess) spawn() error {
+	if p.clean != nil {
+		p.clean(false)
+	}
+
+	cmd := exec.Command("rclone", p.args...)
+	if len(p.env) > 0 {
+		cmd.Env = append(os.Environ(), p.env...)
+	}
+	cmd.Stdout = p.logs
+	cmd.Stderr = p.logs
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start rclone %s: %w", p.what, err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	p.cmd = cmd
+	p.exited = exited
+	return nil
+}
+
+// waitReady returns once the process is ready, or with an error carrying
+// rclone's last output if rclone exits first.
+func (p *rcloneProcess) waitReady(exited chan struct{}) error {
+	deadline := time.Now().Add(mountReadyTimeout)
+	for {
+		select {
+		case <-exited:
+			return fmt.Errorf("rclone %s exited: %s", p.what, p.logs.tail(5))
+		default:
+		}
+
+		if p.ready() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not ready after %s: %s", p.what, mountReadyTimeout, p.logs.tail(5))
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func (p *rcloneProcess) supervise() {
+	defer close(p.done)
+
+	backoff := mountMinBackoff
+	for {
+		p.mu.Lock()
+		exited := p.exited
+		p.mu.Unlock()
+
+		started := time.Now()
+		<-exited
+
+		p.mu.Lock()
+		if p.stopping {
+			p.mu.Unlock()
+			return
+		}
+		state := p.cmd.ProcessState
+		p.mu.Unlock()
+
+		if time.Since(started) > mountMaxBackoff {
+			backoff = mountMinBackoff
+		}
+		p.logs.Printf("exited unexpectedly (%v), restarting in %s", state, backoff)
+		select {
+		case <-p.quit:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > mountMaxBackoff {
+			backoff = mountMaxBackoff
+		}
+
+		p.mu.Lock()
+		if p.stopping {
+			p.mu.Unlock()
+			return
+		}
+		err := p.spawn()
+		exited = p.exited
+		p.mu.Unlock()
+		if err != nil {
+			p.logs.Print(err)
+			continue
+		}
+
+		if err := p.waitReady(exited); err != nil {
+			p.logs.Print(err)
+		}
+	}
+}
+
+// stop sends rclone SIGTERM, which makes it unmount or stop serving and
+// exit, and kills it if it has not exited before ctx expires. It may be
+// called more than once, e.g. by Close racing an unmount.
+func (p *rcloneProcess) stop(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopping {
+		p.stopping = true
+		close(p.quit)
+	}
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	cmd.Process.Signal(syscall.SIGTERM)
+
+	var err error
+	select {
+	case <-exited:
+	case <-ctx.Done():
+		err = ctx.Err()
+		cmd.Process.Kill()
+		<-exited
+		if p.clean != nil {
+			p.clean(true)
+		}
+	}
+
+	<-p.done
+	return err
+}
+
+// restarting reports whether the process is supervised and not being
+// stopped; while it isn't ready, supervise is backing off or waiting for a
+// new rclone.
+func (p *rcloneProcess) restarting() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.stopping
+}
+
+// kill stops a process that never became ready, before supervise started.
+func (p *rcloneProcess) kill() {
+	p.mu.Lock()
+	p.stopping = true
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	cmd.Process.Kill()
+	<-exited
+	if p.clean != nil {
+		p.clean(true)
+	}
+	close(p.done)
+}
+
+// logBuffer keeps the last lines written to it and copies every line to a
+// logger.
+type logBuffer struct {
+	logger *log.Logger
+	max    int
+
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+func newLogBuffer(max int, logger *log.Logger) *logBuffer {
+	return &logBuffer{logger: logger, max: max}
+}
+
+func (b *logBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.partial = append(b.partial, data...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		b.addLocked(strings.TrimRight(string(b.partial[:i]), "\r"))
+		b.partial = b.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+func (b *logBuffer) Print(v ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addLocked(fmt.Sprint(v...))
+}
+
+func (b *logBuffer) Printf(format string, v ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addLocked(fmt.Sprintf(format, v...))
+}
+
+// addLocked must be called with b.mu held.
+func (b *logBuffer) addLocked(line string) {
+	b.logger.Print(line)
+	b.lines = append(b.lines, time.Now().Format(time.RFC3339)+" "+line)
+	if len(b.lines) > b.max {
+		b.lines = b.lines[len(b.lines)-b.max:]
+	}
+}
+
+func (b *logBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
+
+// tail returns the last n lines joined for use in an error message.
+func (b *logBuffer) tail(n int) string {
+	lines := b.Lines()
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	if len(lines) == 0 {
+		return "no output"
+	}
+	return strings.Join(lines, "; ")
+}
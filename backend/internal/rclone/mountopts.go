@@ -0,0 +1,135 @@
+package rclone
+
+import (
+	"log"
+	"pooled-storage/internal/models"
+	"strconv"
+)
+
+// MountProfiles are the presets of mount options a pool can be mounted
+// with. "default" is how pools were always mounted.
+var MountProfiles = map[string]models.MountOptions{
+	"default": {
+		CacheMode: "writes",
+	},
+	// Streaming large files: cache whole files for a few days and read
+	// ahead generously
+	"media": {
+		CacheMode:    "full",
+		CacheMaxSize: "100G",
+		CacheMaxAge:  "72h",
+		BufferSize:   "32M",
+		ReadAhead:    "128M",
+		DirCacheTime: "1h",
+		PollInterval: "1m",
+	},
+	// Mostly writing new files: only cache what is being written and keep
+	// listings fresh so backup tools see their own changes
+	"backup": {
+		CacheMode:    "writes",
+		CacheMaxAge:  "1h",
+		BufferSize:   "16M",
+		DirCacheTime: "1m",
+	},
+}
+
+// Cache size pools that allow large files get unless their options set one.
+const largeFilesCacheMaxSize = "50G"
+
+// EffectiveMountOptions returns the options a pool is mounted with: its
+// profile with its own options on top.
+func EffectiveMountOptions(pool *models.StoragePool) models.MountOptions {
+	opts, ok := MountProfiles[pool.MountProfile]
+	if !ok {
+		opts = MountProfiles["default"]
+	}
+
+	o := pool.MountOptions
+	for _, f := range []struct{ from, to *string }{
+		{&o.CacheMode, &opts.CacheMode},
+		{&o.CacheMaxSize, &opts.CacheMaxSize},
+		{&o.CacheMaxAge, &opts.CacheMaxAge},
+		{&o.BufferSize, &opts.BufferSize},
+		{&o.ReadAhead, &opts.ReadAhead},
+		{&o.DirCacheTime, &opts.DirCacheTime},
+		{&o.Umask, &opts.Umask},
+		{&o.PollInterval, &opts.PollInterval},
+		{&o.BwLimit, &opts.BwLimit},
+	} {
+		if *f.from != "" {
+			*f.to = *f.from
+		}
+	}
+	if o.UID != nil {
+		opts.UID = o.UID
+	}
+	if o.GID != nil {
+		opts.GID = o.GID
+	}
+
+	if opts.CacheMaxSize == "" && pool.AllowLargeFiles {
+		opts.CacheMaxSize = largeFilesCacheMaxSize
+	}
+	return opts
+}
+
+// mountFlags turns mount options into `rclone mount` flags.
+func mountFlags(opts models.MountOptions) []string {
+	var args []string
+	for _, f := range []struct{ flag, value string }{
+		{"--vfs-cache-mode", opts.CacheMode},
+		{"--vfs-cache-max-size", opts.CacheMaxSize},
+		{"--vfs-cache-max-age", opts.CacheMaxAge},
+		{"--buffer-size", opts.BufferSize},
+		{"--vfs-read-ahead", opts.ReadAhead},
+		{"--dir-cache-time", opts.DirCacheTime},
+		{"--umask", opts.Umask},
+		{"--poll-interval", opts.PollInterval},
+		{"--bwlimit", opts.BwLimit},
+	} {
+		if f.value != "" {
+			args = append(args, f.flag, f.value)
+		}
+	}
+	if opts.UID != nil {
+		args = append(args, "--uid", strconv.Itoa(*opts.UID))
+	}
+	if opts.GID != nil {
+		args = append(args, "--gid", strconv.Itoa(*opts.GID))
+	}
+	return args
+}
+
+// rcVfsOpt turns mount options into the vfsOpt of mount/mount. Buffer size
+// and bandwidth limit are settings of the whole rc server, not of a mount,
+// so they are left to whoever runs it.
+func rcVfsOpt(poolID string, opts models.MountOptions) map[string]interface{} {
+	vfsOpt := map[string]interface{}{}
+	for _, f := range []struct{ key, value string }{
+		{"CacheMode", opts.CacheMode},
+		{"CacheMaxSize", opts.CacheMaxSize},
+		{"CacheMaxAge", opts.CacheMaxAge},
+		{"ReadAhead", opts.ReadAhead},
+		{"DirCacheTime", opts.DirCacheTime},
+		{"PollInterval", opts.PollInterval},
+	} {
+		if f.value != "" {
+			vfsOpt[f.key] = f.value
+		}
+	}
+	if opts.Umask != "" {
+		if umask, err := strconv.ParseInt(opts.Umask, 8, 32); err == nil {
+			vfsOpt["Umask"] = umask
+		}
+	}
+	if opts.UID != nil {
+		vfsOpt["UID"] = *opts.UID
+	}
+	if opts.GID != nil {
+		vfsOpt["GID"] = *opts.GID
+	}
+	if opts.BufferSize != "" || opts.BwLimit != "" {
+		log.Printf("Pool %s: buffer size and bandwidth limit are not applied by an external rc server", poolID)
+	}
+	return vfsOpt
+}
@@ -0,0 +1,47 @@
+package rclone
+
+import (
+	"pooled-storage/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestEffectiveMountOptions(t *testing.T) {
+	uid := 1000
+	tests := []struct {
+		name string
+		pool models.StoragePool
+		want models.MountOptions
+	}{
+		{"default", models.StoragePool{MountProfile: "default"}, models.MountOptions{CacheMode: "writes"}},
+		{"unknown profile", models.StoragePool{MountProfile: "gone"}, models.MountOptions{CacheMode: "writes"}},
+		{
+			"overrides on a profile",
+			models.StoragePool{MountProfile: "backup", MountOptions: models.MountOptions{CacheMode: "full", BwLimit: "5M:20M", UID: &uid}},
+			models.MountOptions{CacheMode: "full", CacheMaxAge: "1h", BufferSize: "16M", DirCacheTime: "1m", BwLimit: "5M:20M", UID: &uid},
+		},
+		{
+			"large files",
+			models.StoragePool{MountProfile: "default", AllowLargeFiles: true},
+			models.MountOptions{CacheMode: "writes", CacheMaxSize: largeFilesCacheMaxSize},
+		},
+		{
+			"large files keep the profile's cache size",
+			models.StoragePool{MountProfile: "media", AllowLargeFiles: true, MountOptions: models.MountOptions{ReadAhead: "256M"}},
+			models.MountOptions{CacheMode: "full", CacheMaxSize: "100G", CacheMaxAge: "72h", BufferSize: "32M",
+				ReadAhead: "256M", DirCacheTime: "1h", PollInterval: "1m"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveMountOptions(&tt.pool); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EffectiveMountOptions = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Merging never changes the profile itself
+	if !reflect.DeepEqual(MountProfiles["backup"], models.MountOptions{CacheMode: "writes", CacheMaxAge: "1h", BufferSize: "16M", DirCacheTime: "1m"}) {
+		t.Errorf("backup profile changed to %+v", MountProfiles["backup"])
+	}
+}
@@ -0,0 +1,150 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client talks to an rclone remote-control server (rclone rcd) over its
+// JSON API. Every rc method is a POST to /<method> with a JSON object body.
+type Client struct {
+	baseURL string
+	user    string
+	pass    string
+	http    *http.Client
+}
+
+// RCError is the error object returned by the rc server for failed calls.
+type RCError struct {
+	Path    string                 `json:"path"`
+	Status  int                    `json:"status"`
+	Message string                 `json:"error"`
+	Input   map[string]interface{} `json:"input"`
+}
+
+func (e *RCError) Error() string {
+	return fmt.Sprintf("rclone %s: %s (status %d)", e.Path, e.Message, e.Status)
+}
+
+type About struct {
+	Total   int64 `json:"total"`
+	Used    int64 `json:"used"`
+	Free    int64 `json:"free"`
+	Trashed int64 `json:"trashed"`
+	Other   int64 `json:"other"`
+	Objects int64 `json:"objects"`
+}
+
+type ListItem struct {
+	Path     string    `json:"Path"`
+	Name     string    `json:"Name"`
+	Size     int64     `json:"Size"`
+	MimeType string    `json:"MimeType"`
+	ModTime  time.Time `json:"ModTime"`
+	IsDir    bool      `json:"IsDir"`
+}
+
+type SharedDrive struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type Size struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+type Transfer struct {
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"`
+	Bytes      int64   `json:"bytes"`
+	Percentage int     `json:"percentage"`
+	Speed      float64 `json:"speed"`
+}
+
+type Stats struct {
+	Bytes          int64      `json:"bytes"`
+	TotalBytes     int64      `json:"totalBytes"`
+	Checks         int64      `json:"checks"`
+	Deletes        int64      `json:"deletes"`
+	Errors         int64      `json:"errors"`
+	FatalError     bool       `json:"fatalError"`
+	LastError      string     `json:"lastError,omitempty"`
+	Renames        int64      `json:"renames"`
+	Speed          float64    `json:"speed"`
+	Transfers      int64      `json:"transfers"`
+	TotalTransfers int64      `json:"totalTransfers"`
+	ElapsedTime    float64    `json:"elapsedTime"`
+	Eta            *float64   `json:"eta"`
+	Transferring   []Transfer `json:"transferring,omitempty"`
+}
+
+func NewClient(baseURL, user, pass string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		user:    user,
+		pass:    pass,
+		http:    &http.Client{},
+	}
+}
+
+// Call invokes an rc method with in as the JSON body and decodes the reply
+// into out (which may be nil). Failures reported by rclone are returned as
+// *RCError.
+func (c *Client) Call(ctx context.Context, method string, in, out interface{}) error {
+	if in == nil {
+		in = map[string]interface{}{}
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("rclone %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("rclone %s: %w", method, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		rcErr := &RCError{Path: method, Status: resp.StatusCode}
+		if json.Unmarshal(data, rcErr) != nil || rcErr.Message == "" {
+			rcErr.Message = strings.TrimSpace(string(data))
+		}
+		return rcErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return nil
+}
+
+// Ping checks that the rc server is reachable and accepting requests.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Call(ctx, "rc/noop", nil, nil)
+}
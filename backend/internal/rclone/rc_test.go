@@ -0,0 +1,287 @@
+package rclone_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"pooled-storage/internal/rclone/rctest"
+	"strings"
+	"testing"
+)
+
+func TestCallDecodesErrors(t *testing.T) {
+	srv := rctest.NewServer()
+	defer srv.Close()
+	srv.Fail("operations/about", "failed to get quota")
+
+	// Not every failure comes from rclone's error handler
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		method  string
+		status  int
+		message string
+	}{
+		{"rc error", srv.URL, "operations/about", http.StatusInternalServerError, "failed to get quota"},
+		{"unknown method", srv.URL, "no/such", http.StatusNotFound, `couldn't find method "no/such"`},
+		{"plain text", proxy.URL, "rc/noop", http.StatusBadGateway, "bad gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rclone.NewClient(tt.url, "", "").Call(context.Background(), tt.method, nil, nil)
+			var rcErr *rclone.RCError
+			if !errors.As(err, &rcErr) {
+				t.Fatalf("err = %v, want an *RCError", err)
+			}
+			if rcErr.Path != tt.method || rcErr.Status != tt.status || rcErr.Message != tt.message {
+				t.Errorf("err = %+v, want %s failing with %d %q", rcErr, tt.method, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestCallDecodesReply(t *testing.T) {
+	srv := rctest.NewServer()
+	defer srv.Close()
+
+	// rc/noop echoes its input
+	var out struct {
+		Rate string `json:"rate"`
+	}
+	c := rclone.NewClient(srv.URL+"/", "", "")
+	if err := c.Call(context.Background(), "rc/noop", map[string]interface{}{"rate": "1M"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Rate != "1M" {
+		t.Errorf("rate = %q, want 1M", out.Rate)
+	}
+}
+
+// newManager returns a manager using srv as its rc server.
+func newManager(t *testing.T, srv *rctest.Server) *rclone.Manager {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "rclone.conf")
+	t.Setenv("RCLONE_RC_URL", srv.URL)
+	t.Setenv("RCLONE_CONFIG_PATH", configPath)
+	t.Setenv("MOUNT_PATH", t.TempDir())
+	srv.SetConfigPath(configPath)
+	return rclone.NewManager()
+}
+
+func TestBackgroundJobs(t *testing.T) {
+	a := models.Account{ID: "a", Type: "google", AccessToken: "token"}
+	b := models.Account{ID: "b", Type: "google", AccessToken: "token"}
+	pool := &models.StoragePool{ID: "p", Strategy: "eplus", Accounts: []models.Account{a, b}}
+
+	tests := []struct {
+		name    string
+		setup   func(srv *rctest.Server, m *rclone.Manager)
+		wantErr string
+		polled  bool // whether the job got started
+	}{
+		{"success", func(srv *rctest.Server, m *rclone.Manager) {
+			m.AddRemote(&a)
+			m.AddRemote(&b)
+		}, "", true},
+		// The job starts but fails; its error comes from job/status
+		{"job fails", func(srv *rctest.Server, m *rclone.Manager) {
+			m.AddRemote(&a)
+		}, `didn't find section in config file ("google_b")`, true},
+		{"status fails", func(srv *rctest.Server, m *rclone.Manager) {
+			m.AddRemote(&a)
+			m.AddRemote(&b)
+			srv.Fail("job/status", "job not found")
+		}, "job not found", true},
+		{"start fails", func(srv *rctest.Server, m *rclone.Manager) {
+			srv.Fail("operations/movefile", "no way")
+		}, "no way", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := rctest.NewServer()
+			defer srv.Close()
+			m := newManager(t, srv)
+			tt.setup(srv, m)
+
+			err := m.MoveFile(context.Background(), pool, &a, &b, "dir/file")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("MoveFile: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("MoveFile: err = %v, want %q", err, tt.wantErr)
+			}
+
+			polled := false
+			for _, call := range srv.Calls() {
+				polled = polled || call == "job/status"
+			}
+			if polled != tt.polled {
+				t.Errorf("job/status called: %v, want %v", polled, tt.polled)
+			}
+		})
+	}
+}
+
+func TestManagerRemotes(t *testing.T) {
+	srv := rctest.NewServer()
+	defer srv.Close()
+	m := newManager(t, srv)
+
+	account := &models.Account{ID: "a", Type: "google", AccessToken: "token"}
+	if err := m.TestConnection(account.ID, account.Type); err == nil {
+		t.Error("connection to a missing remote succeeded")
+	}
+
+	if err := m.AddRemote(account); err != nil {
+		t.Fatal(err)
+	}
+	if params, ok := srv.Remote("google_a"); !ok || params["type"] != "drive" {
+		t.Errorf("remote google_a = %v, want a drive remote", params)
+	}
+	srv.SetAbout("google_a:", 100, 40)
+	total, used, err := m.GetQuota(account.ID, account.Type)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 100 || used != 40 {
+		t.Errorf("quota = %d/%d, want 40/100", used, total)
+	}
+
+	if err := m.RemoveRemote(account.ID, account.Type); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Remote("google_a"); ok {
+		t.Error("removed remote still exists")
+	}
+	if _, _, err := m.GetQuota(account.ID, account.Type); !strings.Contains(fmt.Sprint(err), "didn't find section") {
+		t.Errorf("quota of a removed remote: err = %v", err)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	srv := rctest.NewServer()
+	defer srv.Close()
+	m := newManager(t, srv)
+
+	a := models.Account{ID: "a", Type: "google", AccessToken: "token"}
+	b := models.Account{ID: "b", Type: "google", AccessToken: "token"}
+	pool := models.StoragePool{ID: "p", Strategy: "eplus", EnableChunker: true, ChunkSize: "100M",
+		Accounts: []models.Account{a, b}}
+
+	if err := m.Rebuild([]models.Account{a, b}, []models.StoragePool{pool}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"google_a", "google_b", "chunk_p_a", "chunk_p_b", "union_p"} {
+		if _, ok := srv.Remote(name); !ok {
+			t.Errorf("%s missing after rebuild", name)
+		}
+	}
+
+	// Chunkers of a pool that no longer uses them and remotes of removed
+	// accounts don't survive the next rebuild
+	pool.EnableChunker = false
+	pool.Accounts = []models.Account{a}
+	if err := m.Rebuild([]models.Account{a}, []models.StoragePool{pool}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"google_b", "chunk_p_a", "chunk_p_b"} {
+		if _, ok := srv.Remote(name); ok {
+			t.Errorf("%s left over after rebuild", name)
+		}
+	}
+	if union, _ := srv.Remote("union_p"); union["upstreams"] != "google_a:" {
+		t.Errorf("union upstreams = %q, want google_a:", union["upstreams"])
+	}
+
+	// Deleting a union takes its chunkers along
+	pool.EnableChunker = true
+	if err := m.CreateUnion(&pool); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Remote("chunk_p_a"); !ok {
+		t.Fatal("chunk_p_a missing after creating a chunked union")
+	}
+	if err := m.DeleteUnion(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"union_p", "chunk_p_a"} {
+		if _, ok := srv.Remote(name); ok {
+			t.Errorf("%s left over after deleting the union", name)
+		}
+	}
+	if _, ok := srv.Remote("google_a"); !ok {
+		t.Error("deleting the union removed an account remote")
+	}
+}
+
+func TestCloseExternal(t *testing.T) {
+	srv := rctest.NewServer()
+	defer srv.Close()
+	m := newManager(t, srv)
+
+	a := models.Account{ID: "a", Type: "google", AccessToken: "token"}
+	pool := models.StoragePool{ID: "p", Strategy: "eplus", Accounts: []models.Account{a}}
+	if err := m.Rebuild([]models.Account{a}, []models.StoragePool{pool}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MountPool(&pool); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartServe(&pool, &models.PoolServe{ID: "s", PoolID: pool.ID, Protocol: "webdav", Port: 8081}); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Mounts()) != 1 || len(srv.Serves()) != 1 {
+		t.Fatalf("rc server runs %d mounts and %d serves, want one of each", len(srv.Mounts()), len(srv.Serves()))
+	}
+
+	// The rc server outlives us, what we started on it must not
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if mounts := srv.Mounts(); len(mounts) != 0 {
+		t.Errorf("mounts left after close: %v", mounts)
+	}
+	if serves := srv.Serves(); len(serves) != 0 {
+		t.Errorf("serves left after close: %v", serves)
+	}
+	if m.ServeRunning("s") {
+		t.Error("serve still running after close")
+	}
+}
+
+func TestPoolSize(t *testing.T) {
+	srv := rctest.NewServer()
+	defer srv.Close()
+	m := newManager(t, srv)
+
+	a := models.Account{ID: "a", Type: "google", AccessToken: "token"}
+	b := models.Account{ID: "b", Type: "google", AccessToken: "token"}
+	pool := models.StoragePool{ID: "p", Strategy: "eplus", EnableChunker: true, ChunkSize: "100M",
+		Accounts: []models.Account{a, b}}
+	if err := m.Rebuild([]models.Account{a, b}, []models.StoragePool{pool}); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetAbout("union_p:", 0, 50)
+	srv.SetAbout("google_a:", 0, 30)
+	srv.SetAbout("google_b:", 0, 40)
+
+	// Members are measured as stored, not through the chunker the union
+	// reads them with
+	size, err := m.PoolSize(context.Background(), &pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Logical != 50 || size.Stored != 70 {
+		t.Errorf("size = %d logical, %d stored; want 50 and 70", size.Logical, size.Stored)
+	}
+}
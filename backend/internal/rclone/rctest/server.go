@@ -0,0 +1,646 @@
+// Package rctest provides a local stand-in for the rclone remote-control
+// server so the rclone manager can be exercised without rclone or real cloud
+// accounts. Point the manager at it with RCLONE_RC_URL=<Server.URL>.
+package rctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"pooled-storage/internal/rclone"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type About struct {
+	Total int64 `json:"total"`
+	Used  int64 `json:"used"`
+	Free  int64 `json:"free"`
+}
+
+type SharedDrive struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// Server is an in-memory rc server. Remotes, quotas and mounts only exist in
+// its maps; nothing touches the network. Like rcd it also finds remotes in
+// the config file set with SetConfigPath.
+type Server struct {
+	URL string
+
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	configPath string
+	remotes    map[string]map[string]string
+	abouts     map[string]About
+	drives     map[string][]SharedDrive
+	mounts     map[string]string
+	serves     map[string]string // serve ID -> addr
+	failures   map[string]string
+	calls      []string
+	bwlimit    string
+	jobs       []map[string]interface{} // job/status answers, by job ID - 1
+}
+
+type handlerFunc func(in map[string]interface{}) (interface{}, error)
+
+func NewServer() *Server {
+	s := &Server{
+		remotes:  make(map[string]map[string]string),
+		abouts:   make(map[string]About),
+		drives:   make(map[string][]SharedDrive),
+		mounts:   make(map[string]string),
+		serves:   make(map[string]string),
+		failures: make(map[string]string),
+	}
+
+	handlers := map[string]handlerFunc{
+		"rc/noop":             s.noop,
+		"core/quit":           s.noop,
+		"core/version":        s.coreVersion,
+		"core/stats":          s.coreStats,
+		"config/create":       s.configCreate,
+		"config/update":       s.configUpdate,
+		"config/delete":       s.configDelete,
+		"config/get":          s.configGet,
+		"config/dump":         s.configDump,
+		"config/listremotes":  s.configListRemotes,
+		"fscache/clear":       s.noop,
+		"operations/about":    s.operationsAbout,
+		"operations/list":     s.operationsList,
+		"operations/size":     s.operationsSize,
+		"backend/command":     s.backendCommand,
+		"mount/mount":         s.mountMount,
+		"mount/unmount":       s.mountUnmount,
+		"mount/unmountall":    s.mountUnmountAll,
+		"mount/listmounts":    s.mountListMounts,
+		"serve/start":         s.serveStart,
+		"serve/stop":          s.serveStop,
+		"serve/list":          s.serveList,
+		"sync/copy":           s.syncTransfer,
+		"sync/move":           s.syncTransfer,
+		"operations/check":    s.operationsCheck,
+		"job/status":          s.jobStatus,
+		"job/stop":            s.noop,
+		"operations/movefile": s.syncTransfer,
+		"core/bwlimit":        s.coreBwlimit,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		in := map[string]interface{}{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				writeError(w, method, in, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		s.mu.Lock()
+		s.calls = append(s.calls, method)
+		failure, failed := s.failures[method]
+		s.mu.Unlock()
+
+		if failed {
+			writeError(w, method, in, http.StatusInternalServerError, failure)
+			return
+		}
+
+		h, ok := handlers[method]
+		if !ok {
+			writeError(w, method, in, http.StatusNotFound, "couldn't find method \""+method+"\"")
+			return
+		}
+
+		// Background jobs run to completion right away; job/status reports
+		// how they went
+		if async, _ := in["_async"].(bool); async {
+			out, err := h(in)
+			job := map[string]interface{}{"finished": true, "success": err == nil, "output": out}
+			if err != nil {
+				job["error"] = err.Error()
+			}
+			s.mu.Lock()
+			s.jobs = append(s.jobs, job)
+			job["id"] = len(s.jobs)
+			s.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"jobid": job["id"]})
+			return
+		}
+
+		out, err := h(in)
+		if err != nil {
+			writeError(w, method, in, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if out == nil {
+			out = map[string]interface{}{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetAbout sets the quota returned by operations/about for fs (e.g. "google_<id>:").
+func (s *Server) SetAbout(fs string, total, used int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abouts[fs] = About{Total: total, Used: used, Free: total - used}
+}
+
+// SetSharedDrives sets what the "drives" backend command returns for fs.
+func (s *Server) SetSharedDrives(fs string, drives []SharedDrive) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drives[fs] = drives
+}
+
+// Fail makes every call to method return an rc error with msg until
+// ClearFailure is called.
+func (s *Server) Fail(method, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = msg
+}
+
+func (s *Server) ClearFailure(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, method)
+}
+
+// SetConfigPath makes remotes in an rclone.conf visible, as rcd --config
+// does. The file is reread on every lookup.
+func (s *Server) SetConfigPath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configPath = path
+}
+
+// Remote returns a copy of the parameters of a configured remote.
+func (s *Server) Remote(name string) (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	params, ok := s.lookupRemote(name)
+	if !ok {
+		return nil, false
+	}
+	cp := make(map[string]string, len(params))
+	for k, v := range params {
+		cp[k] = v
+	}
+	return cp, true
+}
+
+// must be called with s.mu held
+func (s *Server) lookupRemote(name string) (map[string]string, bool) {
+	if params, ok := s.remotes[name]; ok {
+		return params, true
+	}
+	for _, section := range s.fileRemotes() {
+		if section.Name == name {
+			return section.Params, true
+		}
+	}
+	return nil, false
+}
+
+// must be called with s.mu held
+func (s *Server) fileRemotes() []rclone.ConfigSection {
+	if s.configPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return nil
+	}
+	sections, err := rclone.ParseConfig(data, os.Getenv("RCLONE_CONFIG_PASS"))
+	if err != nil {
+		return nil
+	}
+	return sections
+}
+
+// Mounts returns mount point -> fs for all active mounts.
+func (s *Server) Mounts() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := make(map[string]string, len(s.mounts))
+	for k, v := range s.mounts {
+		cp[k] = v
+	}
+	return cp
+}
+
+// Serves returns serve ID -> addr for all running serves.
+func (s *Server) Serves() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := make(map[string]string, len(s.serves))
+	for k, v := range s.serves {
+		cp[k] = v
+	}
+	return cp
+}
+
+// Calls returns the rc methods invoked so far, in order.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func writeError(w http.ResponseWriter, method string, in map[string]interface{}, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  msg,
+		"input":  in,
+		"path":   method,
+		"status": status,
+	})
+}
+
+func stringParam(in map[string]interface{}, key string) (string, error) {
+	v, ok := in[key].(string)
+	if !ok || v == "" {
+		return "", fmt.Errorf("Didn't find key %q in input", key)
+	}
+	return v, nil
+}
+
+func remoteOf(fs string) string {
+	if i := strings.Index(fs, ":"); i >= 0 {
+		return fs[:i]
+	}
+	return fs
+}
+
+func (s *Server) noop(in map[string]interface{}) (interface{}, error) {
+	return in, nil
+}
+
+func (s *Server) coreVersion(in map[string]interface{}) (interface{}, error) {
+	return map[string]interface{}{"version": "v1.66.0-rctest"}, nil
+}
+
+func (s *Server) coreStats(in map[string]interface{}) (interface{}, error) {
+	return map[string]interface{}{
+		"bytes":          0,
+		"checks":         0,
+		"errors":         0,
+		"speed":          0,
+		"transfers":      0,
+		"totalTransfers": 0,
+		"elapsedTime":    0,
+	}, nil
+}
+
+func (s *Server) setParams(name string, params map[string]string, in map[string]interface{}) {
+	raw, _ := in["parameters"].(map[string]interface{})
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			params[k] = v
+		default:
+			b, _ := json.Marshal(v)
+			params[k] = string(b)
+		}
+	}
+	s.remotes[name] = params
+}
+
+func (s *Server) configCreate(in map[string]interface{}) (interface{}, error) {
+	name, err := stringParam(in, "name")
+	if err != nil {
+		return nil, err
+	}
+	remoteType, err := stringParam(in, "type")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setParams(name, map[string]string{"type": remoteType}, in)
+	return nil, nil
+}
+
+func (s *Server) configUpdate(in map[string]interface{}) (interface{}, error) {
+	name, err := stringParam(in, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	params, ok := s.remotes[name]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find remote %q", name)
+	}
+	s.setParams(name, params, in)
+	return nil, nil
+}
+
+func (s *Server) configDelete(in map[string]interface{}) (interface{}, error) {
+	name, err := stringParam(in, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.remotes, name)
+	return nil, nil
+}
+
+func (s *Server) configGet(in map[string]interface{}) (interface{}, error) {
+	name, err := stringParam(in, "name")
+	if err != nil {
+		return nil, err
+	}
+	params, _ := s.Remote(name)
+	if params == nil {
+		params = map[string]string{}
+	}
+	return params, nil
+}
+
+func (s *Server) configDump(in map[string]interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dump := make(map[string]map[string]string, len(s.remotes))
+	for _, section := range s.fileRemotes() {
+		dump[section.Name] = section.Params
+	}
+	for name, params := range s.remotes {
+		cp := make(map[string]string, len(params))
+		for k, v := range params {
+			cp[k] = v
+		}
+		dump[name] = cp
+	}
+	return dump, nil
+}
+
+func (s *Server) configListRemotes(in map[string]interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.remotes))
+	for name := range s.remotes {
+		names = append(names, name)
+	}
+	for _, section := range s.fileRemotes() {
+		if _, ok := s.remotes[section.Name]; !ok {
+			names = append(names, section.Name)
+		}
+	}
+	sort.Strings(names)
+	return map[string]interface{}{"remotes": names}, nil
+}
+
+func (s *Server) requireRemote(fs string) error {
+	// Remotes defined inline (":backend,opt=value:path") need no config
+	if strings.HasPrefix(fs, ":") {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lookupRemote(remoteOf(fs)); !ok {
+		return fmt.Errorf("didn't find section in config file (%q)", remoteOf(fs))
+	}
+	return nil
+}
+
+func (s *Server) operationsAbout(in map[string]interface{}) (interface{}, error) {
+	fs, err := stringParam(in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireRemote(fs); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.abouts[fs], nil
+}
+
+func (s *Server) operationsList(in map[string]interface{}) (interface{}, error) {
+	fs, err := stringParam(in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireRemote(fs); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"list": []interface{}{}}, nil
+}
+
+func (s *Server) syncTransfer(in map[string]interface{}) (interface{}, error) {
+	for _, key := range []string{"srcFs", "dstFs"} {
+		fs, err := stringParam(in, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.requireRemote(fs); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) coreBwlimit(in map[string]interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rate, ok := in["rate"].(string); ok {
+		if rate == "off" {
+			rate = ""
+		}
+		s.bwlimit = rate
+	}
+	rate := s.bwlimit
+	if rate == "" {
+		rate = "off"
+	}
+	return map[string]interface{}{"rate": rate}, nil
+}
+
+func (s *Server) operationsCheck(in map[string]interface{}) (interface{}, error) {
+	if _, err := s.syncTransfer(in); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "status": "0 differences found", "hashType": "md5"}, nil
+}
+
+func (s *Server) jobStatus(in map[string]interface{}) (interface{}, error) {
+	id, ok := in["jobid"].(float64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok || id < 1 || int(id) > len(s.jobs) {
+		return nil, fmt.Errorf("job not found")
+	}
+	return s.jobs[int(id)-1], nil
+}
+
+func (s *Server) operationsSize(in map[string]interface{}) (interface{}, error) {
+	fs, err := stringParam(in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireRemote(fs); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{"count": 0, "bytes": s.abouts[fs].Used}, nil
+}
+
+func (s *Server) backendCommand(in map[string]interface{}) (interface{}, error) {
+	command, err := stringParam(in, "command")
+	if err != nil {
+		return nil, err
+	}
+	fs, err := stringParam(in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireRemote(fs); err != nil {
+		return nil, err
+	}
+	if command != "drives" {
+		return nil, fmt.Errorf("command not found")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drives := s.drives[fs]
+	if drives == nil {
+		drives = []SharedDrive{}
+	}
+	return map[string]interface{}{"result": drives}, nil
+}
+
+func (s *Server) mountMount(in map[string]interface{}) (interface{}, error) {
+	fs, err := stringParam(in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	mountPoint, err := stringParam(in, "mountPoint")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireRemote(fs); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mounts[mountPoint]; ok {
+		return nil, fmt.Errorf("mount point %s already in use", mountPoint)
+	}
+	s.mounts[mountPoint] = fs
+	return nil, nil
+}
+
+func (s *Server) mountUnmount(in map[string]interface{}) (interface{}, error) {
+	mountPoint, err := stringParam(in, "mountPoint")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mounts[mountPoint]; !ok {
+		return nil, fmt.Errorf("mount not found")
+	}
+	delete(s.mounts, mountPoint)
+	return nil, nil
+}
+
+func (s *Server) mountUnmountAll(in map[string]interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mounts = make(map[string]string)
+	return nil, nil
+}
+
+func (s *Server) mountListMounts(in map[string]interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var points []map[string]string
+	for mountPoint, fs := range s.mounts {
+		points = append(points, map[string]string{"Fs": fs, "MountPoint": mountPoint})
+	}
+	return map[string]interface{}{"mountPoints": points}, nil
+}
+
+func (s *Server) serveStart(in map[string]interface{}) (interface{}, error) {
+	if _, err := stringParam(in, "type"); err != nil {
+		return nil, err
+	}
+	fs, err := stringParam(in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := stringParam(in, "addr")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireRemote(fs); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.serves {
+		if a == addr {
+			return nil, fmt.Errorf("listen tcp %s: bind: address already in use", addr)
+		}
+	}
+	id := fmt.Sprintf("serve-%d", len(s.calls))
+	s.serves[id] = addr
+	return map[string]interface{}{"id": id, "addr": addr}, nil
+}
+
+func (s *Server) serveStop(in map[string]interface{}) (interface{}, error) {
+	id, err := stringParam(in, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.serves[id]; !ok {
+		return nil, fmt.Errorf("couldn't find backend with id %q", id)
+	}
+	delete(s.serves, id)
+	return nil, nil
+}
+
+func (s *Server) serveList(in map[string]interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]string{}
+	for id, addr := range s.serves {
+		list = append(list, map[string]string{"id": id, "addr": addr})
+	}
+	return map[string]interface{}{"list": list}, nil
+}
@@ -0,0 +1,177 @@
+package rclone
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"pooled-storage/internal/models"
+	"strconv"
+	"time"
+)
+
+// ServeProtocols are the `rclone serve` protocols a pool can be served over.
+var ServeProtocols = []string{"webdav", "sftp", "ftp", "http", "s3"}
+
+// serveCacheDir is where a serve keeps its VFS cache. Each serve gets its
+// own, apart from the mount of its pool: two VFS sharing a cache directory
+// clobber each other's files.
+func serveCacheDir(serveID string) string {
+	base := os.Getenv("RCLONE_CACHE_DIR")
+	if base == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			base = filepath.Join(dir, "rclone")
+		} else {
+			base = filepath.Join(os.TempDir(), "rclone")
+		}
+	}
+	return filepath.Join(base, "serve-"+serveID)
+}
+
+// serveArgs returns the rclone flags of a serve and the environment carrying
+// its credentials, so they don't show up in the process list.
+func serveArgs(serve *models.PoolServe) ([]string, []string) {
+	args := []string{"--vfs-cache-mode", "writes", "--cache-dir", serveCacheDir(serve.ID)}
+	if serve.ReadOnly {
+		args = append(args, "--read-only")
+	}
+
+	var env []string
+	switch {
+	case serve.Username == "" && serve.Protocol == "sftp":
+		// rclone serve sftp refuses to start without credentials otherwise
+		args = append(args, "--no-auth")
+	case serve.Username == "":
+	case serve.Protocol == "s3":
+		env = append(env, "RCLONE_AUTH_KEY="+serve.Username+","+serve.Password)
+	default:
+		env = append(env, "RCLONE_USER="+serve.Username, "RCLONE_PASS="+serve.Password)
+	}
+	return args, env
+}
+
+// serveAddr is the address rclone listens on; dialAddr is where we check
+// that it does.
+func serveAddr(serve *models.PoolServe) (addr, dialAddr string) {
+	port := strconv.Itoa(serve.Port)
+	addr = net.JoinHostPort(serve.BindAddress, port)
+	switch serve.BindAddress {
+	case "", "0.0.0.0", "::":
+		return addr, net.JoinHostPort("localhost", port)
+	}
+	return addr, addr
+}
+
+// StartServe serves a pool over the serve's protocol. With our own daemon
+// it runs a supervised `rclone serve` process, which is restarted if it
+// dies; an external rc server serves with serve/start.
+func (m *Manager) StartServe(pool *models.StoragePool, serve *models.PoolServe) error {
+	addr, dialAddr := serveAddr(serve)
+	flags, env := serveArgs(serve)
+
+	if m.daemon == nil {
+		return m.rcServe(pool, serve, addr)
+	}
+
+	args := append([]string{"serve", serve.Protocol, poolRemote(pool),
+		"--addr", addr,
+		"--config", m.configPath,
+		"--log-level", "INFO",
+	}, flags...)
+
+	m.mountsMu.Lock()
+	old := m.serves[serve.ID]
+	delete(m.serves, serve.ID)
+	logs, ok := m.serveLogs[serve.ID]
+	if !ok {
+		logs = newLogBuffer(mountLogLines, log.New(os.Stderr, fmt.Sprintf("[rclone serve %s %s] ", serve.Protocol, serve.ID), log.LstdFlags))
+		m.serveLogs[serve.ID] = logs
+	}
+	m.mountsMu.Unlock()
+
+	if old != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		old.stop(ctx)
+		cancel()
+	}
+
+	p := newServeProcess(dialAddr, args, env, logs)
+	if err := p.start(); err != nil {
+		return fmt.Errorf("failed to serve over %s: %w", serve.Protocol, err)
+	}
+
+	m.mountsMu.Lock()
+	m.serves[serve.ID] = p
+	m.mountsMu.Unlock()
+	return nil
+}
+
+// rcServe starts a serve on the rc server and remembers the ID it is known
+// by there. The cache directory is a setting of the whole rc server, shared
+// with the pool's mount, so these serves don't cache.
+func (m *Manager) rcServe(pool *models.StoragePool, serve *models.PoolServe, addr string) error {
+	params := map[string]interface{}{
+		"type":           serve.Protocol,
+		"fs":             poolRemote(pool),
+		"addr":           addr,
+		"vfs_cache_mode": "off",
+		"read_only":      serve.ReadOnly,
+	}
+	switch {
+	case serve.Username == "" && serve.Protocol == "sftp":
+		params["no_auth"] = true
+	case serve.Username == "":
+	case serve.Protocol == "s3":
+		params["auth_key"] = []string{serve.Username + "," + serve.Password}
+	default:
+		params["user"] = serve.Username
+		params["pass"] = serve.Password
+	}
+
+	var out struct {
+		ID string `json:"id"`
+	}
+	if err := m.call("serve/start", params, &out); err != nil {
+		return fmt.Errorf("failed to serve over %s: %w", serve.Protocol, err)
+	}
+
+	m.mountsMu.Lock()
+	m.rcServes[serve.ID] = out.ID
+	m.mountsMu.Unlock()
+	return nil
+}
+
+// StopServe stops serving. Stopping a serve that isn't running is not an
+// error.
+func (m *Manager) StopServe(serveID string) error {
+	m.mountsMu.Lock()
+	p := m.serves[serveID]
+	delete(m.serves, serveID)
+	rcID, ok := m.rcServes[serveID]
+	delete(m.rcServes, serveID)
+	m.mountsMu.Unlock()
+
+	if p != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return p.stop(ctx)
+	}
+	if ok {
+		if err := m.call("serve/stop", map[string]interface{}{"id": rcID}, nil); err != nil {
+			return fmt.Errorf("failed to stop serve: %w", err)
+		}
+	}
+	return nil
+}
+
+// ServeRunning reports whether a serve was started and not stopped. A
+// supervised process that died counts as running while it is restarted.
+func (m *Manager) ServeRunning(serveID string) bool {
+	m.mountsMu.Lock()
+	defer m.mountsMu.Unlock()
+	_, supervised := m.serves[serveID]
+	_, started := m.rcServes[serveID]
+	return supervised || started
+}
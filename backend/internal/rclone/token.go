@@ -0,0 +1,28 @@
+package rclone
+
+import (
+	"encoding/json"
+	"pooled-storage/internal/models"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenJSON renders an account's OAuth token in the JSON form rclone stores
+// in the `token` option of OAuth backends.
+func tokenJSON(account *models.Account) (string, error) {
+	tokenType := account.TokenType
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+
+	data, err := json.Marshal(&oauth2.Token{
+		AccessToken:  account.AccessToken,
+		TokenType:    tokenType,
+		RefreshToken: account.RefreshToken,
+		Expiry:       account.TokenExpiry,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
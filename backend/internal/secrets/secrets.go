@@ -0,0 +1,158 @@
+// Package secrets seals sensitive database columns (OAuth tokens, client
+// secrets) with AES-256-GCM under a master key.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sealed values carry this prefix; anything without it is treated as legacy
+// plaintext so existing databases keep working until they are re-sealed.
+const sealedPrefix = "enc:v1:"
+
+const KeySize = 32
+
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts a value for storage. Empty values stay empty.
+func (s *Sealer) Seal(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal. Values without the sealed prefix
+// are returned unchanged.
+func (s *Sealer) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("malformed sealed value: %w", err)
+	}
+	if len(data) < s.aead.NonceSize() {
+		return "", errors.New("malformed sealed value: too short")
+	}
+
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt sealed value: wrong master key or corrupted data")
+	}
+
+	return string(plaintext), nil
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// KeyFilePath is where the master key lives when MASTER_KEY is not set.
+func KeyFilePath() string {
+	if path := os.Getenv("MASTER_KEY_FILE"); path != "" {
+		return path
+	}
+	return "./data/master.key"
+}
+
+// LoadKey reads the master key from MASTER_KEY (base64) or the key file,
+// generating a new key file on first start.
+func LoadKey() ([]byte, error) {
+	if encoded := os.Getenv("MASTER_KEY"); encoded != "" {
+		return DecodeKey(encoded)
+	}
+
+	path := KeyFilePath()
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return DecodeKey(string(data))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteKeyFile(path, key); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated new master key at %s - back it up, stored tokens cannot be read without it", path)
+
+	return key, nil
+}
+
+// WriteKeyFile atomically writes a key file readable only by the owner.
+func WriteKeyFile(path string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(EncodeKey(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write master key file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
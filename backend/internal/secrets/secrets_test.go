@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestSealer(t *testing.T) *Sealer {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSealOpen(t *testing.T) {
+	s := newTestSealer(t)
+
+	sealed, err := s.Seal("refresh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "refresh-token") {
+		t.Fatalf("Seal = %q, want an opaque sealed value", sealed)
+	}
+	again, err := s.Seal("refresh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing the same value twice gave the same output")
+	}
+
+	got, err := s.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "refresh-token" {
+		t.Errorf("Open = %q, want %q", got, "refresh-token")
+	}
+
+	// Empty values stay empty and legacy plaintext passes through
+	if sealed, err := s.Seal(""); err != nil || sealed != "" {
+		t.Errorf("Seal(\"\") = %q, %v, want empty", sealed, err)
+	}
+	if got, err := s.Open("plain-token"); err != nil || got != "plain-token" {
+		t.Errorf("Open of plaintext = %q, %v, want it unchanged", got, err)
+	}
+
+	if _, err := newTestSealer(t).Open(sealed); err == nil {
+		t.Error("Open with another key succeeded")
+	}
+	if _, err := s.Open(sealedPrefix + "AAAA"); err == nil {
+		t.Error("Open of a truncated value succeeded")
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeKey(" " + EncodeKey(key) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(key) {
+		t.Error("DecodeKey does not round-trip EncodeKey")
+	}
+
+	for _, encoded := range []string{"not base64!", EncodeKey(key[:16])} {
+		if _, err := DecodeKey(encoded); err == nil {
+			t.Errorf("DecodeKey(%q) succeeded", encoded)
+		}
+	}
+}
@@ -2,23 +2,35 @@ package services
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"pooled-storage/internal/models"
+	"pooled-storage/internal/providers"
 	"pooled-storage/internal/rclone"
+	"pooled-storage/internal/secrets"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
 )
 
+var ErrDuplicateAccount = errors.New("an account with this email already exists for this provider")
+
 type AccountService struct {
 	db      *sql.DB
-	rclone  *rclone.Manager
+	rclone  rclone.StorageBackend
+	secrets *secrets.Sealer
+	oauth   *OAuthService // client credentials for the remotes
 }
 
-func NewAccountService(db *sql.DB, rclone *rclone.Manager) *AccountService {
+func NewAccountService(db *sql.DB, rclone rclone.StorageBackend, sealer *secrets.Sealer) *AccountService {
 	return &AccountService{
-		db:     db,
-		rclone: rclone,
+		db:      db,
+		rclone:  rclone,
+		secrets: sealer,
+		oauth:   NewOAuthService(db, sealer),
 	}
 }
 
@@ -33,12 +45,88 @@ func (s *AccountService) CreateAccount(req *models.CreateAccountRequest) (*model
 		UpdatedAt: time.Now(),
 	}
 
-	if req.Token != "" {
-		account.AccessToken = req.Token
+	provider, ok := providers.Get(req.Type)
+	if !ok {
+		return nil, validationErrorf("unsupported account type: %s", req.Type)
+	}
+
+	if provider.UsesOAuth() {
+		if err := applyToken(account, req); err != nil {
+			return nil, err
+		}
+		return s.registerAccount(account)
+	}
+
+	creds, identity, err := parseCredentials(req.Type, req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	account.AuthMethod = "credentials"
+	account.Credentials = creds
+	account.Capacity = req.Capacity
+	if account.Email == "" {
+		account.Email = identity
+	}
+	if account.Name == "" {
+		account.Name = identity
+	}
+
+	return s.registerAccount(account)
+}
+
+// CreateServiceAccount onboards a Google account that authenticates with a
+// service-account key instead of an OAuth token.
+func (s *AccountService) CreateServiceAccount(req *models.CreateServiceAccountRequest) (*models.Account, error) {
+	email, err := serviceAccountEmail(req.ServiceAccountJSON, req.Impersonate)
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Name
+	if name == "" {
+		name = email
+	}
+
+	account := &models.Account{
+		ID:                 uuid.New().String(),
+		Name:               name,
+		Type:               "google",
+		Email:              email,
+		AuthMethod:         "service_account",
+		ServiceAccountJSON: string(req.ServiceAccountJSON),
+		Impersonate:        strings.TrimSpace(req.Impersonate),
+		SharedDriveID:      strings.TrimSpace(req.SharedDriveID),
+		Status:             "active",
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}
+
+	return s.registerAccount(account)
+}
+
+// registerAccount creates the rclone remote for a new account, checks it can
+// be reached and stores it.
+func (s *AccountService) registerAccount(account *models.Account) (*models.Account, error) {
+	if account.AuthMethod == "" {
+		account.AuthMethod = "oauth"
+	}
+
+	if account.Email != "" {
+		existing, err := s.findAccount(account.Type, account.Email, account.SharedDriveID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != "" {
+			return nil, ErrDuplicateAccount
+		}
 	}
 
 	// Add to rclone
-	if err := s.rclone.AddRemote(account); err != nil {
+	remote, err := s.remoteAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rclone.AddRemote(remote); err != nil {
 		return nil, fmt.Errorf("failed to add remote: %w", err)
 	}
 
@@ -49,17 +137,28 @@ func (s *AccountService) CreateAccount(req *models.CreateAccountRequest) (*model
 	}
 
 	// Get quota
-	total, used, err := s.rclone.GetQuota(account.ID, account.Type)
+	total, used, err := fetchQuota(s.rclone, account)
 	if err == nil {
 		account.QuotaTotal = total
 		account.QuotaUsed = used
 	}
 
+	sealed, err := s.sealAll(account.AccessToken, account.RefreshToken, account.ServiceAccountJSON,
+		account.Credentials, account.ClientSecret)
+	if err != nil {
+		s.rclone.RemoveRemote(account.ID, account.Type)
+		return nil, err
+	}
+
 	// Save to database
-	query := `INSERT INTO accounts (id, name, type, email, access_token, quota_total, quota_used, status, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = s.db.Exec(query, account.ID, account.Name, account.Type, account.Email,
-		account.AccessToken, account.QuotaTotal, account.QuotaUsed, account.Status,
+	query := `INSERT INTO accounts (id, name, type, email, auth_method, access_token, refresh_token, token_type, token_expiry,
+			  service_account, impersonate, shared_drive_id, parent_account_id, credentials, capacity,
+			  oauth_client_id, oauth_client_secret, quota_total, quota_used, status, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.Exec(query, account.ID, account.Name, account.Type, account.Email, account.AuthMethod,
+		sealed[0], sealed[1], account.TokenType, nullTime(account.TokenExpiry),
+		sealed[2], account.Impersonate, account.SharedDriveID, account.ParentAccountID, sealed[3], account.Capacity,
+		account.ClientID, sealed[4], account.QuotaTotal, account.QuotaUsed, account.Status,
 		account.CreatedAt, account.UpdatedAt)
 	if err != nil {
 		s.rclone.RemoveRemote(account.ID, account.Type)
@@ -70,7 +169,9 @@ func (s *AccountService) CreateAccount(req *models.CreateAccountRequest) (*model
 }
 
 func (s *AccountService) GetAccounts() ([]models.Account, error) {
-	query := `SELECT id, name, type, email, quota_total, quota_used, status, created_at, updated_at
+	query := `SELECT id, name, type, email, COALESCE(auth_method, 'oauth'), COALESCE(impersonate, ''),
+			  COALESCE(shared_drive_id, ''), COALESCE(parent_account_id, ''), capacity, quota_total, quota_used, status,
+			  created_at, updated_at
 			  FROM accounts ORDER BY created_at DESC`
 	
 	rows, err := s.db.Query(query)
@@ -83,7 +184,8 @@ func (s *AccountService) GetAccounts() ([]models.Account, error) {
 	for rows.Next() {
 		var account models.Account
 		err := rows.Scan(&account.ID, &account.Name, &account.Type, &account.Email,
-			&account.QuotaTotal, &account.QuotaUsed, &account.Status,
+			&account.AuthMethod, &account.Impersonate, &account.SharedDriveID, &account.ParentAccountID,
+			&account.Capacity, &account.QuotaTotal, &account.QuotaUsed, &account.Status,
 			&account.CreatedAt, &account.UpdatedAt)
 		if err != nil {
 			return nil, err
@@ -95,12 +197,15 @@ func (s *AccountService) GetAccounts() ([]models.Account, error) {
 }
 
 func (s *AccountService) GetAccount(id string) (*models.Account, error) {
-	query := `SELECT id, name, type, email, quota_total, quota_used, status, created_at, updated_at
+	query := `SELECT id, name, type, email, COALESCE(auth_method, 'oauth'), COALESCE(impersonate, ''),
+			  COALESCE(shared_drive_id, ''), COALESCE(parent_account_id, ''), capacity, quota_total, quota_used, status,
+			  created_at, updated_at
 			  FROM accounts WHERE id = ?`
 	
 	var account models.Account
 	err := s.db.QueryRow(query, id).Scan(&account.ID, &account.Name, &account.Type, &account.Email,
-		&account.QuotaTotal, &account.QuotaUsed, &account.Status,
+		&account.AuthMethod, &account.Impersonate, &account.SharedDriveID, &account.ParentAccountID,
+		&account.Capacity, &account.QuotaTotal, &account.QuotaUsed, &account.Status,
 		&account.CreatedAt, &account.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -114,6 +219,14 @@ func (s *AccountService) DeleteAccount(id string) error {
 	if err != nil {
 		return err
 	}
+	// Shared drives renew their token through this account
+	children, err := s.childAccounts(id)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return validationErrorf("account has %d shared drive(s) registered under it, delete them first", len(children))
+	}
 
 	// Remove from rclone
 	if err := s.rclone.RemoveRemote(account.ID, account.Type); err != nil {
@@ -131,7 +244,7 @@ func (s *AccountService) RefreshQuota(id string) error {
 		return err
 	}
 
-	total, used, err := s.rclone.GetQuota(account.ID, account.Type)
+	total, used, err := fetchQuota(s.rclone, account)
 	if err != nil {
 		return err
 	}
@@ -146,3 +259,369 @@ func (s *AccountService) UpdateAccountStatus(id, status string) error {
 	_, err := s.db.Exec(query, status, time.Now(), id)
 	return err
 }
+
+// ListSharedDrives lists the shared drives a Google account can see and
+// which of them are already registered as accounts.
+func (s *AccountService) ListSharedDrives(id string) ([]models.SharedDrive, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Type != "google" {
+		return nil, validationErrorf("shared drives are only available for Google accounts")
+	}
+
+	drives, err := s.rclone.ListSharedDrives(account.ID, account.Type)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shared drives: %w", err)
+	}
+
+	registered, err := s.sharedDriveAccounts(account)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.SharedDrive, 0, len(drives))
+	for _, d := range drives {
+		result = append(result, models.SharedDrive{ID: d.ID, Name: d.Name, AccountID: registered[d.ID]})
+	}
+	return result, nil
+}
+
+// AddSharedDrives registers shared drives visible to a Google account as
+// separate accounts sharing its credentials, so each can join pools on its
+// own. Drives that are already registered are skipped.
+func (s *AccountService) AddSharedDrives(id string, req *models.AddSharedDrivesRequest) ([]models.Account, error) {
+	parent, err := s.getAccountWithSecrets(id)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Type != "google" {
+		return nil, validationErrorf("shared drives are only available for Google accounts")
+	}
+	if parent.SharedDriveID != "" {
+		return nil, validationErrorf("account is already a shared drive")
+	}
+	if len(req.DriveIDs) == 0 {
+		return nil, validationErrorf("drive_ids is required")
+	}
+
+	available, err := s.rclone.ListSharedDrives(parent.ID, parent.Type)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shared drives: %w", err)
+	}
+	names := make(map[string]string, len(available))
+	for _, d := range available {
+		names[d.ID] = d.Name
+	}
+
+	registered, err := s.sharedDriveAccounts(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, driveID := range req.DriveIDs {
+		if _, ok := names[driveID]; !ok {
+			return nil, validationErrorf("shared drive %s is not visible to this account", driveID)
+		}
+	}
+
+	var created []models.Account
+	for _, driveID := range req.DriveIDs {
+		if registered[driveID] != "" {
+			continue
+		}
+		name := names[driveID]
+
+		child := *parent
+		child.ID = uuid.New().String()
+		child.Name = name
+		child.SharedDriveID = driveID
+		child.ParentAccountID = parent.ID
+		child.Capacity = req.Capacity
+		child.QuotaTotal = 0
+		child.QuotaUsed = 0
+		child.Status = "active"
+		child.CreatedAt = time.Now()
+		child.UpdatedAt = time.Now()
+
+		account, err := s.registerAccount(&child)
+		if err != nil {
+			return created, fmt.Errorf("failed to add shared drive %s: %w", name, err)
+		}
+		created = append(created, *account)
+	}
+
+	return created, nil
+}
+
+// sharedDriveAccounts maps shared drive ID -> account ID for drives already
+// registered with the same login as account.
+func (s *AccountService) sharedDriveAccounts(account *models.Account) (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT id, shared_drive_id FROM accounts
+			  WHERE type = ? AND lower(email) = lower(?) AND COALESCE(shared_drive_id, '') != ''`,
+		account.Type, account.Email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registered := map[string]string{}
+	for rows.Next() {
+		var accountID, driveID string
+		if err := rows.Scan(&accountID, &driveID); err != nil {
+			return nil, err
+		}
+		registered[driveID] = accountID
+	}
+	return registered, rows.Err()
+}
+
+// getAccountWithSecrets loads an account including its decrypted credentials.
+func (s *AccountService) getAccountWithSecrets(id string) (*models.Account, error) {
+	account, err := s.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessToken, refreshToken, tokenType, serviceAccount, credentials, clientID, clientSecret sql.NullString
+	var expiry sql.NullTime
+	err = s.db.QueryRow(`SELECT access_token, refresh_token, token_type, token_expiry, service_account, credentials,
+			  oauth_client_id, oauth_client_secret
+			  FROM accounts WHERE id = ?`, id).
+		Scan(&accessToken, &refreshToken, &tokenType, &expiry, &serviceAccount, &credentials, &clientID, &clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{accessToken.String, refreshToken.String, serviceAccount.String, credentials.String,
+		clientSecret.String}
+	opened := make([]string, len(values))
+	for i, v := range values {
+		if opened[i], err = s.secrets.Open(v); err != nil {
+			return nil, err
+		}
+	}
+	account.AccessToken = opened[0]
+	account.RefreshToken = opened[1]
+	account.ServiceAccountJSON = opened[2]
+	account.Credentials = opened[3]
+	account.ClientID = clientID.String
+	account.ClientSecret = opened[4]
+	account.TokenType = tokenType.String
+	account.TokenExpiry = expiry.Time
+
+	return account, nil
+}
+
+// remoteAccount returns the account as its rclone remote is written. Tokens
+// we obtained refresh through our client for the provider; imported ones
+// keep the client stored with them.
+func (s *AccountService) remoteAccount(account *models.Account) (*models.Account, error) {
+	if account.AuthMethod != "oauth" || account.ClientID != "" {
+		return account, nil
+	}
+	creds, err := s.oauth.GetCredentials(account.Type)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return account, nil
+	}
+	remote := *account
+	remote.ClientID = creds.ClientID
+	remote.ClientSecret = creds.ClientSecret
+	return &remote, nil
+}
+
+// UpdateToken stores a renewed OAuth token and pushes it to the account's
+// rclone remote. Shared drives registered under the account get it too,
+// since they were handed the same token.
+func (s *AccountService) UpdateToken(id string, token *oauth2.Token) error {
+	sealed, err := s.sealAll(token.AccessToken, token.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE accounts SET access_token = ?, refresh_token = ?, token_type = ?, token_expiry = ?, updated_at = ?
+			  WHERE id = ? OR parent_account_id = ?`
+	_, err = s.db.Exec(query, sealed[0], sealed[1], token.TokenType,
+		nullTime(token.Expiry.UTC()), time.Now(), id, id)
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.childAccounts(id)
+	if err != nil {
+		return err
+	}
+	for _, accountID := range append([]string{id}, ids...) {
+		account, err := s.GetAccount(accountID)
+		if err != nil {
+			return err
+		}
+		account.AccessToken = token.AccessToken
+		account.RefreshToken = token.RefreshToken
+		account.TokenType = token.TokenType
+		account.TokenExpiry = token.Expiry.UTC()
+		if err := s.rclone.UpdateToken(account); err != nil {
+			return fmt.Errorf("failed to update remote token of account %s: %w", accountID, err)
+		}
+	}
+
+	return nil
+}
+
+// childAccounts returns the IDs of the shared drives registered under an
+// account.
+func (s *AccountService) childAccounts(id string) ([]string, error) {
+	rows, err := s.db.Query("SELECT id FROM accounts WHERE parent_account_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, accountID)
+	}
+	return ids, rows.Err()
+}
+
+// GetExpiringAccounts returns accounts with a refresh token whose access
+// token expires before the given time, including their tokens. Imported
+// tokens belong to another OAuth client; rclone refreshes those itself.
+// Shared drives are left out, UpdateToken renews them with their parent.
+func (s *AccountService) GetExpiringAccounts(before time.Time) ([]models.Account, error) {
+	query := `SELECT id, name, type, email, access_token, refresh_token, token_type, token_expiry, status
+			  FROM accounts
+			  WHERE refresh_token IS NOT NULL AND refresh_token != ''
+			  AND token_expiry IS NOT NULL AND token_expiry < ?
+			  AND status != 'inactive' AND COALESCE(auth_method, 'oauth') = 'oauth'
+			  AND COALESCE(parent_account_id, '') = ''`
+
+	rows, err := s.db.Query(query, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []models.Account
+	for rows.Next() {
+		var account models.Account
+		var accessToken, refreshToken, tokenType sql.NullString
+		var expiry sql.NullTime
+		err := rows.Scan(&account.ID, &account.Name, &account.Type, &account.Email,
+			&accessToken, &refreshToken, &tokenType, &expiry, &account.Status)
+		if err != nil {
+			return nil, err
+		}
+		if account.AccessToken, err = s.secrets.Open(accessToken.String); err != nil {
+			return nil, err
+		}
+		if account.RefreshToken, err = s.secrets.Open(refreshToken.String); err != nil {
+			return nil, err
+		}
+		account.TokenType = tokenType.String
+		account.TokenExpiry = expiry.Time
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (s *AccountService) sealAll(values ...string) ([]string, error) {
+	sealed := make([]string, len(values))
+	for i, v := range values {
+		var err error
+		if sealed[i], err = s.secrets.Seal(v); err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// serviceAccountEmail validates a Google service-account key and returns the
+// login it acts as.
+func serviceAccountEmail(keyJSON []byte, impersonate string) (string, error) {
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		return "", validationErrorf("service account JSON is not valid JSON: %v", err)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return "", validationErrorf("not a Google service account key (need type, client_email and private_key)")
+	}
+
+	// With domain-wide delegation the files belong to the impersonated user
+	if impersonate = strings.TrimSpace(impersonate); impersonate != "" {
+		return strings.ToLower(impersonate), nil
+	}
+	return strings.ToLower(key.ClientEmail), nil
+}
+
+// findAccount returns the ID of the account registered for provider+email
+// (and shared drive, which makes the same login a separate pool member), or
+// "" if there is none.
+func (s *AccountService) findAccount(accountType, email, sharedDriveID string) (string, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT id FROM accounts WHERE type = ? AND lower(email) = lower(?)
+			  AND COALESCE(shared_drive_id, '') = ? LIMIT 1`,
+		accountType, email, sharedDriveID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// applyToken fills the account's token fields from a create request. A
+// manual token may be a bare access token or the JSON printed by
+// `rclone authorize`.
+func applyToken(account *models.Account, req *models.CreateAccountRequest) error {
+	if strings.HasPrefix(strings.TrimSpace(req.Token), "{") {
+		var token oauth2.Token
+		if err := json.Unmarshal([]byte(req.Token), &token); err != nil {
+			return validationErrorf("invalid token JSON: %v", err)
+		}
+		account.AccessToken = token.AccessToken
+		account.RefreshToken = token.RefreshToken
+		account.TokenType = token.TokenType
+		account.TokenExpiry = token.Expiry.UTC()
+		return nil
+	}
+
+	account.AccessToken = req.Token
+	account.RefreshToken = req.RefreshToken
+	account.TokenType = req.TokenType
+	account.TokenExpiry = req.TokenExpiry.UTC()
+	return nil
+}
+
+// fetchQuota asks rclone for an account's quota. Backends without a quota
+// API (shared drives, S3, some WebDAV and SFTP servers) only have their
+// usage measured and report the configured capacity as total.
+func fetchQuota(backend rclone.StorageBackend, account *models.Account) (int64, int64, error) {
+	if account.SharedDriveID == "" {
+		total, used, err := backend.GetQuota(account.ID, account.Type)
+		if (err == nil && total > 0) || account.Capacity == 0 {
+			return total, used, err
+		}
+	}
+
+	used, err := backend.GetUsage(account.ID, account.Type)
+	return account.Capacity, used, err
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"errors"
+	"pooled-storage/internal/models"
+	"testing"
+)
+
+func TestCreateAccountToken(t *testing.T) {
+	env := newTestEnv(t)
+	account, err := env.accounts.CreateAccount(&models.CreateAccountRequest{
+		Name:  "a",
+		Type:  "google",
+		Email: "a@example.com",
+		Token: `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := env.accounts.getAccountWithSecrets(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AccessToken != "access" || stored.RefreshToken != "refresh" {
+		t.Errorf("tokens = %q/%q, want the ones from the JSON", stored.AccessToken, stored.RefreshToken)
+	}
+
+	var verr *ValidationError
+	_, err = env.accounts.CreateAccount(&models.CreateAccountRequest{
+		Name:  "b",
+		Type:  "google",
+		Email: "b@example.com",
+		Token: `{"access_token":`,
+	})
+	if !errors.As(err, &verr) {
+		t.Errorf("malformed token JSON: err = %v, want a validation error", err)
+	}
+}
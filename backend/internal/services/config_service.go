@@ -0,0 +1,73 @@
+package services
+
+import (
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+)
+
+// ConfigService keeps rclone's config in line with the database, which is
+// the source of truth for remotes and unions.
+type ConfigService struct {
+	rclone   rclone.StorageBackend
+	accounts *AccountService
+	storage  *StorageService
+}
+
+func NewConfigService(rclone rclone.StorageBackend, accounts *AccountService, storage *StorageService) *ConfigService {
+	return &ConfigService{
+		rclone:   rclone,
+		accounts: accounts,
+		storage:  storage,
+	}
+}
+
+// Regenerate rewrites the rclone config from the database.
+func (s *ConfigService) Regenerate() error {
+	accounts, pools, err := s.load()
+	if err != nil {
+		return err
+	}
+	return s.rclone.Rebuild(accounts, pools)
+}
+
+// Preview shows the config Regenerate would write next to the current one.
+func (s *ConfigService) Preview() (*rclone.ConfigPreview, error) {
+	accounts, pools, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s.rclone.PreviewConfig(accounts, pools)
+}
+
+// load returns every account with its credentials and the pools whose
+// unions should exist, i.e. the running ones.
+func (s *ConfigService) load() ([]models.Account, []models.StoragePool, error) {
+	list, err := s.accounts.GetAccounts()
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts := make([]models.Account, 0, len(list))
+	for _, a := range list {
+		account, err := s.accounts.getAccountWithSecrets(a.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if account, err = s.accounts.remoteAccount(account); err != nil {
+			return nil, nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+
+	all, err := s.storage.GetPools()
+	if err != nil {
+		return nil, nil, err
+	}
+	var pools []models.StoragePool
+	for _, pool := range all {
+		if pool.Status == "running" && len(pool.Accounts) > 0 {
+			pools = append(pools, pool)
+		}
+	}
+
+	return accounts, pools, nil
+}
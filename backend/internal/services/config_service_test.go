@@ -0,0 +1,41 @@
+package services
+
+import (
+	"pooled-storage/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestRemoteClient(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "our-id")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "our-secret")
+	env := newTestEnv(t)
+	env.addAccount(t, "ours")
+	_, err := env.accounts.registerAccount(&models.Account{
+		ID:           "imported",
+		Name:         "imported",
+		Type:         "google",
+		Email:        "imported@example.com",
+		Status:       "active",
+		AccessToken:  "token",
+		ClientID:     "their-id",
+		ClientSecret: "their-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := NewConfigService(env.backend, env.accounts, env.storage).Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"client_id = our-id", "client_id = their-id"} {
+		if n := strings.Count(preview.Generated, line+"\n"); n != 1 {
+			t.Errorf("config has %q %d times, want once:\n%s", line, n, preview.Generated)
+		}
+	}
+	// Remotes are added the way the config is regenerated
+	if preview.Changed {
+		t.Errorf("regenerating changes the remotes:\n%s", preview.Diff)
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"pooled-storage/internal/models"
+	"strings"
+)
+
+// parseCredentials validates the credential payload of a non-OAuth account
+// and returns it re-encoded along with an identifier for the login (stored
+// as the account email, so the same bucket or share isn't added twice).
+func parseCredentials(accountType string, raw json.RawMessage) (string, string, error) {
+	if len(raw) == 0 {
+		return "", "", validationErrorf("credentials are required for %s accounts", accountType)
+	}
+
+	var creds interface{}
+	var identity string
+
+	switch accountType {
+	case "s3":
+		var c models.S3Credentials
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return "", "", validationErrorf("invalid s3 credentials: %v", err)
+		}
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" || c.Bucket == "" {
+			return "", "", validationErrorf("s3 credentials need access_key_id, secret_access_key and bucket")
+		}
+		host := "aws"
+		if c.Endpoint != "" {
+			u, err := url.Parse(c.Endpoint)
+			if err != nil || u.Host == "" {
+				return "", "", validationErrorf("s3 endpoint must be an absolute URL")
+			}
+			host = u.Host
+		}
+		c.Path = strings.Trim(c.Path, "/")
+		identity = c.AccessKeyID + "@" + host + "/" + c.Bucket
+		if c.Path != "" {
+			identity += "/" + c.Path
+		}
+		creds = c
+
+	case "webdav":
+		var c models.WebDAVCredentials
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return "", "", validationErrorf("invalid webdav credentials: %v", err)
+		}
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", "", validationErrorf("webdav url must be an absolute http(s) URL")
+		}
+		switch c.Vendor {
+		case "":
+			c.Vendor = "other"
+		case "nextcloud", "owncloud", "sharepoint", "sharepoint-ntlm", "other":
+		default:
+			return "", "", validationErrorf("unsupported webdav vendor: %s", c.Vendor)
+		}
+		identity = u.Host + u.Path
+		if c.User != "" {
+			identity = c.User + "@" + identity
+		}
+		creds = c
+
+	case "sftp":
+		var c models.SFTPCredentials
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return "", "", validationErrorf("invalid sftp credentials: %v", err)
+		}
+		if c.Host == "" || c.User == "" {
+			return "", "", validationErrorf("sftp credentials need host and user")
+		}
+		if c.Password == "" && c.PrivateKey == "" {
+			return "", "", validationErrorf("sftp credentials need a password or private_key")
+		}
+		if c.PrivateKey != "" && !strings.Contains(c.PrivateKey, "PRIVATE KEY-----") {
+			return "", "", validationErrorf("sftp private_key must be PEM encoded")
+		}
+		if c.Port == 0 {
+			c.Port = 22
+		}
+		if c.Port < 1 || c.Port > 65535 {
+			return "", "", validationErrorf("sftp port must be between 1 and 65535")
+		}
+		identity = fmt.Sprintf("%s@%s:%d/%s", c.User, c.Host, c.Port, strings.Trim(c.Path, "/"))
+		creds = c
+
+	default:
+		return "", "", validationErrorf("%s accounts do not take credentials", accountType)
+	}
+
+	encoded, err := json.Marshal(creds)
+	if err != nil {
+		return "", "", err
+	}
+	return string(encoded), strings.ToLower(identity), nil
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Drain jobs in these states still have a goroutine working on them.
+const activeDrainStatuses = `('copying', 'verifying', 'deleting')`
+
+// DrainService moves the files of a pool member to the other members and
+// then removes it from the pool. While it runs the member is read-only in
+// the union, so nothing new lands on it.
+type DrainService struct {
+	db      *sql.DB
+	storage *StorageService
+	rclone  rclone.StorageBackend
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc // running jobs by ID
+}
+
+func NewDrainService(db *sql.DB, storage *StorageService, rclone rclone.StorageBackend) *DrainService {
+	return &DrainService{
+		db:      db,
+		storage: storage,
+		rclone:  rclone,
+		cancels: make(map[string]context.CancelFunc),
+	}
+}
+
+// FailInterrupted marks drains cut short by a restart as failed and makes
+// their members writable again. Call it before the rclone config is
+// generated.
+func (s *DrainService) FailInterrupted() (int, error) {
+	rows, err := s.db.Query(`SELECT id, pool_id, account_id FROM drain_jobs WHERE status IN ` + activeDrainStatuses)
+	if err != nil {
+		return 0, err
+	}
+	var jobs []models.DrainJob
+	for rows.Next() {
+		var job models.DrainJob
+		if err := rows.Scan(&job.ID, &job.PoolID, &job.AccountID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+
+	for _, job := range jobs {
+		s.setMode(job.PoolID, job.AccountID, "rw")
+		s.finish(job.ID, "failed", "", fmt.Errorf("interrupted by a restart"))
+	}
+	return len(jobs), nil
+}
+
+// StartDrain makes the member read-only, remounts the pool and starts moving
+// the member's files in the background.
+func (s *DrainService) StartDrain(poolID, accountID string) (*models.DrainJob, error) {
+	st := s.storage
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	pool, err := st.GetPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+	if pool.Status != "running" {
+		return nil, validationErrorf("pool must be running to drain an account")
+	}
+
+	var account *models.Account
+	writable := 0
+	for i := range pool.Accounts {
+		if pool.Accounts[i].ID == accountID {
+			account = &pool.Accounts[i]
+		} else if pool.Accounts[i].PoolMode != "ro" {
+			writable++
+		}
+	}
+	if account == nil {
+		return nil, validationErrorf("account %s is not in the pool", accountID)
+	}
+	if writable == 0 {
+		return nil, validationErrorf("no other writable account in the pool to drain to")
+	}
+	if err := st.refuseWhileMoving(poolID, ""); err != nil {
+		return nil, err
+	}
+
+	if err := s.setMode(poolID, accountID, "ro"); err != nil {
+		return nil, err
+	}
+	if err := st.remountPool(poolID); err != nil {
+		s.setMode(poolID, accountID, "rw")
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &models.DrainJob{
+		ID:        uuid.New().String(),
+		PoolID:    poolID,
+		AccountID: accountID,
+		Status:    "copying",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	query := `INSERT INTO drain_jobs (id, pool_id, account_id, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, job.ID, job.PoolID, job.AccountID, job.Status, job.CreatedAt, job.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	// Reload so the member shows up read-only
+	pool, err = st.GetPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+	go s.run(ctx, job.ID, pool, *account)
+
+	return job, nil
+}
+
+func (s *DrainService) run(ctx context.Context, jobID string, pool *models.StoragePool, account models.Account) {
+	defer func() {
+		s.mu.Lock()
+		if cancel, ok := s.cancels[jobID]; ok {
+			cancel()
+			delete(s.cancels, jobID)
+		}
+		s.mu.Unlock()
+	}()
+
+	result, err := s.rclone.DrainMember(ctx, pool, &account, func(p rclone.DrainProgress) {
+		s.updateProgress(jobID, &p)
+	})
+
+	st := s.storage
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err != nil {
+		status := "failed"
+		if ctx.Err() != nil {
+			status = "cancelled"
+		}
+		log.Printf("Drain of account %s from pool %s %s: %v", account.ID, pool.ID, status, err)
+		// Files that were copied stay on both sides; the member takes writes
+		// again
+		s.setMode(pool.ID, account.ID, "rw")
+		if err := s.remountIfRunning(pool.ID); err != nil {
+			log.Printf("Failed to remount pool %s after drain: %v", pool.ID, err)
+		}
+		s.finish(jobID, status, "", err)
+		return
+	}
+
+	if _, err := s.db.Exec("DELETE FROM pool_accounts WHERE pool_id = ? AND account_id = ?", pool.ID, account.ID); err != nil {
+		s.finish(jobID, "failed", result.HashType, fmt.Errorf("files moved but removing the account failed: %w", err))
+		return
+	}
+	var remountErr error
+	if err := s.remountIfRunning(pool.ID); err != nil {
+		remountErr = fmt.Errorf("account removed but remounting the pool failed: %w", err)
+	}
+	s.finish(jobID, "completed", result.HashType, remountErr)
+}
+
+// remountIfRunning must be called with s.storage.mu held.
+func (s *DrainService) remountIfRunning(poolID string) error {
+	pool, err := s.storage.GetPool(poolID)
+	if err != nil {
+		return err
+	}
+	if pool.Status != "running" {
+		return nil
+	}
+	return s.storage.remountPool(poolID)
+}
+
+func (s *DrainService) setMode(poolID, accountID, mode string) error {
+	_, err := s.db.Exec("UPDATE pool_accounts SET mode = ? WHERE pool_id = ? AND account_id = ?", mode, poolID, accountID)
+	return err
+}
+
+func (s *DrainService) updateProgress(jobID string, p *rclone.DrainProgress) {
+	if p.Phase != "copying" {
+		s.db.Exec("UPDATE drain_jobs SET status = ?, updated_at = ? WHERE id = ?", p.Phase, time.Now(), jobID)
+		return
+	}
+	query := `UPDATE drain_jobs SET status = ?, bytes_done = ?, bytes_total = ?, files_done = ?, files_total = ?,
+			  errors = ?, updated_at = ? WHERE id = ?`
+	s.db.Exec(query, p.Phase, p.Bytes, p.TotalBytes, p.Files, p.TotalFiles, p.Errors, time.Now(), jobID)
+}
+
+func (s *DrainService) finish(jobID, status, hashType string, err error) {
+	var message string
+	if err != nil {
+		message = err.Error()
+	}
+	now := time.Now()
+	query := `UPDATE drain_jobs SET status = ?, hash_type = ?, error = ?, finished_at = ?, updated_at = ? WHERE id = ?`
+	if _, err := s.db.Exec(query, status, hashType, message, now, now, jobID); err != nil {
+		log.Printf("Failed to update drain job %s: %v", jobID, err)
+	}
+}
+
+// CancelDrain stops a running drain. The member is made writable again.
+func (s *DrainService) CancelDrain(id string) error {
+	s.mu.Lock()
+	cancel, ok := s.cancels[id]
+	s.mu.Unlock()
+	if !ok {
+		if _, err := s.GetDrain(id); err != nil {
+			return err
+		}
+		return validationErrorf("drain %s is not running", id)
+	}
+	cancel()
+	return nil
+}
+
+func (s *DrainService) GetDrain(id string) (*models.DrainJob, error) {
+	rows, err := s.db.Query(drainJobQuery+` WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scanDrainJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &jobs[0], nil
+}
+
+// ListDrains returns the drains of a pool, newest first.
+func (s *DrainService) ListDrains(poolID string) ([]models.DrainJob, error) {
+	if _, err := s.storage.GetPool(poolID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(drainJobQuery+` WHERE pool_id = ? ORDER BY created_at DESC`, poolID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDrainJobs(rows)
+}
+
+const drainJobQuery = `SELECT id, pool_id, account_id, status, bytes_done, bytes_total, files_done, files_total,
+	errors, hash_type, error, created_at, updated_at, finished_at FROM drain_jobs`
+
+func scanDrainJobs(rows *sql.Rows) ([]models.DrainJob, error) {
+	defer rows.Close()
+
+	jobs := []models.DrainJob{}
+	for rows.Next() {
+		var job models.DrainJob
+		var hashType, message sql.NullString
+		var finishedAt sql.NullTime
+		err := rows.Scan(&job.ID, &job.PoolID, &job.AccountID, &job.Status, &job.BytesDone, &job.BytesTotal,
+			&job.FilesDone, &job.FilesTotal, &job.Errors, &hashType, &message, &job.CreatedAt, &job.UpdatedAt, &finishedAt)
+		if err != nil {
+			return nil, err
+		}
+		job.HashType = hashType.String
+		job.Error = message.String
+		if finishedAt.Valid {
+			job.FinishedAt = &finishedAt.Time
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForDrain polls a drain job until it has finished.
+func waitForDrain(t *testing.T, s *DrainService, id string) *models.DrainJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := s.GetDrain(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch job.Status {
+		case "completed", "failed", "cancelled":
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("drain still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	env := newTestEnv(t)
+	a, b := env.addAccount(t, "a"), env.addAccount(t, "b")
+	pool := env.addPool(t, models.CreatePoolRequest{}, a, b)
+	if err := env.storage.StartPool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.SetFiles(a.ID, []rclone.ListItem{{Path: "x", Name: "x", Size: 10}, {Path: "d/y", Name: "y", Size: 20}})
+	s := NewDrainService(env.db, env.storage, env.backend)
+
+	job, err := s.StartDrain(pool.ID, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The drain can't finish while the pool is locked, so the remounted
+	// union is what it runs against
+	env.storage.mu.Lock()
+	upstreams, _ := env.backend.Union(pool.ID)
+	env.storage.mu.Unlock()
+	if len(upstreams) != 2 || !strings.HasSuffix(upstreams[0], ":ro") || strings.HasSuffix(upstreams[1], ":ro") {
+		t.Errorf("union while draining = %v, want only the drained member read-only", upstreams)
+	}
+	if _, err := s.StartDrain(pool.ID, b.ID); err == nil {
+		t.Error("second drain in the same pool started")
+	}
+
+	job = waitForDrain(t, s, job.ID)
+	if job.Status != "completed" || job.FilesDone != 2 || job.FilesTotal != 2 {
+		t.Fatalf("drain = %+v, want 2 files moved", job)
+	}
+
+	drained, err := env.storage.GetPool(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drained.Accounts) != 1 || drained.Accounts[0].ID != b.ID {
+		t.Errorf("members after the drain = %v, want only b", drained.Accounts)
+	}
+	if upstreams, _ := env.backend.Union(pool.ID); len(upstreams) != 1 || strings.HasSuffix(upstreams[0], ":ro") {
+		t.Errorf("union after the drain = %v, want only b", upstreams)
+	}
+	if files, _ := env.backend.ListFiles(drained, b); len(files) != 2 {
+		t.Errorf("b holds %d files after the drain, want 2", len(files))
+	}
+	if files, _ := env.backend.ListFiles(drained, a); len(files) != 0 {
+		t.Errorf("a still holds %v", files)
+	}
+
+	// The finished drain goes with its pool
+	if err := env.storage.DeletePool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"drain_jobs", "pool_accounts"} {
+		if n := env.poolRows(t, table, pool.ID); n != 0 {
+			t.Errorf("%d %s rows left after deleting the pool", n, table)
+		}
+	}
+}
+
+func TestDrainFailure(t *testing.T) {
+	env := newTestEnv(t)
+	a, b := env.addAccount(t, "a"), env.addAccount(t, "b")
+	pool := env.addPool(t, models.CreatePoolRequest{}, a, b)
+	if err := env.storage.StartPool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Fail("DrainMember", errors.New("copy failed"))
+	s := NewDrainService(env.db, env.storage, env.backend)
+
+	job, err := s.StartDrain(pool.ID, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitForDrain(t, s, job.ID)
+	if job.Status != "failed" || job.Error != "copy failed" {
+		t.Errorf("drain = %+v, want it failed with the copy error", job)
+	}
+
+	// The member stays and takes writes again
+	if upstreams, _ := env.backend.Union(pool.ID); len(upstreams) != 2 || strings.HasSuffix(upstreams[0], ":ro") {
+		t.Errorf("union after a failed drain = %v, want both members writable", upstreams)
+	}
+}
@@ -0,0 +1,29 @@
+package services
+
+import "fmt"
+
+// ValidationError reports a problem with the caller's input. API handlers
+// answer it with 400 instead of 500.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func validationErrorf(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// UniqueFilesError refuses to take an account out of a pool while it holds
+// files no other member has. API handlers answer it with 409.
+type UniqueFilesError struct {
+	AccountID string
+	Count     int
+	Files     []string // the first few
+}
+
+func (e *UniqueFilesError) Error() string {
+	return fmt.Sprintf("account %s holds %d file(s) no other pool member has; drain it first or remove it with force", e.AccountID, e.Count)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"pooled-storage/internal/providers"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Identity is the user an OAuth token belongs to.
+type Identity struct {
+	Email string
+	Name  string
+}
+
+// identityEndpointsFromEnv returns each provider's identity URL, overridden
+// by <PROVIDER>_IDENTITY_URL when set (e.g. to use a stub server).
+func identityEndpointsFromEnv() map[string]string {
+	all := providers.All()
+	endpoints := make(map[string]string, len(all))
+	for _, p := range all {
+		if p.IdentityURL == "" {
+			continue
+		}
+		url := p.IdentityURL
+		if v := os.Getenv(strings.ToUpper(p.ID) + "_IDENTITY_URL"); v != "" {
+			url = v
+		}
+		endpoints[p.ID] = url
+	}
+	return endpoints
+}
+
+// FetchIdentity asks the provider who the token belongs to.
+func (s *OAuthService) FetchIdentity(ctx context.Context, provider string, token *oauth2.Token) (*Identity, error) {
+	p, ok := providers.Get(provider)
+	endpoint := s.IdentityEndpoints[provider]
+	if !ok || endpoint == "" {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+
+	method := p.IdentityMethod
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if method == http.MethodPost {
+		// RPC-style endpoints (Dropbox) take a JSON null for "no arguments"
+		body = strings.NewReader("null")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s identity: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s identity request failed: %s", provider, resp.Status)
+	}
+
+	email, name, err := p.ParseIdentity(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s identity: %w", provider, err)
+	}
+	if email == "" {
+		return nil, fmt.Errorf("%s did not return an email address", provider)
+	}
+
+	identity := &Identity{Email: strings.ToLower(email), Name: name}
+	if identity.Name == "" {
+		identity.Name = identity.Email
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pooled-storage/internal/models"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// identityStub answers identity requests made with the token "token" with
+// status and body.
+func identityStub(t *testing.T, method string, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			t.Errorf("identity requested with %s, want %s", r.Method, method)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if r.Method == http.MethodPost {
+			if data, _ := io.ReadAll(r.Body); string(data) != "null" {
+				t.Errorf("body = %q, want null", data)
+			}
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		method   string
+		status   int
+		body     string
+		want     Identity
+		wantErr  string
+	}{
+		{"google", "google", "GET", 200, `{"user":{"displayName":"Ann","emailAddress":"Ann@Example.com"}}`,
+			Identity{"ann@example.com", "Ann"}, ""},
+		{"microsoft", "microsoft", "GET", 200, `{"displayName":"Bo","mail":"bo@example.com","userPrincipalName":"bo_upn@example.com"}`,
+			Identity{"bo@example.com", "Bo"}, ""},
+		{"microsoft personal", "microsoft", "GET", 200, `{"displayName":"Bo","userPrincipalName":"bo@outlook.com"}`,
+			Identity{"bo@outlook.com", "Bo"}, ""},
+		{"dropbox", "dropbox", "POST", 200, `{"email":"cy@example.com","name":{"display_name":"Cy"}}`,
+			Identity{"cy@example.com", "Cy"}, ""},
+		{"box", "box", "GET", 200, `{"login":"di@example.com","name":"Di"}`,
+			Identity{"di@example.com", "Di"}, ""},
+		{"pcloud without a name", "pcloud", "GET", 200, `{"result":0,"email":"ed@example.com"}`,
+			Identity{"ed@example.com", "ed@example.com"}, ""},
+		{"pcloud error", "pcloud", "GET", 200, `{"result":2000,"error":"Log in failed."}`,
+			Identity{}, "Log in failed."},
+		{"rejected token", "google", "GET", 401, `{"error":"invalid_token"}`,
+			Identity{}, "401 Unauthorized"},
+		{"no email", "box", "GET", 200, `{"name":"Di"}`,
+			Identity{}, "did not return an email address"},
+		{"not json", "google", "GET", 200, `<html>`,
+			Identity{}, "failed to read google identity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			srv := identityStub(t, tt.method, tt.status, tt.body)
+			t.Setenv(strings.ToUpper(tt.provider)+"_IDENTITY_URL", srv.URL)
+
+			s := NewOAuthService(env.db, env.sealer)
+			identity, err := s.FetchIdentity(context.Background(), tt.provider, &oauth2.Token{AccessToken: "token"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *identity != tt.want {
+				t.Errorf("identity = %+v, want %+v", *identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchIdentityUnsupportedProvider(t *testing.T) {
+	env := newTestEnv(t)
+	s := NewOAuthService(env.db, env.sealer)
+	for _, provider := range []string{"webdav", "nope"} {
+		if _, err := s.FetchIdentity(context.Background(), provider, &oauth2.Token{AccessToken: "token"}); err == nil {
+			t.Errorf("%s: no error", provider)
+		}
+	}
+}
+
+func TestDuplicateAccount(t *testing.T) {
+	env := newTestEnv(t)
+	srv := identityStub(t, "GET", 200, `{"user":{"displayName":"Ann","emailAddress":"ann@example.com"}}`)
+	t.Setenv("GOOGLE_IDENTITY_URL", srv.URL)
+	s := NewOAuthService(env.db, env.sealer)
+
+	// Each sign-in resolves the identity and creates the account from it,
+	// as the OAuth callback does
+	signIn := func(provider, email string) error {
+		identity := &Identity{Email: email, Name: email}
+		if email == "" {
+			var err error
+			identity, err = s.FetchIdentity(context.Background(), provider, &oauth2.Token{AccessToken: "token"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := env.accounts.CreateAccount(&models.CreateAccountRequest{
+			Name:  identity.Name,
+			Type:  provider,
+			Email: identity.Email,
+			Token: "token",
+		})
+		return err
+	}
+
+	tests := []struct {
+		name      string
+		provider  string
+		email     string // "" to resolve it through the stub
+		duplicate bool
+	}{
+		{"first sign-in", "google", "", false},
+		{"same account again", "google", "", true},
+		{"same email in other case", "google", "ANN@example.com", true},
+		{"same email elsewhere", "dropbox", "ann@example.com", false},
+		{"other account", "google", "bo@example.com", false},
+	}
+	for _, tt := range tests {
+		err := signIn(tt.provider, tt.email)
+		if got := errors.Is(err, ErrDuplicateAccount); got != tt.duplicate {
+			t.Errorf("%s: err = %v, want duplicate %v", tt.name, err, tt.duplicate)
+		} else if !tt.duplicate && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/providers"
+	"pooled-storage/internal/rclone"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// ImportService turns the remotes of an existing rclone.conf into accounts.
+type ImportService struct {
+	accounts *AccountService
+	oauth    *OAuthService
+}
+
+func NewImportService(accounts *AccountService, oauth *OAuthService) *ImportService {
+	return &ImportService{
+		accounts: accounts,
+		oauth:    oauth,
+	}
+}
+
+// Preview reports for every remote in the config which account type it maps
+// to and whether it can be imported.
+func (s *ImportService) Preview(ctx context.Context, req *models.ImportConfigRequest) ([]models.ImportRemote, error) {
+	sections, err := rclone.ParseConfig([]byte(req.Config), req.Password)
+	if err != nil {
+		return nil, validationErrorf("%v", err)
+	}
+
+	remotes := make([]models.ImportRemote, 0, len(sections))
+	for i := range sections {
+		remote, _ := s.inspect(ctx, &sections[i])
+		remotes = append(remotes, *remote)
+	}
+	return remotes, nil
+}
+
+// Import registers the selected remotes as accounts, testing the connection
+// and fetching quota like any new account. Every selected remote is reported
+// back with its outcome.
+func (s *ImportService) Import(ctx context.Context, req *models.ImportConfigRequest) ([]models.ImportRemote, error) {
+	if len(req.Remotes) == 0 {
+		return nil, validationErrorf("remotes is required")
+	}
+
+	sections, err := rclone.ParseConfig([]byte(req.Config), req.Password)
+	if err != nil {
+		return nil, validationErrorf("%v", err)
+	}
+	byName := make(map[string]*rclone.ConfigSection, len(sections))
+	for i := range sections {
+		byName[sections[i].Name] = &sections[i]
+	}
+
+	results := make([]models.ImportRemote, 0, len(req.Remotes))
+	for _, name := range req.Remotes {
+		section, ok := byName[name]
+		if !ok {
+			results = append(results, models.ImportRemote{Remote: name, Status: "failed", Reason: "remote not found in config"})
+			continue
+		}
+
+		remote, account := s.inspect(ctx, section)
+		if remote.Status != "importable" {
+			results = append(results, *remote)
+			continue
+		}
+
+		created, err := s.accounts.registerAccount(account)
+		if err != nil {
+			remote.Status = "failed"
+			remote.Reason = err.Error()
+		} else {
+			remote.Status = "imported"
+			remote.AccountID = created.ID
+		}
+		results = append(results, *remote)
+	}
+
+	return results, nil
+}
+
+// inspect maps a config section to a new account and checks whether that
+// account is already registered. The account is nil unless the remote can
+// be imported.
+func (s *ImportService) inspect(ctx context.Context, section *rclone.ConfigSection) (*models.ImportRemote, *models.Account) {
+	remote := &models.ImportRemote{Remote: section.Name, RcloneType: section.Type()}
+
+	account, err := accountFromSection(section)
+	if err != nil {
+		remote.Status = "unsupported"
+		remote.Reason = err.Error()
+		return remote, nil
+	}
+	remote.Type = account.Type
+
+	if account.Email == "" {
+		token := &oauth2.Token{AccessToken: account.AccessToken, TokenType: account.TokenType}
+		identity, err := s.oauth.FetchIdentity(ctx, account.Type, token)
+		if err != nil {
+			// Usually an expired access token we can't refresh ourselves
+			account.Email = section.Name
+			remote.Reason = "could not look up the account email, the remote name is used instead"
+		} else {
+			account.Email = identity.Email
+		}
+	}
+	remote.Email = account.Email
+
+	existing, err := s.accounts.findAccount(account.Type, account.Email, account.SharedDriveID)
+	if err != nil {
+		remote.Status = "failed"
+		remote.Reason = err.Error()
+		return remote, nil
+	}
+	if existing != "" {
+		remote.Status = "exists"
+		remote.AccountID = existing
+		return remote, nil
+	}
+
+	remote.Status = "importable"
+	return remote, account
+}
+
+// accountFromSection builds an account from an rclone remote's parameters.
+// OAuth accounts are returned without an email.
+func accountFromSection(section *rclone.ConfigSection) (*models.Account, error) {
+	rcloneType := section.Type()
+	if rcloneType == "" {
+		return nil, fmt.Errorf("remote has no type")
+	}
+
+	var provider *providers.Provider
+	for _, p := range providers.All() {
+		if p.RcloneType == rcloneType {
+			provider = p
+			break
+		}
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("rclone backend %q is not supported", rcloneType)
+	}
+
+	account := &models.Account{
+		ID:        uuid.New().String(),
+		Name:      section.Name,
+		Type:      provider.ID,
+		Status:    "active",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	params := section.Params
+
+	if !provider.UsesOAuth() {
+		raw, err := sectionCredentials(provider.ID, params)
+		if err != nil {
+			return nil, err
+		}
+		creds, identity, err := parseCredentials(provider.ID, raw)
+		if err != nil {
+			return nil, err
+		}
+		account.AuthMethod = "credentials"
+		account.Credentials = creds
+		account.Email = identity
+		return account, nil
+	}
+
+	if provider.ID == "google" {
+		account.SharedDriveID = params["team_drive"]
+		if params["service_account_file"] != "" {
+			return nil, fmt.Errorf("service_account_file points at a file on another machine, add the key as a service account instead")
+		}
+		if key := params["service_account_credentials"]; key != "" {
+			email, err := serviceAccountEmail([]byte(key), params["impersonate"])
+			if err != nil {
+				return nil, err
+			}
+			account.AuthMethod = "service_account"
+			account.ServiceAccountJSON = key
+			account.Impersonate = params["impersonate"]
+			account.Email = email
+			return account, nil
+		}
+	}
+
+	if params["token"] == "" {
+		return nil, fmt.Errorf("remote has no token, run `rclone config reconnect %s:` first", section.Name)
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(params["token"]), &token); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	account.AuthMethod = "imported"
+	account.AccessToken = token.AccessToken
+	account.RefreshToken = token.RefreshToken
+	account.TokenType = token.TokenType
+	account.TokenExpiry = token.Expiry.UTC()
+	account.ClientID = params["client_id"]
+	account.ClientSecret = params["client_secret"]
+
+	return account, nil
+}
+
+// sectionCredentials converts the parameters of an S3, WebDAV or SFTP remote
+// into the credential payload CreateAccount takes, revealing obscured
+// passwords.
+func sectionCredentials(accountType string, params map[string]string) (json.RawMessage, error) {
+	reveal := func(key string) (string, error) {
+		if params[key] == "" {
+			return "", nil
+		}
+		value, err := rclone.Reveal(params[key])
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key, err)
+		}
+		return value, nil
+	}
+
+	var creds interface{}
+	switch accountType {
+	case "s3":
+		// An s3 remote covers every bucket of the login; accounts are one bucket
+		return nil, fmt.Errorf("s3 remotes are not tied to a bucket, add the bucket as an S3 account instead")
+
+	case "webdav":
+		if params["bearer_token"] != "" || params["bearer_token_command"] != "" {
+			return nil, fmt.Errorf("webdav bearer token authentication is not supported")
+		}
+		pass, err := reveal("pass")
+		if err != nil {
+			return nil, err
+		}
+		creds = models.WebDAVCredentials{
+			URL:      params["url"],
+			Vendor:   params["vendor"],
+			User:     params["user"],
+			Password: pass,
+		}
+
+	case "sftp":
+		if params["key_file"] != "" {
+			return nil, fmt.Errorf("key_file points at a file on another machine, use key_pem or a password")
+		}
+		port := 22
+		if params["port"] != "" {
+			p, err := strconv.Atoi(params["port"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid port: %s", params["port"])
+			}
+			port = p
+		}
+		pass, err := reveal("pass")
+		if err != nil {
+			return nil, err
+		}
+		keyPass, err := reveal("key_file_pass")
+		if err != nil {
+			return nil, err
+		}
+		creds = models.SFTPCredentials{
+			Host:                 params["host"],
+			Port:                 port,
+			User:                 params["user"],
+			Password:             pass,
+			PrivateKey:           strings.ReplaceAll(params["key_pem"], `\n`, "\n"),
+			PrivateKeyPassphrase: keyPass,
+		}
+
+	default:
+		return nil, fmt.Errorf("%s remotes cannot be imported", accountType)
+	}
+
+	return json.Marshal(creds)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"pooled-storage/internal/models"
+	"testing"
+)
+
+// importConfig has one remote of each outcome. "potato" is obscured as
+// rclone does it.
+const importConfig = `
+# comment
+[dav]
+type = webdav
+url = https://cloud.example.com/remote.php/dav/files/ann
+vendor = nextcloud
+user = ann
+pass = YWFhYWFhYWFhYWFhYWFhYXMaGgIlEQ
+
+[bucket]
+type = s3
+provider = AWS
+
+[sa]
+type = drive
+service_account_file = /home/ann/sa.json
+
+[mega]
+type = mega
+user = ann
+`
+
+func TestImportConfig(t *testing.T) {
+	env := newTestEnv(t)
+	s := NewImportService(env.accounts, NewOAuthService(env.db, env.sealer))
+	ctx := context.Background()
+
+	status := func(remotes []models.ImportRemote) map[string]string {
+		got := map[string]string{}
+		for _, r := range remotes {
+			got[r.Remote] = r.Status
+		}
+		return got
+	}
+	check := func(what string, got, want map[string]string) {
+		t.Helper()
+		for remote, st := range want {
+			if got[remote] != st {
+				t.Errorf("%s: %s is %q, want %q", what, remote, got[remote], st)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, want %v", what, got, want)
+		}
+	}
+
+	remotes, err := s.Preview(ctx, &models.ImportConfigRequest{Config: importConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("preview", status(remotes), map[string]string{
+		"dav": "importable", "bucket": "unsupported", "sa": "unsupported", "mega": "unsupported",
+	})
+	for _, r := range remotes {
+		if r.Status == "unsupported" && r.Reason == "" {
+			t.Errorf("%s is unsupported without a reason", r.Remote)
+		}
+		if r.Remote == "dav" && (r.Type != "webdav" || r.Email != "ann@cloud.example.com/remote.php/dav/files/ann") {
+			t.Errorf("dav previewed as %+v", r)
+		}
+	}
+
+	results, err := s.Import(ctx, &models.ImportConfigRequest{Config: importConfig, Remotes: []string{"dav", "mega", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("import", status(results), map[string]string{"dav": "imported", "mega": "unsupported", "missing": "failed"})
+
+	// The obscured password is stored revealed
+	account, err := env.accounts.getAccountWithSecrets(results[0].AccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var creds models.WebDAVCredentials
+	if err := json.Unmarshal([]byte(account.Credentials), &creds); err != nil {
+		t.Fatal(err)
+	}
+	if creds.Password != "potato" {
+		t.Errorf("imported password = %q, want %q", creds.Password, "potato")
+	}
+
+	// Importing again finds the account
+	remotes, err = s.Preview(ctx, &models.ImportConfigRequest{Config: importConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("second preview", status(remotes), map[string]string{
+		"dav": "exists", "bucket": "unsupported", "sa": "unsupported", "mega": "unsupported",
+	})
+	results, err = s.Import(ctx, &models.ImportConfigRequest{Config: importConfig, Remotes: []string{"dav"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != "exists" || results[0].AccountID != account.ID {
+		t.Errorf("second import = %+v, want the existing account %s", results[0], account.ID)
+	}
+
+	var verr *ValidationError
+	if _, err := s.Import(ctx, &models.ImportConfigRequest{Config: importConfig}); !errors.As(err, &verr) {
+		t.Errorf("import without remotes: err = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/providers"
+	"pooled-storage/internal/secrets"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// How long a user has to complete the provider consent screen.
+const oauthStateTTL = 10 * time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired OAuth state")
+
+type OAuthService struct {
+	db      *sql.DB
+	secrets *secrets.Sealer
+
+	// IdentityEndpoints maps provider -> URL used by FetchIdentity.
+	IdentityEndpoints map[string]string
+}
+
+func NewOAuthService(db *sql.DB, sealer *secrets.Sealer) *OAuthService {
+	return &OAuthService{
+		db:                db,
+		secrets:           sealer,
+		IdentityEndpoints: identityEndpointsFromEnv(),
+	}
+}
+
+func (s *OAuthService) GetConfig(provider string) (*oauth2.Config, error) {
+	p, ok := providers.Get(provider)
+	if !ok || !p.UsesOAuth() {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+
+	creds, err := s.GetCredentials(provider)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return nil, fmt.Errorf("%s OAuth not configured", p.Label)
+	}
+
+	return &oauth2.Config{
+		ClientID:     creds.ClientID,
+		ClientSecret: creds.ClientSecret,
+		RedirectURL:  creds.RedirectURI,
+		Scopes:       p.Scopes,
+		Endpoint:     p.Endpoint,
+	}, nil
+}
+
+// GetCredentials returns the client credentials for a provider from the
+// oauth_configs table, falling back to <PROVIDER>_CLIENT_ID/_SECRET in the
+// environment. It returns nil if neither is set.
+func (s *OAuthService) GetCredentials(provider string) (*models.OAuthConfig, error) {
+	var cfg models.OAuthConfig
+	query := `SELECT provider, client_id, client_secret, redirect_uri, updated_at FROM oauth_configs WHERE provider = ?`
+	err := s.db.QueryRow(query, provider).Scan(&cfg.Provider, &cfg.ClientID, &cfg.ClientSecret,
+		&cfg.RedirectURI, &cfg.UpdatedAt)
+	if err == nil {
+		if cfg.ClientSecret, err = s.secrets.Open(cfg.ClientSecret); err != nil {
+			return nil, err
+		}
+		cfg.Source = "database"
+		if cfg.RedirectURI == "" {
+			cfg.RedirectURI = defaultRedirectURI()
+		}
+		return &cfg, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	prefix := strings.ToUpper(provider)
+	clientID := os.Getenv(prefix + "_CLIENT_ID")
+	clientSecret := os.Getenv(prefix + "_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		return nil, nil
+	}
+
+	return &models.OAuthConfig{
+		Provider:     provider,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURI:  defaultRedirectURI(),
+		Source:       "environment",
+	}, nil
+}
+
+// ListCredentials reports the credential status of every supported provider.
+func (s *OAuthService) ListCredentials() (map[string]models.OAuthConfig, error) {
+	all := providers.All()
+	configs := make(map[string]models.OAuthConfig, len(all))
+	for _, p := range all {
+		if !p.UsesOAuth() {
+			continue
+		}
+		provider := p.ID
+		cfg, err := s.GetCredentials(provider)
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			cfg = &models.OAuthConfig{Provider: provider, RedirectURI: defaultRedirectURI()}
+		}
+		cfg.Label = p.Label
+		cfg.Configured = cfg.ClientID != ""
+		configs[provider] = *cfg
+	}
+	return configs, nil
+}
+
+// SaveCredentials stores client credentials for a provider. An empty secret
+// keeps the one already stored so the client ID or redirect can be changed
+// without re-entering it.
+func (s *OAuthService) SaveCredentials(provider string, req *models.SaveOAuthConfigRequest) (*models.OAuthConfig, error) {
+	if p, ok := providers.Get(provider); !ok || !p.UsesOAuth() {
+		return nil, validationErrorf("unsupported provider: %s", provider)
+	}
+
+	clientID := strings.TrimSpace(req.ClientID)
+	clientSecret := strings.TrimSpace(req.ClientSecret)
+	redirectURI := strings.TrimSpace(req.RedirectURI)
+
+	if clientID == "" {
+		return nil, validationErrorf("client_id is required")
+	}
+	if clientSecret == "" {
+		var stored string
+		err := s.db.QueryRow("SELECT client_secret FROM oauth_configs WHERE provider = ?", provider).Scan(&stored)
+		if err == sql.ErrNoRows {
+			return nil, validationErrorf("client_secret is required")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if clientSecret, err = s.secrets.Open(stored); err != nil {
+			return nil, err
+		}
+	}
+	if redirectURI != "" {
+		u, err := url.Parse(redirectURI)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, validationErrorf("redirect_uri must be an absolute http(s) URL")
+		}
+	}
+
+	sealedSecret, err := s.secrets.Seal(clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO oauth_configs (provider, client_id, client_secret, redirect_uri, updated_at)
+			  VALUES (?, ?, ?, ?, ?)
+			  ON CONFLICT(provider) DO UPDATE SET client_id = excluded.client_id,
+			  client_secret = excluded.client_secret, redirect_uri = excluded.redirect_uri,
+			  updated_at = excluded.updated_at`
+	if _, err := s.db.Exec(query, provider, clientID, sealedSecret, redirectURI, time.Now()); err != nil {
+		return nil, err
+	}
+
+	cfg, err := s.GetCredentials(provider)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Configured = true
+	return cfg, nil
+}
+
+// DeleteCredentials removes stored credentials; the environment fallback
+// applies again afterwards.
+func (s *OAuthService) DeleteCredentials(provider string) error {
+	_, err := s.db.Exec("DELETE FROM oauth_configs WHERE provider = ?", provider)
+	return err
+}
+
+func defaultRedirectURI() string {
+	if redirect := os.Getenv("OAUTH_REDIRECT_URL"); redirect != "" {
+		return redirect
+	}
+
+	hostIP := os.Getenv("HOST_IP")
+	if hostIP == "" {
+		hostIP = "192.168.100.14"
+	}
+
+	return fmt.Sprintf("http://%s:20080/api/oauth/callback", hostIP)
+}
+
+// BeginAuth creates a random single-use state and PKCE verifier for the
+// provider, stores them server-side and returns the consent URL.
+func (s *OAuthService) BeginAuth(provider string) (string, string, error) {
+	p, ok := providers.Get(provider)
+	if !ok || !p.UsesOAuth() {
+		return "", "", fmt.Errorf("unsupported provider: %s", provider)
+	}
+	config, err := s.GetConfig(provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	verifier := oauth2.GenerateVerifier()
+
+	// Drop states nobody came back for
+	s.db.Exec("DELETE FROM oauth_states WHERE expires_at < ?", time.Now().UTC())
+
+	query := `INSERT INTO oauth_states (state, provider, code_verifier, expires_at) VALUES (?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, state, provider, verifier, time.Now().Add(oauthStateTTL).UTC()); err != nil {
+		return "", "", err
+	}
+
+	opts := append(p.AuthCodeOptions(), oauth2.S256ChallengeOption(verifier))
+	url := config.AuthCodeURL(state, opts...)
+	return url, state, nil
+}
+
+// CompleteAuth consumes the state issued by BeginAuth and exchanges the
+// authorization code using its PKCE verifier. An empty provider means the
+// one recorded with the state (provider redirects only carry code and
+// state). Unknown, reused, expired or mismatched states return
+// ErrInvalidState. The provider the token belongs to is returned.
+func (s *OAuthService) CompleteAuth(ctx context.Context, provider, state, code string) (*oauth2.Token, string, error) {
+	provider, verifier, err := s.consumeState(provider, state)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, err := s.GetConfig(provider)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to exchange token: %w", err)
+	}
+
+	return token, provider, nil
+}
+
+func (s *OAuthService) consumeState(provider, state string) (string, string, error) {
+	if state == "" {
+		return "", "", ErrInvalidState
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var storedProvider, verifier string
+	var expiresAt time.Time
+	err = tx.QueryRow("SELECT provider, code_verifier, expires_at FROM oauth_states WHERE state = ?", state).
+		Scan(&storedProvider, &verifier, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", "", ErrInvalidState
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// Single use: delete before checking anything else
+	if _, err := tx.Exec("DELETE FROM oauth_states WHERE state = ?", state); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	if (provider != "" && storedProvider != provider) || time.Now().After(expiresAt) {
+		return "", "", ErrInvalidState
+	}
+
+	return storedProvider, verifier, nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pooled-storage/internal/models"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenStub answers every token request, wherever it is sent, once the
+// verifier it is given matches challenge.
+func tokenStub(t *testing.T, challenge *string) context.Context {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if got := base64.RawURLEncoding.EncodeToString(sum[:]); got != *challenge {
+			t.Errorf("verifier hashes to %q, want the challenge %q", got, *challenge)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, client)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestOAuthState(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "id")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "secret")
+	t.Setenv("MICROSOFT_CLIENT_ID", "id")
+	t.Setenv("MICROSOFT_CLIENT_SECRET", "secret")
+	env := newTestEnv(t)
+	s := NewOAuthService(env.db, env.sealer)
+
+	var challenge string
+	ctx := tokenStub(t, &challenge)
+	begin := func() string {
+		authURL, state, err := s.BeginAuth("google")
+		if err != nil {
+			t.Fatal(err)
+		}
+		consent, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := consent.Query()
+		if q.Get("state") != state || q.Get("code_challenge_method") != "S256" {
+			t.Fatalf("consent URL %s does not carry the state and an S256 challenge", authURL)
+		}
+		challenge = q.Get("code_challenge")
+		return state
+	}
+
+	state := begin()
+	token, provider, err := s.CompleteAuth(ctx, "", state, "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider != "google" || token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("got %s token %+v", provider, token)
+	}
+	if _, _, err := s.CompleteAuth(ctx, "", state, "code"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("reused state: err = %v, want ErrInvalidState", err)
+	}
+
+	expired := begin()
+	if _, err := env.db.Exec("UPDATE oauth_states SET expires_at = ? WHERE state = ?", time.Now().Add(-time.Minute).UTC(), expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.CompleteAuth(ctx, "", expired, "code"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expired state: err = %v, want ErrInvalidState", err)
+	}
+
+	// A state is used up even when it is presented for the wrong provider
+	other := begin()
+	if _, _, err := s.CompleteAuth(ctx, "microsoft", other, "code"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("state of another provider: err = %v, want ErrInvalidState", err)
+	}
+	if _, _, err := s.CompleteAuth(ctx, "google", other, "code"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("state after a failed attempt: err = %v, want ErrInvalidState", err)
+	}
+
+	for _, unknown := range []string{"", "made-up"} {
+		if _, _, err := s.CompleteAuth(ctx, "", unknown, "code"); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("state %q: err = %v, want ErrInvalidState", unknown, err)
+		}
+	}
+}
+
+func TestOAuthCredentials(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "env-id")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "env-secret")
+	t.Setenv("MICROSOFT_CLIENT_ID", "")
+	t.Setenv("MICROSOFT_CLIENT_SECRET", "")
+	env := newTestEnv(t)
+	s := NewOAuthService(env.db, env.sealer)
+
+	creds := func(provider string) *models.OAuthConfig {
+		t.Helper()
+		cfg, err := s.GetCredentials(provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	if cfg := creds("google"); cfg == nil || cfg.Source != "environment" || cfg.ClientID != "env-id" || cfg.ClientSecret != "env-secret" {
+		t.Errorf("google without stored credentials = %+v, want those of the environment", cfg)
+	}
+	if cfg := creds("microsoft"); cfg != nil {
+		t.Errorf("microsoft without any credentials = %+v, want nil", cfg)
+	}
+
+	// A first save needs a secret
+	var verr *ValidationError
+	if _, err := s.SaveCredentials("microsoft", &models.SaveOAuthConfigRequest{ClientID: "id"}); !errors.As(err, &verr) {
+		t.Errorf("save without a secret: err = %v, want a validation error", err)
+	}
+
+	if _, err := s.SaveCredentials("google", &models.SaveOAuthConfigRequest{ClientID: "db-id", ClientSecret: "db-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := creds("google"); cfg.Source != "database" || cfg.ClientID != "db-id" || cfg.ClientSecret != "db-secret" {
+		t.Errorf("google after saving = %+v, want the stored credentials", cfg)
+	}
+
+	// An empty secret keeps the stored one
+	saved, err := s.SaveCredentials("google", &models.SaveOAuthConfigRequest{ClientID: "new-id", RedirectURI: "https://example.com/cb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ClientID != "new-id" || saved.ClientSecret != "db-secret" || saved.RedirectURI != "https://example.com/cb" {
+		t.Errorf("google after changing the client ID = %+v, want the secret kept", saved)
+	}
+
+	if err := s.DeleteCredentials("google"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := creds("google"); cfg == nil || cfg.Source != "environment" || cfg.ClientID != "env-id" {
+		t.Errorf("google after deleting = %+v, want the environment again", cfg)
+	}
+}
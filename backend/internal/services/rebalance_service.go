@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Percentage points between the fullest and emptiest member.
+	defaultRebalanceSpread = 10.0
+	// A plan stops at this many moves; plan again for the rest.
+	maxRebalanceMoves = 10000
+)
+
+// RebalanceService evens out how full the members of a pool are. Strategies
+// like eplus keep writing to one account until it is full, so members drift
+// apart; a rebalance plans file moves from the fullest members to the
+// emptiest and carries them out one file at a time.
+type RebalanceService struct {
+	db       *sql.DB
+	storage  *StorageService
+	accounts *AccountService
+	rclone   rclone.StorageBackend
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc // running jobs by ID
+	// limited is the running job that set rclone's bandwidth limit. The
+	// limit is one for the whole rclone process, so only one job at a time
+	// may set it.
+	limited string
+}
+
+func NewRebalanceService(db *sql.DB, storage *StorageService, accounts *AccountService, rclone rclone.StorageBackend) *RebalanceService {
+	return &RebalanceService{
+		db:       db,
+		storage:  storage,
+		accounts: accounts,
+		rclone:   rclone,
+		cancels:  make(map[string]context.CancelFunc),
+	}
+}
+
+// FailInterrupted marks rebalances cut short by a restart as failed.
+func (s *RebalanceService) FailInterrupted() (int, error) {
+	now := time.Now()
+	query := `UPDATE rebalance_jobs SET status = 'failed', error = ?, finished_at = ?, updated_at = ? WHERE status = 'running'`
+	result, err := s.db.Exec(query, "interrupted by a restart", now, now)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// PlanRebalance measures every member of the pool and stores a plan of the
+// moves that bring them within the target spread. Nothing is moved until
+// the plan is started, so the plan doubles as a dry run.
+func (s *RebalanceService) PlanRebalance(poolID string, req *models.RebalanceRequest) (*models.RebalanceJob, error) {
+	spread := req.TargetSpread
+	if spread == 0 {
+		spread = defaultRebalanceSpread
+	}
+	if spread < 0 || spread >= 100 {
+		return nil, validationErrorf("target spread must be between 0 and 100 percentage points")
+	}
+	if req.BandwidthLimit != "" && !validBandwidthLimit(req.BandwidthLimit) {
+		return nil, validationErrorf("invalid bandwidth limit: %s", req.BandwidthLimit)
+	}
+
+	pool, err := s.storage.GetPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+	if pool.CreatePolicy == "all" {
+		return nil, validationErrorf("pools that create files on every account have nothing to rebalance")
+	}
+	if len(pool.Accounts) < 2 {
+		return nil, validationErrorf("pool needs at least two accounts to rebalance")
+	}
+
+	now := time.Now()
+	job := &models.RebalanceJob{
+		ID:             uuid.New().String(),
+		PoolID:         poolID,
+		Status:         "planned",
+		TargetSpread:   spread,
+		BandwidthLimit: req.BandwidthLimit,
+		Accounts:       []models.RebalanceAccount{},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	var members []*balanceMember
+	for i := range pool.Accounts {
+		account := &pool.Accounts[i]
+		entry := models.RebalanceAccount{AccountID: account.ID, Name: account.Name}
+
+		total, used, err := fetchQuota(s.rclone, account)
+		if err != nil {
+			// Fall back to the last known quota
+			total, used = account.QuotaTotal, account.QuotaUsed
+		}
+		files, err := s.rclone.ListFiles(pool, account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of %s: %w", account.Name, err)
+		}
+		for _, file := range files {
+			entry.PoolBytes += file.Size
+		}
+		entry.QuotaTotal = total
+		entry.QuotaUsed = used
+		entry.PoolFiles = len(files)
+
+		switch {
+		case account.PoolMode == "ro":
+			entry.Skipped = "read-only in the pool"
+		case total <= 0:
+			entry.Skipped = "no quota or capacity known"
+		default:
+			entry.UsagePercent = usagePercent(used, total)
+			members = append(members, newBalanceMember(account.ID, total, used, files))
+		}
+		job.Accounts = append(job.Accounts, entry)
+	}
+
+	job.Moves = planMoves(members, spread)
+	job.MovesTotal = len(job.Moves)
+	for _, move := range job.Moves {
+		job.BytesTotal += move.Size
+	}
+	for i := range job.Accounts {
+		entry := &job.Accounts[i]
+		for _, member := range members {
+			if member.id == entry.AccountID {
+				entry.PlannedPercent = usagePercent(member.used, member.total)
+			}
+		}
+	}
+
+	accounts, err := json.Marshal(job.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	moves, err := json.Marshal(job.Moves)
+	if err != nil {
+		return nil, err
+	}
+	query := `INSERT INTO rebalance_jobs (id, pool_id, status, target_spread, bandwidth_limit, accounts, moves,
+			  moves_total, bytes_total, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.Exec(query, job.ID, job.PoolID, job.Status, job.TargetSpread, job.BandwidthLimit,
+		string(accounts), string(moves), job.MovesTotal, job.BytesTotal, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// StartRebalance carries out a planned rebalance in the background.
+func (s *RebalanceService) StartRebalance(id string) (*models.RebalanceJob, error) {
+	st := s.storage
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, err := s.GetRebalance(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != "planned" {
+		return nil, validationErrorf("rebalance is %s, only planned rebalances can be started", job.Status)
+	}
+	if len(job.Moves) == 0 {
+		return nil, validationErrorf("nothing to move, the pool is already balanced")
+	}
+
+	pool, err := st.GetPool(job.PoolID)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.refuseWhileMoving(pool.ID, ""); err != nil {
+		return nil, err
+	}
+	if job.BandwidthLimit != "" {
+		s.mu.Lock()
+		limited := s.limited
+		s.mu.Unlock()
+		if limited != "" {
+			return nil, validationErrorf("rebalance %s is limiting bandwidth already; wait for it or start this one without a limit", limited)
+		}
+	}
+	// Members may have changed since the plan was made
+	members := make(map[string]*models.Account, len(pool.Accounts))
+	for i := range pool.Accounts {
+		if pool.Accounts[i].PoolMode != "ro" {
+			members[pool.Accounts[i].ID] = &pool.Accounts[i]
+		}
+	}
+	for _, move := range job.Moves {
+		for _, accountID := range []string{move.From, move.To} {
+			if members[accountID] == nil {
+				return nil, validationErrorf("account %s is no longer a writable member of the pool, plan again", accountID)
+			}
+		}
+	}
+
+	job.Status = "running"
+	job.UpdatedAt = time.Now()
+	if _, err := s.db.Exec("UPDATE rebalance_jobs SET status = ?, updated_at = ? WHERE id = ?", job.Status, job.UpdatedAt, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancels[id] = cancel
+	if job.BandwidthLimit != "" {
+		s.limited = id
+	}
+	s.mu.Unlock()
+	go s.run(ctx, job, pool, members)
+
+	return job, nil
+}
+
+func (s *RebalanceService) run(ctx context.Context, job *models.RebalanceJob, pool *models.StoragePool, members map[string]*models.Account) {
+	defer func() {
+		s.mu.Lock()
+		if cancel, ok := s.cancels[job.ID]; ok {
+			cancel()
+			delete(s.cancels, job.ID)
+		}
+		s.mu.Unlock()
+	}()
+
+	// rclone has one limit for all its transfers, so it is only touched
+	// when the job asks for one, and StartRebalance lets one job at a time
+	// do that. Other rebalances running meanwhile are limited too.
+	if job.BandwidthLimit != "" {
+		defer func() {
+			s.mu.Lock()
+			s.limited = ""
+			s.mu.Unlock()
+		}()
+		previous, err := s.rclone.SetBandwidthLimit(job.BandwidthLimit)
+		if err != nil {
+			s.finish(job.ID, "failed", fmt.Errorf("failed to set bandwidth limit: %w", err))
+			return
+		}
+		defer func() {
+			if _, err := s.rclone.SetBandwidthLimit(previous); err != nil {
+				log.Printf("Failed to restore bandwidth limit %q: %v", previous, err)
+			}
+		}()
+	}
+
+	var lastErr error
+	done, errors := 0, 0
+	var bytes int64
+	for _, move := range job.Moves {
+		err := s.rclone.MoveFile(ctx, pool, members[move.From], members[move.To], move.Path)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			errors++
+			lastErr = fmt.Errorf("%s: %w", move.Path, err)
+			log.Printf("Rebalance of pool %s: failed to move %s", pool.ID, lastErr)
+		} else {
+			bytes += move.Size
+		}
+		done++
+
+		var message string
+		if lastErr != nil {
+			message = lastErr.Error()
+		}
+		query := `UPDATE rebalance_jobs SET moves_done = ?, bytes_done = ?, errors = ?, error = ?, updated_at = ? WHERE id = ?`
+		s.db.Exec(query, done, bytes, errors, message, time.Now(), job.ID)
+	}
+
+	status := "completed"
+	if ctx.Err() != nil {
+		status = "cancelled"
+	}
+	s.finish(job.ID, status, lastErr)
+
+	// Stats read the stored quotas
+	for accountID := range members {
+		if err := s.accounts.RefreshQuota(accountID); err != nil {
+			log.Printf("Failed to refresh quota of account %s: %v", accountID, err)
+		}
+	}
+}
+
+func (s *RebalanceService) finish(jobID, status string, err error) {
+	var message string
+	if err != nil {
+		message = err.Error()
+	}
+	now := time.Now()
+	query := `UPDATE rebalance_jobs SET status = ?, error = ?, finished_at = ?, updated_at = ? WHERE id = ?`
+	if _, err := s.db.Exec(query, status, message, now, now, jobID); err != nil {
+		log.Printf("Failed to update rebalance job %s: %v", jobID, err)
+	}
+}
+
+// CancelRebalance stops a running rebalance after the file being moved, or
+// discards a plan that hasn't been started.
+func (s *RebalanceService) CancelRebalance(id string) error {
+	s.mu.Lock()
+	cancel, ok := s.cancels[id]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+		return nil
+	}
+
+	job, err := s.GetRebalance(id)
+	if err != nil {
+		return err
+	}
+	if job.Status != "planned" {
+		return validationErrorf("rebalance is %s", job.Status)
+	}
+	s.finish(id, "cancelled", nil)
+	return nil
+}
+
+func (s *RebalanceService) GetRebalance(id string) (*models.RebalanceJob, error) {
+	query := `SELECT id, pool_id, status, target_spread, bandwidth_limit, accounts, moves, moves_done, moves_total,
+			  bytes_done, bytes_total, errors, error, created_at, updated_at, finished_at
+			  FROM rebalance_jobs WHERE id = ?`
+	var job models.RebalanceJob
+	var bandwidth, accounts, moves, message sql.NullString
+	var finishedAt sql.NullTime
+	err := s.db.QueryRow(query, id).Scan(&job.ID, &job.PoolID, &job.Status, &job.TargetSpread, &bandwidth,
+		&accounts, &moves, &job.MovesDone, &job.MovesTotal, &job.BytesDone, &job.BytesTotal, &job.Errors,
+		&message, &job.CreatedAt, &job.UpdatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.BandwidthLimit = bandwidth.String
+	job.Error = message.String
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if err := json.Unmarshal([]byte(accounts.String), &job.Accounts); err != nil {
+		return nil, fmt.Errorf("invalid stored accounts: %w", err)
+	}
+	if err := json.Unmarshal([]byte(moves.String), &job.Moves); err != nil {
+		return nil, fmt.Errorf("invalid stored moves: %w", err)
+	}
+	return &job, nil
+}
+
+// ListRebalances returns the rebalances of a pool, newest first, without
+// their moves.
+func (s *RebalanceService) ListRebalances(poolID string) ([]models.RebalanceJob, error) {
+	if _, err := s.storage.GetPool(poolID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, pool_id, status, target_spread, bandwidth_limit, accounts, moves_done, moves_total,
+			  bytes_done, bytes_total, errors, error, created_at, updated_at, finished_at
+			  FROM rebalance_jobs WHERE pool_id = ? ORDER BY created_at DESC`
+	rows, err := s.db.Query(query, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.RebalanceJob{}
+	for rows.Next() {
+		var job models.RebalanceJob
+		var bandwidth, accounts, message sql.NullString
+		var finishedAt sql.NullTime
+		err := rows.Scan(&job.ID, &job.PoolID, &job.Status, &job.TargetSpread, &bandwidth, &accounts,
+			&job.MovesDone, &job.MovesTotal, &job.BytesDone, &job.BytesTotal, &job.Errors, &message,
+			&job.CreatedAt, &job.UpdatedAt, &finishedAt)
+		if err != nil {
+			return nil, err
+		}
+		job.BandwidthLimit = bandwidth.String
+		job.Error = message.String
+		if finishedAt.Valid {
+			job.FinishedAt = &finishedAt.Time
+		}
+		json.Unmarshal([]byte(accounts.String), &job.Accounts)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// balanceMember is a pool member as planMoves sees it.
+type balanceMember struct {
+	id        string
+	total     int64
+	used      int64
+	files     []rclone.ListItem // movable files, largest first
+	paths     map[string]bool
+	exhausted bool // none of its files helps any more
+}
+
+func newBalanceMember(id string, total, used int64, files []rclone.ListItem) *balanceMember {
+	m := &balanceMember{id: id, total: total, used: used, paths: make(map[string]bool, len(files))}
+	for _, file := range files {
+		m.paths[file.Path] = true
+		if file.Size > 0 {
+			m.files = append(m.files, file)
+		}
+	}
+	sort.Slice(m.files, func(i, j int) bool { return m.files[i].Size > m.files[j].Size })
+	return m
+}
+
+func (m *balanceMember) fill() float64 {
+	return usagePercent(m.used, m.total)
+}
+
+// planMoves repeatedly moves a file from the fullest member to the emptiest
+// until they are within spread percentage points of each other. It takes
+// the largest file that doesn't overshoot levelling the two, and gives up on
+// a member once none of its files fits.
+func planMoves(members []*balanceMember, spread float64) []models.RebalanceMove {
+	moves := []models.RebalanceMove{}
+	for len(moves) < maxRebalanceMoves {
+		var src, dst *balanceMember
+		for _, m := range members {
+			if !m.exhausted && len(m.files) > 0 && (src == nil || m.fill() > src.fill()) {
+				src = m
+			}
+			if dst == nil || m.fill() < dst.fill() {
+				dst = m
+			}
+		}
+		if src == nil || src == dst || src.fill()-dst.fill() <= spread {
+			break
+		}
+
+		// Moving this many bytes leaves both equally full
+		level := (float64(src.used)*float64(dst.total) - float64(dst.used)*float64(src.total)) / float64(src.total+dst.total)
+		free := dst.total - dst.used
+		pick := -1
+		for i, file := range src.files {
+			if float64(file.Size) <= level && file.Size <= free && !dst.paths[file.Path] {
+				pick = i
+				break
+			}
+		}
+		if pick < 0 {
+			src.exhausted = true
+			continue
+		}
+
+		file := src.files[pick]
+		src.files = append(src.files[:pick], src.files[pick+1:]...)
+		delete(src.paths, file.Path)
+		dst.paths[file.Path] = true
+		src.used -= file.Size
+		dst.used += file.Size
+		moves = append(moves, models.RebalanceMove{Path: file.Path, Size: file.Size, From: src.id, To: dst.id})
+	}
+	return moves
+}
+
+func usagePercent(used, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(used) / float64(total) * 100
+}
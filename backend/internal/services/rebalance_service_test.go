@@ -0,0 +1,140 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"testing"
+	"time"
+)
+
+// unbalancedPool returns a pool of two members where the first holds all
+// the files and is nearly full.
+func unbalancedPool(t *testing.T, env *testEnv, name string) *models.StoragePool {
+	t.Helper()
+	full, empty := env.addAccount(t, name+"-full"), env.addAccount(t, name+"-empty")
+	pool := env.addPool(t, models.CreatePoolRequest{Name: name, Strategy: "eplus"}, full, empty)
+	env.backend.SetQuota(full.ID, 1000, 900)
+	env.backend.SetQuota(empty.ID, 1000, 100)
+	var files []rclone.ListItem
+	for i := 0; i < 10; i++ {
+		files = append(files, rclone.ListItem{Path: fmt.Sprintf("f%d", i), Size: 50})
+	}
+	env.backend.SetFiles(full.ID, files)
+	return pool
+}
+
+func waitForRebalance(t *testing.T, s *RebalanceService, id string) *models.RebalanceJob {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		job, err := s.GetRebalance(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("rebalance %s did not finish", id)
+	return nil
+}
+
+func TestRebalanceBandwidthLimitIsExclusive(t *testing.T) {
+	env := newTestEnv(t)
+	s := NewRebalanceService(env.db, env.storage, env.accounts, env.backend)
+	first, second := unbalancedPool(t, env, "first"), unbalancedPool(t, env, "second")
+
+	job, err := s.PlanRebalance(first.ID, &models.RebalanceRequest{BandwidthLimit: "1M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StartRebalance(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job = waitForRebalance(t, s, job.ID); job.Status != "completed" {
+		t.Fatalf("status = %s (%s), want completed", job.Status, job.Error)
+	}
+	// The limit is restored just after the job is marked finished
+	for i := 0; i < 100 && env.backend.BandwidthLimit() != ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if limit := env.backend.BandwidthLimit(); limit != "" {
+		t.Errorf("bandwidth limit after the rebalance = %q, want it restored", limit)
+	}
+
+	// While a limited rebalance runs, another may only run unlimited
+	s.mu.Lock()
+	s.limited = job.ID
+	s.mu.Unlock()
+
+	limited, err := s.PlanRebalance(second.ID, &models.RebalanceRequest{BandwidthLimit: "2M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verr *ValidationError
+	if _, err := s.StartRebalance(limited.ID); !errors.As(err, &verr) {
+		t.Errorf("second limited rebalance: err = %v, want a validation error", err)
+	}
+	unlimited, err := s.PlanRebalance(second.ID, &models.RebalanceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StartRebalance(unlimited.ID); err != nil {
+		t.Errorf("unlimited rebalance: %v", err)
+	}
+	waitForRebalance(t, s, unlimited.ID)
+}
+
+func TestDeletePoolRebalances(t *testing.T) {
+	env := newTestEnv(t)
+	s := NewRebalanceService(env.db, env.storage, env.accounts, env.backend)
+	pool := unbalancedPool(t, env, "pool")
+	done, err := s.PlanRebalance(pool.ID, &models.RebalanceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StartRebalance(done.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitForRebalance(t, s, done.ID)
+	planned, err := s.PlanRebalance(pool.ID, &models.RebalanceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.storage.DeletePool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{done.ID, planned.ID} {
+		if _, err := s.GetRebalance(id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("rebalance %s of a deleted pool: err = %v, want no rows", id, err)
+		}
+	}
+}
+
+func TestValidBandwidthLimit(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  bool
+	}{
+		{"10M", true},
+		{"1.5G", true},
+		{"512Ki", true},
+		{"off", true},
+		{"10M:2M", true},
+		{"off:1M", true},
+		{"", false},
+		{"fast", false},
+		{"10M:", false},
+		{"10M:2M:1M", false},
+		{"08:00,512k", false},
+	}
+	for _, tt := range tests {
+		if got := validBandwidthLimit(tt.limit); got != tt.want {
+			t.Errorf("validBandwidthLimit(%q) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"pooled-storage/internal/models"
+	"time"
+)
+
+const (
+	reconcileInterval = time.Minute
+	// Drift events older than this are deleted.
+	driftEventRetention = 30 * 24 * time.Hour
+)
+
+// Reconciler brings pool state in the database in line with what is actually
+// mounted. Pool status is otherwise only changed by StartPool and StopPool,
+// so without it a restart leaves pools marked running with nothing mounted.
+type Reconciler struct {
+	db      *sql.DB
+	storage *StorageService
+}
+
+func NewReconciler(db *sql.DB, storage *StorageService) *Reconciler {
+	return &Reconciler{
+		db:      db,
+		storage: storage,
+	}
+}
+
+// Run reconciles immediately and then every reconcileInterval until ctx is
+// cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reconcile(); err != nil {
+			log.Printf("Reconcile failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile checks every pool against its mount and repairs the difference:
+//   - running pools that lost their mount are remounted when they are marked
+//     for auto-start and unmounted and marked stopped otherwise, unless the
+//     mount's supervisor is already restarting it;
+//   - pools that are mounted but not marked running are marked running;
+//   - union and chunker remotes of pools that aren't running are deleted, as
+//     are the mount directories of pools that no longer exist.
+//
+// It returns the drift found in this pass.
+func (r *Reconciler) Reconcile() ([]models.DriftEvent, error) {
+	s := r.storage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pools, err := s.GetPools()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []models.DriftEvent
+	record := func(poolID, kind, message, action string) {
+		event, err := r.recordDrift(poolID, kind, message, action)
+		if err != nil {
+			log.Printf("Failed to record drift event: %v", err)
+		}
+		events = append(events, *event)
+	}
+
+	known := make(map[string]bool, len(pools))
+	var running []string
+	for i := range pools {
+		pool := &pools[i]
+		known[pool.ID] = true
+
+		mounted := s.rclone.IsMounted(s.rclone.PoolMountPath(pool.ID))
+		wantsMount := pool.Status == "running" || pool.Status == "starting"
+
+		kind := "mount_lost"
+		if pool.Status == "starting" {
+			// Nothing else is starting it while we hold s.mu
+			kind = "stuck_starting"
+		}
+
+		switch {
+		case mounted && pool.Status == "running":
+			// Restarts serves that failed to come up with the mount
+			s.startServes(pool)
+			running = append(running, pool.ID)
+
+		case mounted:
+			if !wantsMount {
+				kind = "unexpected_mount"
+			}
+			message := fmt.Sprintf("pool %s is mounted but marked %s", pool.Name, pool.Status)
+			if err := s.rclone.CreateUnion(pool); err != nil {
+				record(pool.ID, kind, fmt.Sprintf("%s; failed to create union: %v", message, err), "failed")
+				continue
+			}
+			if err := s.markRunning(pool.ID); err != nil {
+				record(pool.ID, kind, fmt.Sprintf("%s; %v", message, err), "failed")
+				continue
+			}
+			s.startServes(pool)
+			record(pool.ID, kind, message, "marked_running")
+			running = append(running, pool.ID)
+
+		case wantsMount && s.rclone.MountRestarting(pool.ID):
+			// The supervisor brings it back; its union must stay
+			running = append(running, pool.ID)
+
+		case wantsMount && pool.AutoStart && len(pool.Accounts) > 0:
+			message := fmt.Sprintf("pool %s is marked %s but not mounted", pool.Name, pool.Status)
+			// Clears a stale FUSE mount left behind by a dead rclone
+			s.rclone.UnmountPool(pool)
+			if err := s.mountPool(pool); err != nil {
+				record(pool.ID, kind, fmt.Sprintf("%s; remount failed: %v", message, err), "failed")
+				continue
+			}
+			record(pool.ID, kind, message, "remounted")
+			running = append(running, pool.ID)
+
+		case wantsMount:
+			message := fmt.Sprintf("pool %s is marked %s but not mounted", pool.Name, pool.Status)
+			s.stopServes(pool)
+			// Stops a supervisor that would otherwise keep restarting rclone
+			// against the deleted union
+			s.rclone.UnmountPool(pool)
+			s.rclone.DeleteUnion(pool.ID)
+			if err := s.markStopped(pool.ID); err != nil {
+				record(pool.ID, kind, fmt.Sprintf("%s; %v", message, err), "failed")
+				continue
+			}
+			record(pool.ID, kind, message, "marked_stopped")
+		}
+	}
+
+	removed, err := s.rclone.PruneRemotes(running)
+	if err != nil {
+		log.Printf("Failed to prune rclone remotes: %v", err)
+	}
+	for _, name := range removed {
+		record("", "orphan_remote", fmt.Sprintf("remote %s belongs to no running pool", name), "removed")
+	}
+
+	dirs, err := s.rclone.MountDirs()
+	if err != nil {
+		log.Printf("Failed to list mount directories: %v", err)
+	}
+	for _, id := range dirs {
+		if known[id] {
+			continue
+		}
+		path := s.rclone.PoolMountPath(id)
+		message := fmt.Sprintf("mount directory %s belongs to no pool", path)
+		if s.rclone.IsMounted(path) {
+			if err := s.rclone.UnmountPool(&models.StoragePool{ID: id}); err != nil {
+				record(id, "orphan_mount_dir", fmt.Sprintf("%s; unmount failed: %v", message, err), "failed")
+				continue
+			}
+		}
+		if err := s.rclone.RemoveMountDir(id); err != nil {
+			record(id, "orphan_mount_dir", fmt.Sprintf("%s; %v", message, err), "failed")
+			continue
+		}
+		record(id, "orphan_mount_dir", message, "removed")
+	}
+
+	r.db.Exec("DELETE FROM drift_events WHERE created_at < ?", time.Now().Add(-driftEventRetention))
+
+	return events, nil
+}
+
+func (r *Reconciler) recordDrift(poolID, kind, message, action string) (*models.DriftEvent, error) {
+	log.Printf("Drift: %s: %s (%s)", kind, message, action)
+
+	event := &models.DriftEvent{
+		PoolID:    poolID,
+		Kind:      kind,
+		Message:   message,
+		Action:    action,
+		CreatedAt: time.Now(),
+	}
+	query := `INSERT INTO drift_events (pool_id, kind, message, action, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := r.db.Exec(query, poolID, kind, message, action, event.CreatedAt)
+	if err != nil {
+		return event, err
+	}
+	event.ID, _ = result.LastInsertId()
+	return event, nil
+}
+
+// ListDriftEvents returns the most recent drift events, newest first,
+// optionally only those of one pool.
+func (r *Reconciler) ListDriftEvents(poolID string, limit int) ([]models.DriftEvent, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	query := `SELECT id, pool_id, kind, message, action, created_at FROM drift_events`
+	args := []interface{}{}
+	if poolID != "" {
+		query += ` WHERE pool_id = ?`
+		args = append(args, poolID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.DriftEvent{}
+	for rows.Next() {
+		var event models.DriftEvent
+		var poolID sql.NullString
+		if err := rows.Scan(&event.ID, &poolID, &event.Kind, &event.Message, &event.Action, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.PoolID = poolID.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
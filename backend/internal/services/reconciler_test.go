@@ -0,0 +1,68 @@
+package services
+
+import (
+	"pooled-storage/internal/models"
+	"testing"
+)
+
+func TestReconcileLeavesRestartingMountsAlone(t *testing.T) {
+	env := newTestEnv(t)
+	pool := env.addPool(t, models.CreatePoolRequest{}, env.addAccount(t, "a"))
+	if err := env.storage.StartPool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// rclone mount died and its supervisor is backing off
+	env.backend.UnmountPool(pool)
+	env.backend.SetMountRestarting(pool.ID, true)
+
+	events, err := NewReconciler(env.db, env.storage).Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %+v, want none", events)
+	}
+	if status := env.poolStatus(t, pool.ID); status != "running" {
+		t.Errorf("status = %s, want running", status)
+	}
+	if _, ok := env.backend.Union(pool.ID); !ok {
+		t.Error("union of the restarting pool was deleted")
+	}
+}
+
+func TestReconcileLostMount(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoStart  bool
+		wantAction string
+		wantStatus string
+	}{
+		{"auto-start", true, "remounted", "running"},
+		{"no auto-start", false, "marked_stopped", "stopped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			pool := env.addPool(t, models.CreatePoolRequest{AutoStart: tt.autoStart}, env.addAccount(t, "a"))
+			if err := env.storage.StartPool(pool.ID); err != nil {
+				t.Fatal(err)
+			}
+			env.backend.UnmountPool(pool)
+
+			events, err := NewReconciler(env.db, env.storage).Reconcile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 || events[0].Kind != "mount_lost" || events[0].Action != tt.wantAction {
+				t.Fatalf("events = %+v, want one mount_lost %s", events, tt.wantAction)
+			}
+			if status := env.poolStatus(t, pool.ID); status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+			if _, ok := env.backend.Union(pool.ID); ok != tt.autoStart {
+				t.Errorf("union exists = %v, want %v", ok, tt.autoStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"pooled-storage/internal/secrets"
+)
+
+// sealedColumns lists every column whose values are sealed with the master
+// key. Anything added here is covered by key rotation.
+var sealedColumns = []struct {
+	table  string
+	key    string
+	column string
+}{
+	{"accounts", "id", "access_token"},
+	{"accounts", "id", "refresh_token"},
+	{"accounts", "id", "service_account"},
+	{"accounts", "id", "credentials"},
+	{"accounts", "id", "oauth_client_secret"},
+	{"oauth_configs", "provider", "client_secret"},
+	{"storage_pools", "id", "crypt_password"},
+	{"storage_pools", "id", "crypt_salt"},
+	{"pool_serves", "id", "password"},
+}
+
+// ResealSecrets re-encrypts all sealed columns from one master key to
+// another in a single transaction and returns how many values were written.
+// When from and to are the same sealer only plaintext values (stored before
+// encryption was enabled) are sealed.
+func ResealSecrets(db *sql.DB, from, to *secrets.Sealer) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, c := range sealedColumns {
+		query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s != ''",
+			c.key, c.column, c.table, c.column, c.column)
+		rows, err := tx.Query(query)
+		if err != nil {
+			return 0, err
+		}
+
+		values := map[string]string{}
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if from == to && secrets.IsSealed(value) {
+				continue
+			}
+			values[key] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", c.table, c.column, c.key)
+		for key, value := range values {
+			plaintext, err := from.Open(value)
+			if err != nil {
+				return 0, fmt.Errorf("%s.%s for %s: %w", c.table, c.column, key, err)
+			}
+			sealed, err := to.Seal(plaintext)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := tx.Exec(update, sealed, key); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
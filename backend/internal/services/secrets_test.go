@@ -0,0 +1,82 @@
+package services
+
+import (
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/secrets"
+	"testing"
+)
+
+func TestResealSecrets(t *testing.T) {
+	env := newTestEnv(t)
+	account := env.addAccount(t, "a")
+	oauth := NewOAuthService(env.db, env.sealer)
+	if _, err := oauth.SaveCredentials("google", &models.SaveOAuthConfigRequest{ClientID: "id", ClientSecret: "client-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func(query string, args ...interface{}) string {
+		t.Helper()
+		var value string
+		if err := env.db.QueryRow(query, args...).Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	accessToken := func() string {
+		return stored("SELECT access_token FROM accounts WHERE id = ?", account.ID)
+	}
+
+	if token := accessToken(); !secrets.IsSealed(token) {
+		t.Fatalf("access token stored as %q, want it sealed", token)
+	}
+
+	// Plaintext left over from before encryption is sealed in place
+	if _, err := env.db.Exec("UPDATE accounts SET refresh_token = 'legacy' WHERE id = ?", account.ID); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ResealSecrets(env.db, env.sealer, env.sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("sealing plaintext wrote %d values, want 1", n)
+	}
+	if token := stored("SELECT refresh_token FROM accounts WHERE id = ?", account.ID); !secrets.IsSealed(token) {
+		t.Errorf("legacy refresh token stored as %q after sealing", token)
+	}
+
+	// Rotation re-seals every value under the new key
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := secrets.NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ResealSecrets(env.db, env.sealer, rotated); err != nil || n != 3 {
+		t.Fatalf("rotation wrote %d values (%v), want 3", n, err)
+	}
+
+	if _, err := env.sealer.Open(accessToken()); err == nil {
+		t.Error("old key still opens the access token after rotation")
+	}
+	if token, err := rotated.Open(accessToken()); err != nil || token != "token-a" {
+		t.Errorf("access token under the new key = %q, %v, want %q", token, err, "token-a")
+	}
+	cfg, err := NewOAuthService(env.db, rotated).GetCredentials("google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientSecret != "client-secret" {
+		t.Errorf("client secret under the new key = %q, want %q", cfg.ClientSecret, "client-secret")
+	}
+
+	// A wrong old key leaves the database untouched
+	if _, err := ResealSecrets(env.db, env.sealer, rotated); err == nil {
+		t.Error("rotating from the wrong key succeeded")
+	}
+	if _, err := rotated.Open(accessToken()); err != nil {
+		t.Errorf("failed rotation changed the stored token: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ports serves get when none is asked for, the range docker-compose
+// publishes. SERVE_PORT_RANGE (e.g. 30000-30099) overrides it.
+const (
+	defaultServePortMin = 20060
+	defaultServePortMax = 20100
+)
+
+// ServeService manages the network endpoints of pools. Serves run while
+// their pool is mounted; StorageService starts and stops them with the
+// mount.
+type ServeService struct {
+	db      *sql.DB
+	storage *StorageService
+	rclone  rclone.StorageBackend
+
+	portMin, portMax int
+}
+
+func NewServeService(db *sql.DB, storage *StorageService, rclone rclone.StorageBackend) *ServeService {
+	s := &ServeService{
+		db:      db,
+		storage: storage,
+		rclone:  rclone,
+		portMin: defaultServePortMin,
+		portMax: defaultServePortMax,
+	}
+	if r := os.Getenv("SERVE_PORT_RANGE"); r != "" {
+		min, max, err := parsePortRange(r)
+		if err != nil {
+			log.Printf("Ignoring SERVE_PORT_RANGE: %v", err)
+		} else {
+			s.portMin, s.portMax = min, max
+		}
+	}
+	return s
+}
+
+func parsePortRange(r string) (int, int, error) {
+	from, to, ok := strings.Cut(r, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not a range like 20060-20100", r)
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is not a range like 20060-20100", r)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is not a range like 20060-20100", r)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("%q is not a range of ports", r)
+	}
+	return min, max, nil
+}
+
+// CreateServe adds a serve to a pool and, if the pool is running, starts
+// it. A serve that can't start is not kept.
+func (s *ServeService) CreateServe(poolID string, req *models.CreateServeRequest) (*models.PoolServe, error) {
+	st := s.storage
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	pool, err := st.GetPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	serve := &models.PoolServe{
+		ID:          uuid.New().String(),
+		PoolID:      poolID,
+		Protocol:    strings.ToLower(req.Protocol),
+		BindAddress: req.BindAddress,
+		Port:        req.Port,
+		Username:    req.Username,
+		Password:    req.Password,
+		ReadOnly:    req.ReadOnly,
+	}
+	if err := validateServe(serve); err != nil {
+		return nil, err
+	}
+	if serve.Port == 0 {
+		if serve.Port, err = s.allocatePort(serve.BindAddress); err != nil {
+			return nil, err
+		}
+	} else if err := s.checkPort(serve.BindAddress, serve.Port); err != nil {
+		return nil, err
+	}
+
+	password, err := st.secrets.Seal(serve.Password)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	serve.CreatedAt, serve.UpdatedAt = now, now
+	query := `INSERT INTO pool_serves (id, pool_id, protocol, bind_address, port, username, password, read_only, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.Exec(query, serve.ID, serve.PoolID, serve.Protocol, serve.BindAddress, serve.Port,
+		serve.Username, password, serve.ReadOnly, serve.CreatedAt, serve.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	serve.Status = "stopped"
+	if pool.Status == "running" {
+		if err := s.rclone.StartServe(pool, serve); err != nil {
+			s.db.Exec("DELETE FROM pool_serves WHERE id = ?", serve.ID)
+			return nil, err
+		}
+		serve.Status = "running"
+	}
+	serve.URL = serveURL(serve)
+	return serve, nil
+}
+
+func validateServe(serve *models.PoolServe) error {
+	known := false
+	for _, p := range rclone.ServeProtocols {
+		known = known || p == serve.Protocol
+	}
+	if !known {
+		return validationErrorf("unknown protocol %q, expected one of %s", serve.Protocol, strings.Join(rclone.ServeProtocols, ", "))
+	}
+	if serve.BindAddress != "" && net.ParseIP(serve.BindAddress) == nil {
+		return validationErrorf("bind address must be an IP address, not %q", serve.BindAddress)
+	}
+	if serve.Port < 0 || serve.Port > 65535 {
+		return validationErrorf("port must be between 1 and 65535")
+	}
+	if (serve.Username == "") != (serve.Password == "") {
+		return validationErrorf("username and password must be given together")
+	}
+	if strings.Contains(serve.Username, ",") && serve.Protocol == "s3" {
+		return validationErrorf("an s3 access key can't contain a comma")
+	}
+	return nil
+}
+
+// allocatePort returns the first port of the serve range that no serve has
+// and nothing else listens on.
+func (s *ServeService) allocatePort(bindAddress string) (int, error) {
+	used, err := s.usedPorts()
+	if err != nil {
+		return 0, err
+	}
+	for port := s.portMin; port <= s.portMax; port++ {
+		if !used[port] && portFree(bindAddress, port) {
+			return port, nil
+		}
+	}
+	return 0, validationErrorf("no free port between %d and %d", s.portMin, s.portMax)
+}
+
+// checkPort refuses a port another serve has or something else listens on.
+func (s *ServeService) checkPort(bindAddress string, port int) error {
+	var poolID string
+	err := s.db.QueryRow("SELECT pool_id FROM pool_serves WHERE port = ?", port).Scan(&poolID)
+	if err == nil {
+		return validationErrorf("port %d is already used by a serve of pool %s", port, poolID)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	if !portFree(bindAddress, port) {
+		return validationErrorf("port %d is already in use on this host", port)
+	}
+	return nil
+}
+
+func (s *ServeService) usedPorts() (map[int]bool, error) {
+	rows, err := s.db.Query("SELECT port FROM pool_serves")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := map[int]bool{}
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, err
+		}
+		used[port] = true
+	}
+	return used, rows.Err()
+}
+
+// portFree reports whether we could listen on the port.
+func portFree(bindAddress string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// serveURL is where clients connect to a serve. Serves on all interfaces
+// are reached through HOST_IP, like the OAuth callback.
+func serveURL(serve *models.PoolServe) string {
+	host := serve.BindAddress
+	switch host {
+	case "", "0.0.0.0", "::":
+		if host = os.Getenv("HOST_IP"); host == "" {
+			host = "localhost"
+		}
+	}
+
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(serve.Port))}
+	switch serve.Protocol {
+	case "sftp", "ftp":
+		u.Scheme = serve.Protocol
+		if serve.Username != "" {
+			u.User = url.User(serve.Username)
+		}
+	}
+	return u.String()
+}
+
+func (s *ServeService) ListServes(poolID string) ([]models.PoolServe, error) {
+	if _, err := s.storage.GetPool(poolID); err != nil {
+		return nil, err
+	}
+	return s.storage.GetPoolServes(poolID)
+}
+
+func (s *ServeService) GetServe(id string) (*models.PoolServe, error) {
+	rows, err := s.db.Query(poolServeQuery+` WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	serves, err := s.storage.scanServes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(serves) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &serves[0], nil
+}
+
+// DeleteServe stops a serve and removes it from its pool.
+func (s *ServeService) DeleteServe(id string) error {
+	s.storage.mu.Lock()
+	defer s.storage.mu.Unlock()
+
+	if _, err := s.GetServe(id); err != nil {
+		return err
+	}
+	if err := s.rclone.StopServe(id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM pool_serves WHERE id = ?", id)
+	return err
+}
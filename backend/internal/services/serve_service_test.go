@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"pooled-storage/internal/models"
+	"strings"
+	"testing"
+)
+
+// freePort returns a port nothing listens on right now.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestServePorts(t *testing.T) {
+	env := newTestEnv(t)
+	pool := env.addPool(t, models.CreatePoolRequest{}, env.addAccount(t, "a"))
+	s := NewServeService(env.db, env.storage, env.backend)
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	taken := freePort(t)
+	if _, err := s.CreateServe(pool.ID, &models.CreateServeRequest{Protocol: "webdav", BindAddress: "127.0.0.1", Port: taken}); err != nil {
+		t.Fatal(err)
+	}
+
+	validation := func(err error, want string) {
+		t.Helper()
+		var verr *ValidationError
+		if !errors.As(err, &verr) || !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want a validation error about %q", err, want)
+		}
+	}
+	validation(s.checkPort("127.0.0.1", busyPort), "in use on this host")
+	validation(s.checkPort("127.0.0.1", taken), "used by a serve of pool "+pool.ID)
+	_, err = s.CreateServe(pool.ID, &models.CreateServeRequest{Protocol: "sftp", BindAddress: "127.0.0.1", Port: taken})
+	validation(err, "used by a serve")
+
+	// Allocation skips ports of other serves and of other programs
+	s.portMin, s.portMax = busyPort, busyPort
+	_, err = s.allocatePort("127.0.0.1")
+	validation(err, "no free port")
+	s.portMin, s.portMax = taken, taken
+	_, err = s.allocatePort("127.0.0.1")
+	validation(err, "no free port")
+
+	free := freePort(t)
+	s.portMin, s.portMax = free, free
+	serve, err := s.CreateServe(pool.ID, &models.CreateServeRequest{Protocol: "ftp", BindAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serve.Port != free {
+		t.Errorf("allocated port %d, want %d", serve.Port, free)
+	}
+}
+
+func TestServeURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		hostIP string
+		serve  models.PoolServe
+		want   string
+	}{
+		{"all interfaces", "10.0.0.5", models.PoolServe{Protocol: "webdav", Port: 20060}, "http://10.0.0.5:20060"},
+		{"no host ip", "", models.PoolServe{Protocol: "http", BindAddress: "0.0.0.0", Port: 20061}, "http://localhost:20061"},
+		{"sftp user", "10.0.0.5", models.PoolServe{Protocol: "sftp", BindAddress: "127.0.0.1", Port: 2022, Username: "ann"},
+			"sftp://ann@127.0.0.1:2022"},
+		{"ftp on ipv6", "", models.PoolServe{Protocol: "ftp", BindAddress: "::1", Port: 2121}, "ftp://[::1]:2121"},
+		{"s3 key stays out", "10.0.0.5", models.PoolServe{Protocol: "s3", Port: 20062, Username: "AKID"}, "http://10.0.0.5:20062"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOST_IP", tt.hostIP)
+			if got := serveURL(&tt.serve); got != tt.want {
+				t.Errorf("serveURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"pooled-storage/internal/database"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"pooled-storage/internal/secrets"
+	"testing"
+)
+
+// testEnv is a set of services on a fresh database and an in-memory rclone
+// backend.
+type testEnv struct {
+	db       *sql.DB
+	backend  *rclone.MemoryBackend
+	sealer   *secrets.Sealer
+	accounts *AccountService
+	storage  *StorageService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "pooled-storage.db"))
+	db, err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := secrets.NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := rclone.NewMemoryBackend()
+	return &testEnv{
+		db:       db,
+		backend:  backend,
+		sealer:   sealer,
+		accounts: NewAccountService(db, backend, sealer),
+		storage:  NewStorageService(db, backend, sealer),
+	}
+}
+
+// addAccount registers a Google account; name keeps accounts apart.
+func (e *testEnv) addAccount(t *testing.T, name string) *models.Account {
+	t.Helper()
+	account, err := e.accounts.CreateAccount(&models.CreateAccountRequest{
+		Name:  name,
+		Type:  "google",
+		Email: name + "@example.com",
+		Token: "token-" + name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// addPool creates a pool of the given accounts.
+func (e *testEnv) addPool(t *testing.T, req models.CreatePoolRequest, accounts ...*models.Account) *models.StoragePool {
+	t.Helper()
+	if req.Name == "" {
+		req.Name = "pool"
+	}
+	for _, account := range accounts {
+		req.AccountIDs = append(req.AccountIDs, account.ID)
+	}
+	pool, err := e.storage.CreatePool(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func (e *testEnv) poolStatus(t *testing.T, id string) string {
+	t.Helper()
+	pool, err := e.storage.GetPool(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool.Status
+}
+
+// poolRows counts the rows of a table that belong to a pool.
+func (e *testEnv) poolRows(t *testing.T, table, poolID string) int {
+	t.Helper()
+	var n int
+	if err := e.db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE pool_id = ?", poolID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -1,20 +1,27 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"pooled-storage/internal/models"
 	"pooled-storage/internal/rclone"
+	"time"
 )
 
+// Longest a pool size measurement may walk the pool for.
+const poolSizeTimeout = 10 * time.Minute
+
 type StatsService struct {
-	db     *sql.DB
-	rclone *rclone.Manager
+	db      *sql.DB
+	rclone  rclone.StorageBackend
+	storage *StorageService
 }
 
-func NewStatsService(db *sql.DB, rclone *rclone.Manager) *StatsService {
+func NewStatsService(db *sql.DB, rclone rclone.StorageBackend, storage *StorageService) *StatsService {
 	return &StatsService{
-		db:     db,
-		rclone: rclone,
+		db:      db,
+		rclone:  rclone,
+		storage: storage,
 	}
 }
 
@@ -47,7 +54,7 @@ func (s *StatsService) GetStorageStats() (*models.StorageStats, error) {
 }
 
 func (s *StatsService) GetAccountStats() ([]models.AccountStats, error) {
-	query := `SELECT id, name, email, type, quota_total, quota_used, status
+	query := `SELECT id, name, email, type, COALESCE(shared_drive_id, ''), quota_total, quota_used, status
 			  FROM accounts
 			  ORDER BY name`
 	
@@ -60,7 +67,7 @@ func (s *StatsService) GetAccountStats() ([]models.AccountStats, error) {
 	var stats []models.AccountStats
 	for rows.Next() {
 		var as models.AccountStats
-		err := rows.Scan(&as.AccountID, &as.Name, &as.Email, &as.Type,
+		err := rows.Scan(&as.AccountID, &as.Name, &as.Email, &as.Type, &as.SharedDriveID,
 			&as.QuotaTotal, &as.QuotaUsed, &as.Status)
 		if err != nil {
 			continue
@@ -125,28 +132,69 @@ func (s *StatsService) GetPoolStats() ([]models.PoolStats, error) {
 	return stats, nil
 }
 
+// GetPoolSize measures how much a running pool holds and how much its
+// members store for it, which shows what compression saves and what
+// encryption or mirroring costs. It lists every file of the pool, so it is
+// only done on request.
+func (s *StatsService) GetPoolSize(poolID string) (*models.PoolSize, error) {
+	pool, err := s.storage.GetPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+	if pool.Status != "running" {
+		return nil, validationErrorf("pool must be running to measure its size")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), poolSizeTimeout)
+	defer cancel()
+	size, err := s.rclone.PoolSize(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.PoolSize{
+		PoolID:       pool.ID,
+		Files:        size.Files,
+		LogicalBytes: size.Logical,
+		StoredBytes:  size.Stored,
+		MeasuredAt:   time.Now(),
+	}
+	if size.Logical > 0 {
+		result.Ratio = float64(size.Stored) / float64(size.Logical)
+	}
+	return result, nil
+}
+
 func (s *StatsService) RefreshAllQuotas() error {
-	query := `SELECT id, type FROM accounts WHERE status = 'active'`
+	query := `SELECT id, type, COALESCE(shared_drive_id, ''), capacity FROM accounts WHERE status = 'active'`
 	rows, err := s.db.Query(query)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
+	var accounts []models.Account
 	for rows.Next() {
-		var id, accountType string
-		if err := rows.Scan(&id, &accountType); err != nil {
+		var account models.Account
+		if err := rows.Scan(&account.ID, &account.Type, &account.SharedDriveID, &account.Capacity); err != nil {
 			continue
 		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
 
-		total, used, err := s.rclone.GetQuota(id, accountType)
+	for i := range accounts {
+		total, used, err := fetchQuota(s.rclone, &accounts[i])
 		if err != nil {
 			continue
 		}
 
 		updateQuery := `UPDATE accounts SET quota_total = ?, quota_used = ? WHERE id = ?`
-		s.db.Exec(updateQuery, total, used, id)
+		s.db.Exec(updateQuery, total, used, accounts[i].ID)
 	}
 
 	return nil
 }
+
+func (s *StatsService) GetTransferStats() (*rclone.Stats, error) {
+	return s.rclone.Stats()
+}
@@ -1,43 +1,108 @@
 package services
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log"
 	"pooled-storage/internal/models"
 	"pooled-storage/internal/rclone"
+	"pooled-storage/internal/secrets"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type StorageService struct {
-	db     *sql.DB
-	rclone *rclone.Manager
+	db      *sql.DB
+	rclone  rclone.StorageBackend
+	secrets *secrets.Sealer
+
+	// mu serializes pool state changes between API calls and the reconciler
+	mu sync.Mutex
 }
 
-func NewStorageService(db *sql.DB, rclone *rclone.Manager) *StorageService {
+func NewStorageService(db *sql.DB, rclone rclone.StorageBackend, sealer *secrets.Sealer) *StorageService {
 	return &StorageService{
-		db:     db,
-		rclone: rclone,
+		db:      db,
+		rclone:  rclone,
+		secrets: sealer,
 	}
 }
 
 func (s *StorageService) CreatePool(req *models.CreatePoolRequest) (*models.StoragePool, error) {
 	pool := &models.StoragePool{
-		ID:              uuid.New().String(),
-		Name:            req.Name,
-		Strategy:        req.Strategy,
-		EnableChunker:   req.EnableChunker,
-		AllowLargeFiles: req.AllowLargeFiles,
-		ChunkSize:       req.ChunkSize,
-		Status:          "stopped",
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
+		ID:                uuid.New().String(),
+		Name:              req.Name,
+		EnableChunker:     req.EnableChunker,
+		AllowLargeFiles:   req.AllowLargeFiles,
+		ChunkSize:         req.ChunkSize,
+		ChunkerMode:       req.ChunkerMode,
+		ChunkerHashType:   req.ChunkerHashType,
+		ChunkerNameFormat: req.ChunkerNameFormat,
+		ChunkerFailHard:   req.ChunkerFailHard,
+		Status:            "stopped",
+		AutoStart:         req.AutoStart,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
 	}
 
 	if pool.ChunkSize == "" {
 		pool.ChunkSize = "100M"
 	}
+	if pool.ChunkerMode == "" {
+		pool.ChunkerMode = "upstream"
+	}
+	if pool.ChunkerHashType == "" {
+		pool.ChunkerHashType = "md5"
+	}
+	if pool.ChunkerNameFormat == "" {
+		pool.ChunkerNameFormat = defaultChunkNameFormat
+	}
+	if err := validateChunker(pool); err != nil {
+		return nil, err
+	}
+	strategy := req.Strategy
+	if strategy == "" {
+		strategy = "union"
+	}
+	if err := resolvePolicies(pool, strategy, req.ActionPolicy, req.CreatePolicy, req.SearchPolicy); err != nil {
+		return nil, err
+	}
+	cacheTime, err := resolveCacheTime(req.CacheTime)
+	if err != nil {
+		return nil, err
+	}
+	pool.CacheTime = cacheTime
+	if err := setEncryption(pool, req.Encryption, req.FilenameEncryption); err != nil {
+		return nil, err
+	}
+	if err := setCompression(pool, req.EnableCompression, req.CompressionLevel, req.CompressionRAMCacheLimit); err != nil {
+		return nil, err
+	}
+	pool.MountProfile = req.MountProfile
+	if pool.MountProfile == "" {
+		pool.MountProfile = "default"
+	}
+	pool.MountOptions = req.MountOptions
+	if err := validateMount(pool); err != nil {
+		return nil, err
+	}
+	mountOptions, err := encodeMountOptions(pool.MountOptions)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.sealAll(pool.CryptPassword, pool.CryptSalt)
+	if err != nil {
+		return nil, err
+	}
 
 	// Start transaction
 	tx, err := s.db.Begin()
@@ -47,10 +112,17 @@ func (s *StorageService) CreatePool(req *models.CreatePoolRequest) (*models.Stor
 	defer tx.Rollback()
 
 	// Insert pool
-	query := `INSERT INTO storage_pools (id, name, strategy, enable_chunker, allow_large_files, chunk_size, status, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = tx.Exec(query, pool.ID, pool.Name, pool.Strategy, pool.EnableChunker,
-		pool.AllowLargeFiles, pool.ChunkSize, pool.Status, pool.CreatedAt, pool.UpdatedAt)
+	query := `INSERT INTO storage_pools (id, name, strategy, action_policy, create_policy, search_policy, cache_time,
+			  encryption, filename_encryption, crypt_password, crypt_salt,
+			  enable_compression, compression_level, compression_ram_cache_limit,
+			  enable_chunker, allow_large_files, chunk_size, chunker_mode, chunker_hash_type, chunker_name_format,
+			  chunker_fail_hard, status, auto_start, mount_profile, mount_options, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, pool.ID, pool.Name, pool.Strategy, pool.ActionPolicy, pool.CreatePolicy,
+		pool.SearchPolicy, pool.CacheTime, pool.Encryption, pool.FilenameEncryption, sealed[0], sealed[1],
+		pool.EnableCompression, pool.CompressionLevel, pool.CompressionRAMCacheLimit,
+		pool.EnableChunker, pool.AllowLargeFiles, pool.ChunkSize, pool.ChunkerMode, pool.ChunkerHashType, pool.ChunkerNameFormat,
+		pool.ChunkerFailHard, pool.Status, pool.AutoStart, pool.MountProfile, mountOptions, pool.CreatedAt, pool.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -72,9 +144,16 @@ func (s *StorageService) CreatePool(req *models.CreatePoolRequest) (*models.Stor
 }
 
 func (s *StorageService) GetPools() ([]models.StoragePool, error) {
-	query := `SELECT id, name, strategy, enable_chunker, allow_large_files, chunk_size, mount_path, status, created_at, updated_at
+	query := `SELECT id, name, strategy, COALESCE(action_policy, ''), COALESCE(create_policy, ''), COALESCE(search_policy, ''),
+			  COALESCE(cache_time, 0), COALESCE(encryption, ''), COALESCE(filename_encryption, ''),
+			  COALESCE(crypt_password, ''), COALESCE(crypt_salt, ''), COALESCE(enable_compression, 0),
+			  COALESCE(compression_level, -1), COALESCE(compression_ram_cache_limit, '20M'), enable_chunker, allow_large_files, chunk_size,
+			  COALESCE(chunker_mode, 'upstream'), COALESCE(chunker_hash_type, 'md5'),
+			  COALESCE(chunker_name_format, '*.rclone_chunk.###'), COALESCE(chunker_fail_hard, 0),
+			  mount_path, status, auto_start, COALESCE(mount_profile, 'default'), COALESCE(mount_options, ''),
+			  created_at, updated_at
 			  FROM storage_pools ORDER BY created_at DESC`
-	
+
 	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -85,19 +164,34 @@ func (s *StorageService) GetPools() ([]models.StoragePool, error) {
 	for rows.Next() {
 		var pool models.StoragePool
 		var mountPath sql.NullString
-		err := rows.Scan(&pool.ID, &pool.Name, &pool.Strategy, &pool.EnableChunker,
-			&pool.AllowLargeFiles, &pool.ChunkSize, &mountPath, &pool.Status,
-			&pool.CreatedAt, &pool.UpdatedAt)
+		var mountOptions string
+		err := rows.Scan(&pool.ID, &pool.Name, &pool.Strategy, &pool.ActionPolicy, &pool.CreatePolicy,
+			&pool.SearchPolicy, &pool.CacheTime, &pool.Encryption, &pool.FilenameEncryption,
+			&pool.CryptPassword, &pool.CryptSalt, &pool.EnableCompression, &pool.CompressionLevel,
+			&pool.CompressionRAMCacheLimit, &pool.EnableChunker,
+			&pool.AllowLargeFiles, &pool.ChunkSize, &pool.ChunkerMode, &pool.ChunkerHashType,
+			&pool.ChunkerNameFormat, &pool.ChunkerFailHard, &mountPath, &pool.Status,
+			&pool.AutoStart, &pool.MountProfile, &mountOptions, &pool.CreatedAt, &pool.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		if err := decodeMountOptions(mountOptions, &pool.MountOptions); err != nil {
+			return nil, err
+		}
+		rclone.FillUnionPolicies(&pool)
+		if err := s.openKeys(&pool); err != nil {
+			return nil, err
+		}
 		if mountPath.Valid {
 			pool.MountPath = mountPath.String
 		}
-		
+
 		// Get accounts for this pool
 		accounts, _ := s.GetPoolAccounts(pool.ID)
 		pool.Accounts = accounts
+		if pool.Serves, err = s.GetPoolServes(pool.ID); err != nil {
+			return nil, err
+		}
 
 		pools = append(pools, pool)
 	}
@@ -106,17 +200,36 @@ func (s *StorageService) GetPools() ([]models.StoragePool, error) {
 }
 
 func (s *StorageService) GetPool(id string) (*models.StoragePool, error) {
-	query := `SELECT id, name, strategy, enable_chunker, allow_large_files, chunk_size, mount_path, status, created_at, updated_at
+	query := `SELECT id, name, strategy, COALESCE(action_policy, ''), COALESCE(create_policy, ''), COALESCE(search_policy, ''),
+			  COALESCE(cache_time, 0), COALESCE(encryption, ''), COALESCE(filename_encryption, ''),
+			  COALESCE(crypt_password, ''), COALESCE(crypt_salt, ''), COALESCE(enable_compression, 0),
+			  COALESCE(compression_level, -1), COALESCE(compression_ram_cache_limit, '20M'), enable_chunker, allow_large_files, chunk_size,
+			  COALESCE(chunker_mode, 'upstream'), COALESCE(chunker_hash_type, 'md5'),
+			  COALESCE(chunker_name_format, '*.rclone_chunk.###'), COALESCE(chunker_fail_hard, 0),
+			  mount_path, status, auto_start, COALESCE(mount_profile, 'default'), COALESCE(mount_options, ''),
+			  created_at, updated_at
 			  FROM storage_pools WHERE id = ?`
-	
+
 	var pool models.StoragePool
 	var mountPath sql.NullString
+	var mountOptions string
 	err := s.db.QueryRow(query, id).Scan(&pool.ID, &pool.Name, &pool.Strategy,
-		&pool.EnableChunker, &pool.AllowLargeFiles, &pool.ChunkSize, &mountPath,
-		&pool.Status, &pool.CreatedAt, &pool.UpdatedAt)
+		&pool.ActionPolicy, &pool.CreatePolicy, &pool.SearchPolicy, &pool.CacheTime,
+		&pool.Encryption, &pool.FilenameEncryption, &pool.CryptPassword, &pool.CryptSalt,
+		&pool.EnableCompression, &pool.CompressionLevel, &pool.CompressionRAMCacheLimit,
+		&pool.EnableChunker, &pool.AllowLargeFiles, &pool.ChunkSize, &pool.ChunkerMode, &pool.ChunkerHashType,
+		&pool.ChunkerNameFormat, &pool.ChunkerFailHard, &mountPath,
+		&pool.Status, &pool.AutoStart, &pool.MountProfile, &mountOptions, &pool.CreatedAt, &pool.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	if err := decodeMountOptions(mountOptions, &pool.MountOptions); err != nil {
+		return nil, err
+	}
+	rclone.FillUnionPolicies(&pool)
+	if err := s.openKeys(&pool); err != nil {
+		return nil, err
+	}
 
 	if mountPath.Valid {
 		pool.MountPath = mountPath.String
@@ -125,17 +238,21 @@ func (s *StorageService) GetPool(id string) (*models.StoragePool, error) {
 	// Get accounts for this pool
 	accounts, _ := s.GetPoolAccounts(pool.ID)
 	pool.Accounts = accounts
+	if pool.Serves, err = s.GetPoolServes(pool.ID); err != nil {
+		return nil, err
+	}
 
 	return &pool, nil
 }
 
 func (s *StorageService) GetPoolAccounts(poolID string) ([]models.Account, error) {
-	query := `SELECT a.id, a.name, a.type, a.email, a.quota_total, a.quota_used, a.status, a.created_at, a.updated_at
+	query := `SELECT a.id, a.name, a.type, a.email, COALESCE(a.shared_drive_id, ''), COALESCE(a.capacity, 0),
+			  a.quota_total, a.quota_used, a.status, a.created_at, a.updated_at, COALESCE(pa.mode, 'rw')
 			  FROM accounts a
 			  INNER JOIN pool_accounts pa ON a.id = pa.account_id
 			  WHERE pa.pool_id = ?
 			  ORDER BY pa.priority`
-	
+
 	rows, err := s.db.Query(query, poolID)
 	if err != nil {
 		return nil, err
@@ -145,9 +262,9 @@ func (s *StorageService) GetPoolAccounts(poolID string) ([]models.Account, error
 	var accounts []models.Account
 	for rows.Next() {
 		var account models.Account
-		err := rows.Scan(&account.ID, &account.Name, &account.Type, &account.Email,
+		err := rows.Scan(&account.ID, &account.Name, &account.Type, &account.Email, &account.SharedDriveID, &account.Capacity,
 			&account.QuotaTotal, &account.QuotaUsed, &account.Status,
-			&account.CreatedAt, &account.UpdatedAt)
+			&account.CreatedAt, &account.UpdatedAt, &account.PoolMode)
 		if err != nil {
 			return nil, err
 		}
@@ -157,7 +274,80 @@ func (s *StorageService) GetPoolAccounts(poolID string) ([]models.Account, error
 	return accounts, nil
 }
 
+const poolServeQuery = `SELECT id, pool_id, protocol, bind_address, port, COALESCE(username, ''),
+	COALESCE(password, ''), read_only, COALESCE(error, ''), created_at, updated_at FROM pool_serves`
+
+// GetPoolServes returns the serves of a pool with their passwords opened,
+// ordered by port.
+func (s *StorageService) GetPoolServes(poolID string) ([]models.PoolServe, error) {
+	rows, err := s.db.Query(poolServeQuery+` WHERE pool_id = ? ORDER BY port`, poolID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanServes(rows)
+}
+
+func (s *StorageService) scanServes(rows *sql.Rows) ([]models.PoolServe, error) {
+	defer rows.Close()
+
+	serves := []models.PoolServe{}
+	for rows.Next() {
+		var serve models.PoolServe
+		err := rows.Scan(&serve.ID, &serve.PoolID, &serve.Protocol, &serve.BindAddress, &serve.Port,
+			&serve.Username, &serve.Password, &serve.ReadOnly, &serve.Error, &serve.CreatedAt, &serve.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if serve.Password, err = s.secrets.Open(serve.Password); err != nil {
+			return nil, err
+		}
+		switch {
+		case s.rclone.ServeRunning(serve.ID):
+			serve.Status = "running"
+		case serve.Error != "":
+			serve.Status = "error"
+		default:
+			serve.Status = "stopped"
+		}
+		serve.URL = serveURL(&serve)
+		serves = append(serves, serve)
+	}
+	return serves, rows.Err()
+}
+
+// startServes starts the serves of a mounted pool that aren't running. A
+// serve that fails is logged and its error kept; the pool stays up. Must be
+// called with s.mu held.
+func (s *StorageService) startServes(pool *models.StoragePool) {
+	for i := range pool.Serves {
+		serve := &pool.Serves[i]
+		if s.rclone.ServeRunning(serve.ID) {
+			continue
+		}
+		var message string
+		if err := s.rclone.StartServe(pool, serve); err != nil {
+			log.Printf("Failed to serve pool %s over %s on port %d: %v", pool.ID, serve.Protocol, serve.Port, err)
+			message = err.Error()
+		}
+		if message != serve.Error {
+			s.db.Exec("UPDATE pool_serves SET error = ?, updated_at = ? WHERE id = ?", message, time.Now(), serve.ID)
+		}
+	}
+}
+
+// stopServes must be called with s.mu held.
+func (s *StorageService) stopServes(pool *models.StoragePool) {
+	for _, serve := range pool.Serves {
+		if err := s.rclone.StopServe(serve.ID); err != nil {
+			log.Printf("Failed to stop serving pool %s on port %d: %v", pool.ID, serve.Port, err)
+		}
+	}
+}
+
 func (s *StorageService) StartPool(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	pool, err := s.GetPool(id)
 	if err != nil {
 		return err
@@ -167,30 +357,49 @@ func (s *StorageService) StartPool(id string) error {
 		return fmt.Errorf("pool already running")
 	}
 
+	return s.mountPool(pool)
+}
+
+// mountPool creates the union of a pool, mounts it and starts its serves.
+// Must be called with s.mu held.
+func (s *StorageService) mountPool(pool *models.StoragePool) error {
 	// Update status to starting
-	s.updatePoolStatus(id, "starting")
+	s.updatePoolStatus(pool.ID, "starting")
 
 	// Create union
 	if err := s.rclone.CreateUnion(pool); err != nil {
-		s.updatePoolStatus(id, "error")
+		s.updatePoolStatus(pool.ID, "error")
 		return fmt.Errorf("failed to create union: %w", err)
 	}
 
 	// Mount pool
 	if err := s.rclone.MountPool(pool); err != nil {
-		s.updatePoolStatus(id, "error")
+		s.updatePoolStatus(pool.ID, "error")
 		return fmt.Errorf("failed to mount pool: %w", err)
 	}
 
-	// Update status and mount path
-	mountPath := fmt.Sprintf("/mnt/pooled-storage/%s", pool.ID)
-	query := `UPDATE storage_pools SET status = ?, mount_path = ?, updated_at = ? WHERE id = ?`
-	_, err = s.db.Exec(query, "running", mountPath, time.Now(), id)
+	if err := s.markRunning(pool.ID); err != nil {
+		return err
+	}
+	s.startServes(pool)
+	return nil
+}
 
+func (s *StorageService) markRunning(id string) error {
+	mountPath := s.rclone.PoolMountPath(id)
+	query := `UPDATE storage_pools SET status = ?, mount_path = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, "running", mountPath, time.Now(), id)
 	return err
 }
 
 func (s *StorageService) StopPool(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopPool(id)
+}
+
+// stopPool must be called with s.mu held.
+func (s *StorageService) stopPool(id string) error {
 	pool, err := s.GetPool(id)
 	if err != nil {
 		return err
@@ -199,6 +408,11 @@ func (s *StorageService) StopPool(id string) error {
 	if pool.Status != "running" {
 		return fmt.Errorf("pool not running")
 	}
+	if err := s.refuseWhileMoving(id, ""); err != nil {
+		return err
+	}
+
+	s.stopServes(pool)
 
 	// Unmount
 	if err := s.rclone.UnmountPool(pool); err != nil {
@@ -208,39 +422,938 @@ func (s *StorageService) StopPool(id string) error {
 	// Delete union
 	s.rclone.DeleteUnion(pool.ID)
 
-	// Update status
-	query := `UPDATE storage_pools SET status = ?, mount_path = NULL, updated_at = ? WHERE id = ?`
-	_, err = s.db.Exec(query, "stopped", time.Now(), id)
+	return s.markStopped(id)
+}
 
+func (s *StorageService) markStopped(id string) error {
+	query := `UPDATE storage_pools SET status = ?, mount_path = NULL, updated_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, "stopped", time.Now(), id)
 	return err
 }
 
 func (s *StorageService) DeletePool(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	pool, err := s.GetPool(id)
 	if err != nil {
 		return err
 	}
+	if err := s.refuseWhileMoving(id, ""); err != nil {
+		return err
+	}
 
 	if pool.Status == "running" {
-		if err := s.StopPool(id); err != nil {
+		if err := s.stopPool(id); err != nil {
 			return err
 		}
 	}
 
+	// Foreign keys are off, so nothing cascades
+	for _, table := range []string{"pool_serves", "pool_accounts", "drain_jobs", "rebalance_jobs"} {
+		if _, err := s.db.Exec("DELETE FROM "+table+" WHERE pool_id = ?", id); err != nil {
+			return err
+		}
+	}
 	_, err = s.db.Exec("DELETE FROM storage_pools WHERE id = ?", id)
 	return err
 }
 
+// Seconds a union caches its members' quotas, rclone's default.
+const defaultCacheTime = 120
+
+// resolveCacheTime checks a cache time given for a pool; 0 means the
+// default.
+func resolveCacheTime(seconds int) (int, error) {
+	switch {
+	case seconds < 0:
+		return 0, validationErrorf("cache time must not be negative")
+	case seconds == 0:
+		return defaultCacheTime, nil
+	}
+	return seconds, nil
+}
+
+// sizePattern matches rclone sizes such as 100M, 1.5G or 512Ki; a number
+// without a suffix is in KiB.
+var sizePattern = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?)([bB]|[kKmMgGtTpPeE](i|iB)?)?$`)
+
+// Hash types rclone's chunker can keep in its metadata.
+var chunkerHashTypes = map[string]bool{
+	"none":      true,
+	"md5":       true,
+	"sha1":      true,
+	"md5all":    true,
+	"sha1all":   true,
+	"md5quick":  true,
+	"sha1quick": true,
+}
+
+const defaultChunkNameFormat = "*.rclone_chunk.###"
+
+// chunkNamePattern matches chunker name formats: one * for the file name and
+// one run of # for the chunk number.
+var chunkNamePattern = regexp.MustCompile(`^[^*#]*(\*[^*#]*#+|#+[^*#]*\*)[^*#]*$`)
+
+// validateChunker checks the chunker settings of a pool, whether or not the
+// chunker is on, so turning it on later can't fail.
+func validateChunker(pool *models.StoragePool) error {
+	m := sizePattern.FindStringSubmatch(pool.ChunkSize)
+	if m == nil {
+		return validationErrorf("invalid chunk size: %s", pool.ChunkSize)
+	}
+	if n, err := strconv.ParseFloat(m[1], 64); err != nil || n == 0 {
+		return validationErrorf("chunk size must be more than zero")
+	}
+	if pool.ChunkerMode != "upstream" && pool.ChunkerMode != "union" {
+		return validationErrorf("unknown chunker mode: %s", pool.ChunkerMode)
+	}
+	if !chunkerHashTypes[pool.ChunkerHashType] {
+		return validationErrorf("unknown chunker hash type: %s", pool.ChunkerHashType)
+	}
+	if !chunkNamePattern.MatchString(pool.ChunkerNameFormat) {
+		return validationErrorf("chunk name format needs one * and one run of #: %s", pool.ChunkerNameFormat)
+	}
+	return nil
+}
+
+// chunkLayout describes how a pool's files are stored on its members, empty
+// while the chunker is off. Without the chunker its chunks show up as
+// fragments, chunks are only found under the name format they were written
+// with, and union mode spreads them over members where upstream mode looks
+// for all of them on one.
+func chunkLayout(pool *models.StoragePool) string {
+	if !pool.EnableChunker {
+		return ""
+	}
+	return pool.ChunkerMode + " " + pool.ChunkerNameFormat
+}
+
+// UpdatePool applies the changed settings of a pool. A running pool gets a
+// rebuilt union and is remounted if any change needs it; the result reports
+// what changed and whether it was remounted.
+func (s *StorageService) UpdatePool(id string, req *models.UpdatePoolRequest) (*models.UpdatePoolResult, error) {
+	s.mu.Lock()
+	u, err := s.prepareUpdate(id, req)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Listing members can take minutes; other pools and the reconciler
+	// aren't held up meanwhile
+	if err := s.checkUpdateFiles(u, req.Force); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listed := u
+	if u, err = s.prepareUpdate(id, req); err != nil {
+		return nil, err
+	}
+	if (listed.listsFiles(req.Force) || u.listsFiles(req.Force)) && !sameFiles(listed.pool, u.pool) {
+		return nil, validationErrorf("pool changed while its files were listed, try again")
+	}
+
+	if len(u.result.Changed) > 0 {
+		if err := s.savePool(u.updated, req.AccountIDs, u.membersChanged); err != nil {
+			return nil, err
+		}
+	}
+
+	if u.pool.Status == "running" && len(u.result.RemountRequired) > 0 {
+		if err := s.remountPool(id); err != nil {
+			return nil, err
+		}
+		u.result.Remounted = true
+	}
+
+	u.result.Pool, err = s.GetPool(id)
+	if err != nil {
+		return nil, err
+	}
+	return u.result, nil
+}
+
+// poolUpdate is an UpdatePool request checked against the pool it changes.
+type poolUpdate struct {
+	pool, updated  *models.StoragePool
+	result         *models.UpdatePoolResult
+	membersChanged bool
+	removed        []string // members the update takes out
+}
+
+// listsFiles reports whether checkUpdateFiles has to list the pool.
+func (u *poolUpdate) listsFiles(force bool) bool {
+	return chunkLayout(u.updated) != chunkLayout(u.pool) || (!force && len(u.removed) > 0)
+}
+
+// prepareUpdate checks an update against the pool's current settings,
+// leaving out the checks that list its files. Must be called with s.mu held.
+func (s *StorageService) prepareUpdate(id string, req *models.UpdatePoolRequest) (*poolUpdate, error) {
+	pool, err := s.GetPool(id)
+	if err != nil {
+		return nil, err
+	}
+	updated := *pool
+
+	result := &models.UpdatePoolResult{Changed: []string{}, RemountRequired: []string{}}
+	change := func(field string, remount bool) {
+		result.Changed = append(result.Changed, field)
+		if remount {
+			result.RemountRequired = append(result.RemountRequired, field)
+		}
+	}
+
+	if req.Name != nil && *req.Name != pool.Name {
+		if strings.TrimSpace(*req.Name) == "" {
+			return nil, validationErrorf("name must not be empty")
+		}
+		updated.Name = *req.Name
+		change("name", false)
+	}
+	if req.Strategy != nil || req.ActionPolicy != nil || req.CreatePolicy != nil || req.SearchPolicy != nil {
+		err := resolvePolicies(&updated, stringValue(req.Strategy), stringValue(req.ActionPolicy),
+			stringValue(req.CreatePolicy), stringValue(req.SearchPolicy))
+		if err != nil {
+			return nil, err
+		}
+		if updated.Strategy != pool.Strategy {
+			change("strategy", true)
+		}
+		if updated.ActionPolicy != pool.ActionPolicy {
+			change("action_policy", true)
+		}
+		if updated.CreatePolicy != pool.CreatePolicy {
+			change("create_policy", true)
+		}
+		if updated.SearchPolicy != pool.SearchPolicy {
+			change("search_policy", true)
+		}
+	}
+	if req.CacheTime != nil {
+		if updated.CacheTime, err = resolveCacheTime(*req.CacheTime); err != nil {
+			return nil, err
+		}
+		if updated.CacheTime != pool.CacheTime {
+			change("cache_time", true)
+		}
+	}
+	if req.CompressionLevel != nil && *req.CompressionLevel != pool.CompressionLevel {
+		if !validCompressionLevel(*req.CompressionLevel) {
+			return nil, validationErrorf("compression level must be between %d and %d", minCompressionLevel, maxCompressionLevel)
+		}
+		updated.CompressionLevel = *req.CompressionLevel
+		// Only used while compression is on, and only for files written from now
+		change("compression_level", updated.EnableCompression)
+	}
+	if req.CompressionRAMCacheLimit != nil && *req.CompressionRAMCacheLimit != pool.CompressionRAMCacheLimit {
+		if !sizePattern.MatchString(*req.CompressionRAMCacheLimit) {
+			return nil, validationErrorf("invalid compression ram cache limit: %s", *req.CompressionRAMCacheLimit)
+		}
+		updated.CompressionRAMCacheLimit = *req.CompressionRAMCacheLimit
+		change("compression_ram_cache_limit", updated.EnableCompression)
+	}
+	if req.EnableChunker != nil && *req.EnableChunker != pool.EnableChunker {
+		updated.EnableChunker = *req.EnableChunker
+		change("enable_chunker", true)
+	}
+	// The rest is only used by the union while the chunker is on
+	if req.ChunkSize != nil && *req.ChunkSize != pool.ChunkSize {
+		updated.ChunkSize = *req.ChunkSize
+		change("chunk_size", updated.EnableChunker)
+	}
+	if req.ChunkerMode != nil && *req.ChunkerMode != pool.ChunkerMode {
+		updated.ChunkerMode = *req.ChunkerMode
+		change("chunker_mode", updated.EnableChunker)
+	}
+	if req.ChunkerHashType != nil && *req.ChunkerHashType != pool.ChunkerHashType {
+		updated.ChunkerHashType = *req.ChunkerHashType
+		change("chunker_hash_type", updated.EnableChunker)
+	}
+	if req.ChunkerNameFormat != nil && *req.ChunkerNameFormat != pool.ChunkerNameFormat {
+		updated.ChunkerNameFormat = *req.ChunkerNameFormat
+		change("chunker_name_format", updated.EnableChunker)
+	}
+	if req.ChunkerFailHard != nil && *req.ChunkerFailHard != pool.ChunkerFailHard {
+		updated.ChunkerFailHard = *req.ChunkerFailHard
+		change("chunker_fail_hard", updated.EnableChunker)
+	}
+	if err := validateChunker(&updated); err != nil {
+		return nil, err
+	}
+	// Drains and rebalances address files through the chunker they started with
+	if updated.EnableChunker != pool.EnableChunker || updated.ChunkSize != pool.ChunkSize ||
+		updated.ChunkerMode != pool.ChunkerMode || updated.ChunkerHashType != pool.ChunkerHashType ||
+		updated.ChunkerNameFormat != pool.ChunkerNameFormat || updated.ChunkerFailHard != pool.ChunkerFailHard {
+		if err := s.refuseWhileMoving(id, ""); err != nil {
+			return nil, err
+		}
+	}
+	if req.AllowLargeFiles != nil && *req.AllowLargeFiles != pool.AllowLargeFiles {
+		updated.AllowLargeFiles = *req.AllowLargeFiles
+		change("allow_large_files", true)
+	}
+	if req.AutoStart != nil && *req.AutoStart != pool.AutoStart {
+		updated.AutoStart = *req.AutoStart
+		change("auto_start", false)
+	}
+	if req.MountProfile != nil && *req.MountProfile != pool.MountProfile {
+		updated.MountProfile = *req.MountProfile
+		change("mount_profile", true)
+	}
+	if req.MountOptions != nil && !reflect.DeepEqual(*req.MountOptions, pool.MountOptions) {
+		updated.MountOptions = *req.MountOptions
+		change("mount_options", true)
+	}
+	if err := validateMount(&updated); err != nil {
+		return nil, err
+	}
+
+	u := &poolUpdate{pool: pool, updated: &updated, result: result}
+	if req.AccountIDs != nil {
+		if err := s.validateMembers(req.AccountIDs, pool.Status == "running"); err != nil {
+			return nil, err
+		}
+		current := make([]string, len(pool.Accounts))
+		for i, account := range pool.Accounts {
+			current[i] = account.ID
+		}
+		if strings.Join(current, ",") != strings.Join(req.AccountIDs, ",") {
+			u.membersChanged = true
+			change("accounts", true)
+		}
+
+		kept := make(map[string]bool, len(req.AccountIDs))
+		for _, accountID := range req.AccountIDs {
+			kept[accountID] = true
+		}
+		for _, accountID := range current {
+			if kept[accountID] {
+				continue
+			}
+			if err := s.refuseWhileMoving(id, accountID); err != nil {
+				return nil, err
+			}
+			u.removed = append(u.removed, accountID)
+		}
+	}
+	return u, nil
+}
+
+// checkUpdateFiles runs the checks of an update that list the pool: files
+// only read back under the chunk layout they were written with, and unless
+// force is set, members leaving must not hold the only copy of a file.
+func (s *StorageService) checkUpdateFiles(u *poolUpdate, force bool) error {
+	if chunkLayout(u.updated) != chunkLayout(u.pool) {
+		holdsFiles, err := s.poolHoldsFiles(u.pool)
+		if err != nil {
+			return err
+		}
+		if holdsFiles {
+			return validationErrorf("the chunker can only be turned on or off, or its mode and name format changed, while the pool is empty")
+		}
+	}
+	if force {
+		return nil
+	}
+	for _, accountID := range u.removed {
+		if err := s.checkUniqueFiles(u.pool, accountID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameFiles reports whether a pool still has the members and chunk layout
+// it had when its files were listed.
+func sameFiles(listed, current *models.StoragePool) bool {
+	if chunkLayout(listed) != chunkLayout(current) || len(listed.Accounts) != len(current.Accounts) {
+		return false
+	}
+	members := make(map[string]bool, len(listed.Accounts))
+	for _, account := range listed.Accounts {
+		members[account.ID] = true
+	}
+	for _, account := range current.Accounts {
+		if !members[account.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvePolicies sets the pool's union policies to those of the strategy
+// preset, unless the strategy is "custom" or empty, and then to any policy
+// given explicitly. The pool's strategy is set to the preset its policies
+// match, or "custom".
+func resolvePolicies(pool *models.StoragePool, strategy, action, create, search string) error {
+	if strategy != "" && strategy != "custom" {
+		preset, ok := rclone.UnionPresets[strategy]
+		if !ok {
+			return validationErrorf("unknown strategy: %s", strategy)
+		}
+		pool.ActionPolicy = preset.Action
+		pool.CreatePolicy = preset.Create
+		pool.SearchPolicy = preset.Search
+	}
+
+	overrides := []struct {
+		category string
+		value    string
+		policy   *string
+	}{
+		{"action", action, &pool.ActionPolicy},
+		{"create", create, &pool.CreatePolicy},
+		{"search", search, &pool.SearchPolicy},
+	}
+	for _, o := range overrides {
+		if o.value != "" {
+			if !rclone.UnionPolicies[o.value] {
+				return validationErrorf("unknown %s policy: %s", o.category, o.value)
+			}
+			*o.policy = o.value
+		}
+		if *o.policy == "" {
+			return validationErrorf("%s policy is required for a custom strategy", o.category)
+		}
+	}
+
+	pool.Strategy = "custom"
+	for name, preset := range rclone.UnionPresets {
+		if preset == (rclone.UnionPreset{Action: pool.ActionPolicy, Create: pool.CreatePolicy, Search: pool.SearchPolicy}) {
+			pool.Strategy = name
+		}
+	}
+	return nil
+}
+
+// Filename encryption modes of rclone crypt.
+var filenameEncryptionModes = map[string]bool{
+	"standard":  true,
+	"obfuscate": true,
+	"off":       true,
+}
+
+// setEncryption validates the encryption settings of a new pool and
+// generates its password and salt.
+func setEncryption(pool *models.StoragePool, encryption, filenameEncryption string) error {
+	switch encryption {
+	case "", "none":
+		return nil
+	case "union", "upstream":
+	default:
+		return validationErrorf("unknown encryption: %s", encryption)
+	}
+	if filenameEncryption == "" {
+		filenameEncryption = "standard"
+	}
+	if !filenameEncryptionModes[filenameEncryption] {
+		return validationErrorf("unknown filename encryption: %s", filenameEncryption)
+	}
+
+	keys := make([]string, 2)
+	for i := range keys {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		keys[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	pool.Encryption = encryption
+	pool.FilenameEncryption = filenameEncryption
+	pool.CryptPassword = keys[0]
+	pool.CryptSalt = keys[1]
+	return nil
+}
+
+// Gzip levels rclone's compress remote accepts; -1 is gzip's own default
+// and -2 favours speed over size.
+const (
+	minCompressionLevel             = -2
+	maxCompressionLevel             = 9
+	defaultCompressionLevel         = -1
+	defaultCompressionRAMCacheLimit = "20M"
+)
+
+func validCompressionLevel(level int) bool {
+	return level >= minCompressionLevel && level <= maxCompressionLevel
+}
+
+// setCompression validates the compression settings of a new pool. Whether
+// a pool compresses can't change later: files written one way can't be read
+// the other.
+func setCompression(pool *models.StoragePool, enable bool, level *int, ramCacheLimit string) error {
+	pool.EnableCompression = enable
+	pool.CompressionLevel = defaultCompressionLevel
+	if level != nil {
+		if !validCompressionLevel(*level) {
+			return validationErrorf("compression level must be between %d and %d", minCompressionLevel, maxCompressionLevel)
+		}
+		pool.CompressionLevel = *level
+	}
+	pool.CompressionRAMCacheLimit = defaultCompressionRAMCacheLimit
+	if ramCacheLimit != "" {
+		if !sizePattern.MatchString(ramCacheLimit) {
+			return validationErrorf("invalid compression ram cache limit: %s", ramCacheLimit)
+		}
+		pool.CompressionRAMCacheLimit = ramCacheLimit
+	}
+	return nil
+}
+
+// durationPattern matches rclone durations such as 90s, 1h30m or 2d.
+var durationPattern = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?(ms|s|m|h|d|w|M|y))+$`)
+
+var umaskPattern = regexp.MustCompile(`^0?[0-7]{1,3}$`)
+
+var vfsCacheModes = map[string]bool{"off": true, "minimal": true, "writes": true, "full": true}
+
+// validateMount checks the mount profile of a pool and its overrides.
+func validateMount(pool *models.StoragePool) error {
+	if _, ok := rclone.MountProfiles[pool.MountProfile]; !ok {
+		return validationErrorf("unknown mount profile: %s", pool.MountProfile)
+	}
+
+	o := pool.MountOptions
+	if o.CacheMode != "" && !vfsCacheModes[o.CacheMode] {
+		return validationErrorf("unknown cache mode: %s", o.CacheMode)
+	}
+	for _, f := range []struct{ name, value string }{
+		{"cache max size", o.CacheMaxSize},
+		{"buffer size", o.BufferSize},
+		{"read ahead", o.ReadAhead},
+	} {
+		if f.value != "" && !sizePattern.MatchString(f.value) {
+			return validationErrorf("invalid %s: %s", f.name, f.value)
+		}
+	}
+	for _, f := range []struct{ name, value string }{
+		{"cache max age", o.CacheMaxAge},
+		{"dir cache time", o.DirCacheTime},
+		{"poll interval", o.PollInterval},
+	} {
+		if f.value != "" && !durationPattern.MatchString(f.value) {
+			return validationErrorf("invalid %s: %s", f.name, f.value)
+		}
+	}
+	if o.Umask != "" && !umaskPattern.MatchString(o.Umask) {
+		return validationErrorf("umask must be octal, e.g. 002: %s", o.Umask)
+	}
+	if (o.UID != nil && *o.UID < 0) || (o.GID != nil && *o.GID < 0) {
+		return validationErrorf("uid and gid must not be negative")
+	}
+	if o.BwLimit != "" && !validBandwidthLimit(o.BwLimit) {
+		return validationErrorf("invalid bandwidth limit: %s", o.BwLimit)
+	}
+	return nil
+}
+
+// validBandwidthLimit reports whether limit is an rclone --bwlimit rate: a
+// size per second or off, or upload:download as two of them. rclone's
+// timetables aren't supported.
+func validBandwidthLimit(limit string) bool {
+	for _, rate := range strings.SplitN(limit, ":", 2) {
+		if rate != "off" && !sizePattern.MatchString(rate) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeMountOptions stores no overrides as NULL.
+func encodeMountOptions(opts models.MountOptions) (interface{}, error) {
+	if reflect.DeepEqual(opts, models.MountOptions{}) {
+		return nil, nil
+	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func decodeMountOptions(data string, opts *models.MountOptions) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), opts)
+}
+
+// MountProfiles returns the presets pools can be mounted with.
+func (s *StorageService) MountProfiles() map[string]models.MountOptions {
+	return rclone.MountProfiles
+}
+
+// openKeys decrypts the crypt password and salt of a pool as read from the
+// database.
+func (s *StorageService) openKeys(pool *models.StoragePool) error {
+	var err error
+	if pool.CryptPassword, err = s.secrets.Open(pool.CryptPassword); err != nil {
+		return err
+	}
+	if pool.CryptSalt, err = s.secrets.Open(pool.CryptSalt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *StorageService) sealAll(values ...string) ([]string, error) {
+	sealed := make([]string, len(values))
+	for i, v := range values {
+		var err error
+		if sealed[i], err = s.secrets.Seal(v); err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// ExportCryptKeys returns the keys of an encrypted pool for safekeeping.
+// Without them the pool's files can't be read if the database is lost.
+func (s *StorageService) ExportCryptKeys(id string) (*models.CryptKeys, error) {
+	pool, err := s.GetPool(id)
+	if err != nil {
+		return nil, err
+	}
+	if pool.Encryption == "" {
+		return nil, validationErrorf("pool is not encrypted")
+	}
+	keys := &models.CryptKeys{
+		PoolID:             pool.ID,
+		Encryption:         pool.Encryption,
+		FilenameEncryption: pool.FilenameEncryption,
+		Password:           pool.CryptPassword,
+		Salt:               pool.CryptSalt,
+	}
+	// The keys are fixed at creation, a pool without members has no config
+	// yet but the keys are worth saving already
+	if len(pool.Accounts) > 0 {
+		if keys.Config, err = rclone.PoolConfig(pool); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Exported the crypt keys of pool %s", pool.ID)
+	return keys, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// validateMembers checks that the accounts exist and are listed once.
+func (s *StorageService) validateMembers(accountIDs []string, running bool) error {
+	if running && len(accountIDs) == 0 {
+		return validationErrorf("a running pool needs at least one account")
+	}
+	seen := make(map[string]bool, len(accountIDs))
+	for _, accountID := range accountIDs {
+		if seen[accountID] {
+			return validationErrorf("account %s is listed twice", accountID)
+		}
+		seen[accountID] = true
+
+		var exists int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM accounts WHERE id = ?", accountID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return validationErrorf("account %s does not exist", accountID)
+		}
+	}
+	return nil
+}
+
+// savePool writes the settings of a pool and, if membersChanged, replaces
+// its members with accountIDs in priority order.
+func (s *StorageService) savePool(pool *models.StoragePool, accountIDs []string, membersChanged bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE storage_pools SET name = ?, strategy = ?, action_policy = ?, create_policy = ?, search_policy = ?,
+			  cache_time = ?, compression_level = ?, compression_ram_cache_limit = ?, enable_chunker = ?,
+			  allow_large_files = ?, chunk_size = ?, chunker_mode = ?, chunker_hash_type = ?, chunker_name_format = ?,
+			  chunker_fail_hard = ?, auto_start = ?, mount_profile = ?, mount_options = ?, updated_at = ?
+			  WHERE id = ?`
+	mountOptions, err := encodeMountOptions(pool.MountOptions)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(query, pool.Name, pool.Strategy, pool.ActionPolicy, pool.CreatePolicy, pool.SearchPolicy,
+		pool.CacheTime, pool.CompressionLevel, pool.CompressionRAMCacheLimit, pool.EnableChunker, pool.AllowLargeFiles,
+		pool.ChunkSize, pool.ChunkerMode, pool.ChunkerHashType, pool.ChunkerNameFormat, pool.ChunkerFailHard,
+		pool.AutoStart, pool.MountProfile, mountOptions, time.Now(), pool.ID)
+	if err != nil {
+		return err
+	}
+
+	if membersChanged {
+		// Members that stay keep their mode
+		modes := map[string]string{}
+		for _, account := range pool.Accounts {
+			modes[account.ID] = account.PoolMode
+		}
+		if _, err := tx.Exec("DELETE FROM pool_accounts WHERE pool_id = ?", pool.ID); err != nil {
+			return err
+		}
+		for i, accountID := range accountIDs {
+			mode := modes[accountID]
+			if mode == "" {
+				mode = "rw"
+			}
+			query = `INSERT INTO pool_accounts (pool_id, account_id, priority, mode) VALUES (?, ?, ?, ?)`
+			if _, err := tx.Exec(query, pool.ID, accountID, i, mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// remountPool rewrites the union of a running pool from the database and
+// remounts it. The union is replaced first so the pool is only unmounted
+// for as long as rclone takes to come back up. Serves read the config when
+// they start, so they are restarted too. Must be called with s.mu held.
+func (s *StorageService) remountPool(id string) error {
+	pool, err := s.GetPool(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.rclone.CreateUnion(pool); err != nil {
+		return fmt.Errorf("failed to update union: %w", err)
+	}
+	s.stopServes(pool)
+	if err := s.rclone.UnmountPool(pool); err != nil {
+		// The old mount is still up, so the pool keeps running as it was
+		s.startServes(pool)
+		return fmt.Errorf("failed to unmount pool: %w", err)
+	}
+	if err := s.rclone.MountPool(pool); err != nil {
+		s.updatePoolStatus(id, "error")
+		return fmt.Errorf("failed to remount pool: %w", err)
+	}
+	s.startServes(pool)
+	return nil
+}
+
+// GetPoolLogs returns the recent output of the pool's rclone mount.
+func (s *StorageService) GetPoolLogs(id string) ([]string, error) {
+	if _, err := s.GetPool(id); err != nil {
+		return nil, err
+	}
+	return s.rclone.MountLogs(id)
+}
+
+// AddAccountToPool appends an account to a pool. A running pool is remounted
+// with the new member.
 func (s *StorageService) AddAccountToPool(poolID, accountID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, err := s.GetPool(poolID)
+	if err != nil {
+		return err
+	}
+	for _, account := range pool.Accounts {
+		if account.ID == accountID {
+			return validationErrorf("account %s is already in the pool", accountID)
+		}
+	}
+	if err := s.validateMembers([]string{accountID}, false); err != nil {
+		return err
+	}
+
 	query := `INSERT INTO pool_accounts (pool_id, account_id, priority)
 			  SELECT ?, ?, COALESCE(MAX(priority), 0) + 1 FROM pool_accounts WHERE pool_id = ?`
-	_, err := s.db.Exec(query, poolID, accountID, poolID)
-	return err
+	if _, err := s.db.Exec(query, poolID, accountID, poolID); err != nil {
+		return err
+	}
+
+	if pool.Status == "running" {
+		return s.remountPool(poolID)
+	}
+	return nil
 }
 
-func (s *StorageService) RemoveAccountFromPool(poolID, accountID string) error {
-	_, err := s.db.Exec("DELETE FROM pool_accounts WHERE pool_id = ? AND account_id = ?", poolID, accountID)
-	return err
+// RemoveAccountFromPool takes an account out of a pool, remounting it if it
+// is running. Unless force is set, an account holding files that no other
+// member has is refused with a UniqueFilesError.
+func (s *StorageService) RemoveAccountFromPool(poolID, accountID string, force bool) error {
+	s.mu.Lock()
+	listed, err := s.checkRemoval(poolID, accountID)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Listing members can take minutes; other pools and the reconciler
+	// aren't held up meanwhile
+	if !force {
+		if err := s.checkUniqueFiles(listed, accountID); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pool, err := s.checkRemoval(poolID, accountID)
+	if err != nil {
+		return err
+	}
+	if !force && !sameFiles(listed, pool) {
+		return validationErrorf("pool changed while its files were listed, try again")
+	}
+
+	if _, err := s.db.Exec("DELETE FROM pool_accounts WHERE pool_id = ? AND account_id = ?", poolID, accountID); err != nil {
+		return err
+	}
+
+	if pool.Status == "running" {
+		return s.remountPool(poolID)
+	}
+	return nil
+}
+
+// checkRemoval returns the pool if accountID can be taken out of it, files
+// aside. Must be called with s.mu held.
+func (s *StorageService) checkRemoval(poolID, accountID string) (*models.StoragePool, error) {
+	pool, err := s.GetPool(poolID)
+	if err != nil {
+		return nil, err
+	}
+	member := false
+	for _, account := range pool.Accounts {
+		if account.ID == accountID {
+			member = true
+		}
+	}
+	if !member {
+		return nil, validationErrorf("account %s is not in the pool", accountID)
+	}
+	if err := s.refuseWhileMoving(poolID, accountID); err != nil {
+		return nil, err
+	}
+	if pool.Status == "running" && len(pool.Accounts) == 1 {
+		return nil, validationErrorf("cannot remove the last account of a running pool, stop it first")
+	}
+	return pool, nil
+}
+
+// drainInProgress reports whether the pool has a drain that hasn't finished,
+// of accountID if it is set.
+func (s *StorageService) drainInProgress(poolID, accountID string) (bool, error) {
+	query := `SELECT COUNT(*) FROM drain_jobs WHERE pool_id = ? AND status IN ` + activeDrainStatuses
+	args := []interface{}{poolID}
+	if accountID != "" {
+		query += ` AND account_id = ?`
+		args = append(args, accountID)
+	}
+	var count int
+	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// rebalanceInProgress reports whether the pool has a rebalance moving files.
+func (s *StorageService) rebalanceInProgress(poolID string) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM rebalance_jobs WHERE pool_id = ? AND status = 'running'`, poolID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// refuseWhileMoving returns a validation error while a drain of accountID,
+// or any drain if it is empty, or a rebalance is moving the pool's files.
+func (s *StorageService) refuseWhileMoving(poolID, accountID string) error {
+	draining, err := s.drainInProgress(poolID, accountID)
+	if err != nil {
+		return err
+	}
+	if draining && accountID != "" {
+		return validationErrorf("account %s is being drained, cancel the drain first", accountID)
+	}
+	if draining {
+		return validationErrorf("pool has a drain in progress, cancel it first")
+	}
+
+	rebalancing, err := s.rebalanceInProgress(poolID)
+	if err != nil {
+		return err
+	}
+	if rebalancing {
+		return validationErrorf("pool has a rebalance in progress, cancel it first")
+	}
+	return nil
+}
+
+// poolHoldsFiles reports whether any member of the pool has files.
+func (s *StorageService) poolHoldsFiles(pool *models.StoragePool) (bool, error) {
+	for i := range pool.Accounts {
+		files, err := s.rclone.ListFiles(pool, &pool.Accounts[i])
+		if err != nil {
+			return false, fmt.Errorf("failed to list files of account %s: %w", pool.Accounts[i].ID, err)
+		}
+		if len(files) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// maxUniqueFilesReported caps the file list in a UniqueFilesError.
+const maxUniqueFilesReported = 20
+
+// checkUniqueFiles returns a UniqueFilesError if the account holds files, by
+// path, that no other member of the pool has.
+func (s *StorageService) checkUniqueFiles(pool *models.StoragePool, accountID string) error {
+	var leaving *models.Account
+	elsewhere := map[string]bool{}
+	for i := range pool.Accounts {
+		account := &pool.Accounts[i]
+		if account.ID == accountID {
+			leaving = account
+			continue
+		}
+		files, err := s.rclone.ListFiles(pool, account)
+		if err != nil {
+			return fmt.Errorf("failed to list files of account %s, use force to remove anyway: %w", account.ID, err)
+		}
+		for _, file := range files {
+			elsewhere[file.Path] = true
+		}
+	}
+
+	files, err := s.rclone.ListFiles(pool, leaving)
+	if err != nil {
+		return fmt.Errorf("failed to list files of account %s, use force to remove anyway: %w", accountID, err)
+	}
+	uniqueErr := &UniqueFilesError{AccountID: accountID}
+	for _, file := range files {
+		if elsewhere[file.Path] {
+			continue
+		}
+		uniqueErr.Count++
+		if len(uniqueErr.Files) < maxUniqueFilesReported {
+			uniqueErr.Files = append(uniqueErr.Files, file.Path)
+		}
+	}
+	if uniqueErr.Count > 0 {
+		return uniqueErr
+	}
+	return nil
 }
 
 func (s *StorageService) updatePoolStatus(id, status string) {
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"errors"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"strings"
+	"testing"
+)
+
+func TestCacheTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int
+		want    int
+		invalid bool
+	}{
+		{"zero is the default", 0, defaultCacheTime, false},
+		{"explicit", 30, 30, false},
+		{"negative", -1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+
+			// Create and update must agree on what is valid
+			pool, createErr := env.storage.CreatePool(&models.CreatePoolRequest{Name: "p", CacheTime: tt.seconds})
+			if tt.invalid {
+				var verr *ValidationError
+				if !errors.As(createErr, &verr) {
+					t.Errorf("create: err = %v, want a validation error", createErr)
+				}
+				pool = env.addPool(t, models.CreatePoolRequest{CacheTime: 10})
+			} else if createErr != nil {
+				t.Fatalf("create: %v", createErr)
+			} else if pool.CacheTime != tt.want {
+				t.Errorf("create: cache time = %d, want %d", pool.CacheTime, tt.want)
+			}
+
+			seconds := tt.seconds
+			result, err := env.storage.UpdatePool(pool.ID, &models.UpdatePoolRequest{CacheTime: &seconds})
+			if tt.invalid {
+				var verr *ValidationError
+				if !errors.As(err, &verr) {
+					t.Errorf("update: err = %v, want a validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			if result.Pool.CacheTime != tt.want {
+				t.Errorf("update: cache time = %d, want %d", result.Pool.CacheTime, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	pool := env.addPool(t, models.CreatePoolRequest{}, env.addAccount(t, "a"), env.addAccount(t, "b"))
+	serve, err := NewServeService(env.db, env.storage, env.backend).CreateServe(pool.ID, &models.CreateServeRequest{Protocol: "webdav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountPath := env.backend.PoolMountPath(pool.ID)
+
+	if status := env.poolStatus(t, pool.ID); status != "stopped" {
+		t.Fatalf("new pool is %s, want stopped", status)
+	}
+	if _, ok := env.backend.Union(pool.ID); ok {
+		t.Error("new pool has a union")
+	}
+
+	if err := env.storage.StartPool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	running, err := env.storage.GetPool(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.Status != "running" || running.MountPath != mountPath {
+		t.Errorf("started pool is %s at %q, want running at %q", running.Status, running.MountPath, mountPath)
+	}
+	if upstreams, _ := env.backend.Union(pool.ID); len(upstreams) != 2 {
+		t.Errorf("union of the started pool = %v, want both accounts", upstreams)
+	}
+	if !env.backend.IsMounted(mountPath) {
+		t.Error("started pool is not mounted")
+	}
+	if !env.backend.ServeRunning(serve.ID) {
+		t.Error("serve of the started pool is not running")
+	}
+	if err := env.storage.StartPool(pool.ID); err == nil {
+		t.Error("starting a running pool succeeded")
+	}
+
+	if err := env.storage.StopPool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.poolStatus(t, pool.ID); status != "stopped" {
+		t.Errorf("stopped pool is %s", status)
+	}
+	if _, ok := env.backend.Union(pool.ID); ok {
+		t.Error("stopped pool still has a union")
+	}
+	if env.backend.IsMounted(mountPath) {
+		t.Error("stopped pool is still mounted")
+	}
+	if env.backend.ServeRunning(serve.ID) {
+		t.Error("serve of the stopped pool is still running")
+	}
+	if err := env.storage.StopPool(pool.ID); err == nil {
+		t.Error("stopping a stopped pool succeeded")
+	}
+}
+
+func TestStartPoolFailure(t *testing.T) {
+	for _, op := range []string{"CreateUnion", "MountPool"} {
+		t.Run(op, func(t *testing.T) {
+			env := newTestEnv(t)
+			pool := env.addPool(t, models.CreatePoolRequest{}, env.addAccount(t, "a"))
+
+			env.backend.Fail(op, errors.New("boom"))
+			if err := env.storage.StartPool(pool.ID); err == nil {
+				t.Fatal("start succeeded")
+			}
+			if status := env.poolStatus(t, pool.ID); status != "error" {
+				t.Errorf("pool is %s, want error", status)
+			}
+			if env.backend.IsMounted(env.backend.PoolMountPath(pool.ID)) {
+				t.Error("pool is mounted")
+			}
+
+			// A pool in error can be started again
+			env.backend.ClearFailure(op)
+			if err := env.storage.StartPool(pool.ID); err != nil {
+				t.Fatalf("retry: %v", err)
+			}
+			if status := env.poolStatus(t, pool.ID); status != "running" {
+				t.Errorf("pool is %s after the retry, want running", status)
+			}
+		})
+	}
+}
+
+func TestRemountUnmountFailure(t *testing.T) {
+	env := newTestEnv(t)
+	pool := env.addPool(t, models.CreatePoolRequest{}, env.addAccount(t, "a"))
+	serve, err := NewServeService(env.db, env.storage, env.backend).CreateServe(pool.ID, &models.CreateServeRequest{Protocol: "webdav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.storage.StartPool(pool.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	env.backend.Fail("UnmountPool", errors.New("busy"))
+	if err := env.storage.AddAccountToPool(pool.ID, env.addAccount(t, "b").ID); err == nil {
+		t.Fatal("remount succeeded")
+	}
+	if status := env.poolStatus(t, pool.ID); status != "running" {
+		t.Errorf("pool is %s, want running", status)
+	}
+	if !env.backend.IsMounted(env.backend.PoolMountPath(pool.ID)) {
+		t.Error("pool is not mounted")
+	}
+	if !env.backend.ServeRunning(serve.ID) {
+		t.Error("serve of the pool is not running")
+	}
+}
+
+func TestMountOptionsValidation(t *testing.T) {
+	uid := -1
+	tests := []struct {
+		name    string
+		profile string
+		opts    models.MountOptions
+		wantErr bool
+	}{
+		{"default profile", "", models.MountOptions{}, false},
+		{"overrides", "media", models.MountOptions{CacheMode: "minimal", CacheMaxSize: "10G", CacheMaxAge: "1h30m",
+			Umask: "002", BwLimit: "off:10M"}, false},
+		{"unknown profile", "fast", models.MountOptions{}, true},
+		{"cache mode", "", models.MountOptions{CacheMode: "all"}, true},
+		{"size", "", models.MountOptions{BufferSize: "lots"}, true},
+		{"duration", "", models.MountOptions{DirCacheTime: "5 minutes"}, true},
+		{"umask", "", models.MountOptions{Umask: "999"}, true},
+		{"uid", "", models.MountOptions{UID: &uid}, true},
+		{"bandwidth timetable", "", models.MountOptions{BwLimit: "08:00,512k"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			_, err := env.storage.CreatePool(&models.CreatePoolRequest{Name: "p", MountProfile: tt.profile, MountOptions: tt.opts})
+			var verr *ValidationError
+			if tt.wantErr && !errors.As(err, &verr) {
+				t.Errorf("create: err = %v, want a validation error", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("create: %v", err)
+			}
+
+			// Updates are checked the same way
+			pool := env.addPool(t, models.CreatePoolRequest{Name: "q"})
+			req := &models.UpdatePoolRequest{MountOptions: &tt.opts}
+			if tt.profile != "" {
+				req.MountProfile = &tt.profile
+			}
+			_, err = env.storage.UpdatePool(pool.ID, req)
+			if tt.wantErr && !errors.As(err, &verr) {
+				t.Errorf("update: err = %v, want a validation error", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("update: %v", err)
+			}
+		})
+	}
+}
+
+func TestExportCryptKeys(t *testing.T) {
+	env := newTestEnv(t)
+	if _, err := env.storage.ExportCryptKeys(env.addPool(t, models.CreatePoolRequest{}).ID); err == nil {
+		t.Error("exported the keys of an unencrypted pool")
+	}
+
+	pool := env.addPool(t, models.CreatePoolRequest{Name: "crypt", Encryption: "union"})
+	keys, err := env.storage.ExportCryptKeys(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Password == "" || keys.Salt == "" || keys.Config != "" {
+		t.Errorf("keys of an empty pool = %+v, want the password and salt only", keys)
+	}
+
+	if err := env.storage.AddAccountToPool(pool.ID, env.addAccount(t, "a").ID); err != nil {
+		t.Fatal(err)
+	}
+	withConfig, err := env.storage.ExportCryptKeys(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withConfig.Password != keys.Password || withConfig.Salt != keys.Salt || !strings.Contains(withConfig.Config, "type = crypt") {
+		t.Errorf("keys once the pool has a member = %+v, want the same keys and a crypt config", withConfig)
+	}
+}
+
+func TestChunkerToggleNeedsEmptyPool(t *testing.T) {
+	env := newTestEnv(t)
+	a := env.addAccount(t, "a")
+	pool := env.addPool(t, models.CreatePoolRequest{}, a, env.addAccount(t, "b"))
+	on, off := true, false
+
+	// An empty pool can switch either way
+	if _, err := env.storage.UpdatePool(pool.ID, &models.UpdatePoolRequest{EnableChunker: &on}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.storage.UpdatePool(pool.ID, &models.UpdatePoolRequest{EnableChunker: &off}); err != nil {
+		t.Fatal(err)
+	}
+
+	env.backend.SetFiles(a.ID, []rclone.ListItem{{Path: "f", Name: "f", Size: 1}})
+	var verr *ValidationError
+	if _, err := env.storage.UpdatePool(pool.ID, &models.UpdatePoolRequest{EnableChunker: &on}); !errors.As(err, &verr) {
+		t.Errorf("turning the chunker on with files: err = %v, want a validation error", err)
+	}
+	if got, _ := env.storage.GetPool(pool.ID); got.EnableChunker {
+		t.Error("refused update turned the chunker on")
+	}
+}
+
+func TestChunkLayoutNeedsEmptyPool(t *testing.T) {
+	on := true
+	union, format := "union", "*.part###"
+	steps := []models.UpdatePoolRequest{
+		{ChunkerMode: &union},
+		{ChunkerNameFormat: &format},
+		{EnableChunker: &on, ChunkerMode: &union},
+		{EnableChunker: &on},
+	}
+
+	// An empty pool can change layout at will
+	env := newTestEnv(t)
+	pool := env.addPool(t, models.CreatePoolRequest{EnableChunker: true}, env.addAccount(t, "a"))
+	for i := range steps[:2] {
+		if _, err := env.storage.UpdatePool(pool.ID, &steps[i]); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		chunker bool
+		steps   []models.UpdatePoolRequest // all but the last succeed
+	}{
+		{"change mode", true, steps[:1]},
+		{"change name format", true, steps[1:2]},
+		{"turn on with another mode", false, steps[2:3]},
+		// Settings can change while the chunker is off, but not take effect
+		{"change while off and turn on", false, []models.UpdatePoolRequest{steps[0], steps[1], steps[3]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			a := env.addAccount(t, "a")
+			pool := env.addPool(t, models.CreatePoolRequest{EnableChunker: tt.chunker}, a)
+			before, err := env.storage.GetPool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env.backend.SetFiles(a.ID, []rclone.ListItem{{Path: "f", Name: "f", Size: 1}})
+
+			last := len(tt.steps) - 1
+			for i := range tt.steps[:last] {
+				if _, err := env.storage.UpdatePool(pool.ID, &tt.steps[i]); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+			}
+			var verr *ValidationError
+			if _, err := env.storage.UpdatePool(pool.ID, &tt.steps[last]); !errors.As(err, &verr) {
+				t.Errorf("err = %v, want a validation error", err)
+			}
+			after, err := env.storage.GetPool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunkLayout(after) != chunkLayout(before) {
+				t.Errorf("chunk layout went from %q to %q", chunkLayout(before), chunkLayout(after))
+			}
+		})
+	}
+}
+
+// listingBackend runs onList before each listing of a member's files.
+type listingBackend struct {
+	*rclone.MemoryBackend
+	onList func()
+}
+
+func (b *listingBackend) ListFiles(pool *models.StoragePool, account *models.Account) ([]rclone.ListItem, error) {
+	b.onList()
+	return b.MemoryBackend.ListFiles(pool, account)
+}
+
+func TestListWithoutLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(s *StorageService, poolID, accountID string) error
+	}{
+		{"remove account", func(s *StorageService, poolID, accountID string) error {
+			return s.RemoveAccountFromPool(poolID, accountID, false)
+		}},
+		{"update members", func(s *StorageService, poolID, accountID string) error {
+			pool, err := s.GetPool(poolID)
+			if err != nil {
+				return err
+			}
+			var ids []string
+			for _, account := range pool.Accounts {
+				if account.ID != accountID {
+					ids = append(ids, account.ID)
+				}
+			}
+			_, err = s.UpdatePool(poolID, &models.UpdatePoolRequest{AccountIDs: ids})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			a, b, c := env.addAccount(t, "a"), env.addAccount(t, "b"), env.addAccount(t, "c")
+			pool := env.addPool(t, models.CreatePoolRequest{}, a, b, c)
+			env.backend.SetFiles(a.ID, []rclone.ListItem{{Path: "f", Name: "f", Size: 1}})
+			env.backend.SetFiles(b.ID, []rclone.ListItem{{Path: "f", Name: "f", Size: 1}})
+
+			backend := &listingBackend{MemoryBackend: env.backend}
+			storage := NewStorageService(env.db, backend, env.sealer)
+			changed := false
+			backend.onList = func() {
+				if !storage.mu.TryLock() {
+					t.Fatal("member files listed while holding the pool lock")
+				}
+				storage.mu.Unlock()
+				// Another caller takes out the member holding the second copy
+				if !changed {
+					changed = true
+					if err := storage.RemoveAccountFromPool(pool.ID, a.ID, true); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var verr *ValidationError
+			if err := tt.remove(storage, pool.ID, b.ID); !errors.As(err, &verr) {
+				t.Fatalf("err = %v, want a validation error", err)
+			}
+			got, err := storage.GetPool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Accounts) != 2 || got.Accounts[0].ID == a.ID || got.Accounts[1].ID == a.ID {
+				t.Errorf("pool has %d members, want b and c", len(got.Accounts))
+			}
+
+			// Left alone meanwhile, a member without unique files can go
+			if err := tt.remove(storage, pool.ID, c.ID); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"pooled-storage/internal/models"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenRenewInterval = 5 * time.Minute
+	// Renew tokens this long before they expire so rclone never sees an
+	// expired one between two passes.
+	tokenRenewLeeway = 15 * time.Minute
+)
+
+// TokenRenewer refreshes OAuth access tokens in the background before they
+// expire and marks accounts as errored when the refresh token is rejected.
+type TokenRenewer struct {
+	accounts *AccountService
+	oauth    *OAuthService
+}
+
+func NewTokenRenewer(accounts *AccountService, oauth *OAuthService) *TokenRenewer {
+	return &TokenRenewer{
+		accounts: accounts,
+		oauth:    oauth,
+	}
+}
+
+// Run renews expiring tokens immediately and then every tokenRenewInterval
+// until ctx is cancelled.
+func (r *TokenRenewer) Run(ctx context.Context) {
+	ticker := time.NewTicker(tokenRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.RenewExpiring(ctx); err != nil {
+			log.Printf("Token renewal failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *TokenRenewer) RenewExpiring(ctx context.Context) error {
+	accounts, err := r.accounts.GetExpiringAccounts(time.Now().Add(tokenRenewLeeway))
+	if err != nil {
+		return err
+	}
+
+	for i := range accounts {
+		account := &accounts[i]
+		if err := r.Renew(ctx, account); err != nil {
+			log.Printf("Failed to renew token for account %s (%s): %v", account.ID, account.Email, err)
+			if refreshRejected(err) {
+				r.accounts.UpdateAccountStatus(account.ID, "error")
+			}
+			continue
+		}
+		if account.Status == "error" {
+			r.accounts.UpdateAccountStatus(account.ID, "active")
+		}
+	}
+
+	return nil
+}
+
+func (r *TokenRenewer) Renew(ctx context.Context, account *models.Account) error {
+	config, err := r.oauth.GetConfig(account.Type)
+	if err != nil {
+		return err
+	}
+
+	// Only the refresh token is passed so the token source always refreshes.
+	token, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: account.RefreshToken}).Token()
+	if err != nil {
+		return err
+	}
+
+	return r.accounts.UpdateToken(account.ID, token)
+}
+
+// refreshRejected reports whether the provider refused the refresh token
+// itself, which only reconnecting the account fixes. Anything else, such as
+// an outage or a network error, is retried on the next pass.
+func refreshRejected(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	return retrieveErr.ErrorCode == "invalid_grant" || retrieveErr.ErrorCode == "unauthorized_client"
+}
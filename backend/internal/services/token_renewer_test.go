@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pooled-storage/internal/models"
+	"pooled-storage/internal/rclone"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshStub answers every token request, wherever it is sent, with status
+// and body, counting them in requests.
+func refreshStub(t *testing.T, status int, body string, requests *int) context.Context {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, client)
+}
+
+func TestRenewExpiring(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus string
+	}{
+		{"renewed", 200, `{"access_token":"renewed","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`, "active"},
+		{"refresh token revoked", 400, `{"error":"invalid_grant"}`, "error"},
+		{"client disabled", 401, `{"error":"unauthorized_client"}`, "error"},
+		{"provider outage", 503, `{"error":"temporarily_unavailable"}`, "active"},
+		{"rate limited", 429, `rate limited`, "active"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOOGLE_CLIENT_ID", "id")
+			t.Setenv("GOOGLE_CLIENT_SECRET", "secret")
+			env := newTestEnv(t)
+			account, err := env.accounts.CreateAccount(&models.CreateAccountRequest{
+				Name:         "a",
+				Type:         "google",
+				Email:        "a@example.com",
+				Token:        "access",
+				RefreshToken: "refresh",
+				TokenExpiry:  time.Now().Add(time.Minute),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := refreshStub(t, tt.status, tt.body, new(int))
+			renewer := NewTokenRenewer(env.accounts, NewOAuthService(env.db, env.sealer))
+			if err := renewer.RenewExpiring(ctx); err != nil {
+				t.Fatal(err)
+			}
+			got, err := env.accounts.GetAccount(account.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("account is %s, want %s", got.Status, tt.wantStatus)
+			}
+			expiring, err := env.accounts.GetExpiringAccounts(time.Now().Add(tokenRenewLeeway))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if renewed := len(expiring) == 0; renewed != (tt.status == http.StatusOK) {
+				t.Errorf("token renewed = %v", renewed)
+			}
+		})
+	}
+}
+
+func TestRenewSharedDrives(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "id")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "secret")
+	env := newTestEnv(t)
+	parent, err := env.accounts.CreateAccount(&models.CreateAccountRequest{
+		Name:         "a",
+		Type:         "google",
+		Email:        "a@example.com",
+		Token:        "access",
+		RefreshToken: "refresh",
+		TokenExpiry:  time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.SetSharedDrives(parent.ID, []rclone.SharedDrive{{ID: "d1", Name: "Team"}})
+	children, err := env.accounts.AddSharedDrives(parent.ID, &models.AddSharedDrivesRequest{DriveIDs: []string{"d1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The shared drive holds the parent's token, which only the parent refreshes
+	var requests int
+	ctx := refreshStub(t, 200, `{"access_token":"renewed","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`, &requests)
+	if err := NewTokenRenewer(env.accounts, NewOAuthService(env.db, env.sealer)).RenewExpiring(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("token refreshed %d times, want once", requests)
+	}
+	child, err := env.accounts.getAccountWithSecrets(children[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.AccessToken != "renewed" || child.TokenExpiry.Before(time.Now().Add(tokenRenewLeeway)) {
+		t.Errorf("shared drive has token %q expiring at %v, want the renewed one", child.AccessToken, child.TokenExpiry)
+	}
+
+	// Nothing would renew the shared drive without its parent
+	var verr *ValidationError
+	if err := env.accounts.DeleteAccount(parent.ID); !errors.As(err, &verr) {
+		t.Errorf("deleting the parent: err = %v, want a validation error", err)
+	}
+	if err := env.accounts.DeleteAccount(child.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.accounts.DeleteAccount(parent.ID); err != nil {
+		t.Errorf("deleting the parent after its shared drive: %v", err)
+	}
+}
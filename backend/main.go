@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
 	"pooled-storage/internal/api"
 	"pooled-storage/internal/database"
 	"pooled-storage/internal/rclone"
+	"pooled-storage/internal/secrets"
 	"pooled-storage/internal/services"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -27,13 +31,76 @@ func main() {
 	}
 	defer db.Close()
 
+	// `pooled-storage rotate-key` re-encrypts stored secrets and exits
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		if err := rotateKey(db); err != nil {
+			log.Fatal("Key rotation failed:", err)
+		}
+		return
+	}
+
+	// Load master key and seal anything stored before encryption was enabled
+	masterKey, err := secrets.LoadKey()
+	if err != nil {
+		log.Fatal("Failed to load master key:", err)
+	}
+	sealer, err := secrets.NewSealer(masterKey)
+	if err != nil {
+		log.Fatal("Failed to load master key:", err)
+	}
+	if n, err := services.ResealSecrets(db, sealer, sealer); err != nil {
+		log.Fatal("Failed to encrypt stored secrets:", err)
+	} else if n > 0 {
+		log.Printf("Encrypted %d plaintext secrets", n)
+	}
+
 	// Initialize rclone manager
 	rcloneManager := rclone.NewManager()
 
 	// Initialize services
-	accountService := services.NewAccountService(db, rcloneManager)
-	storageService := services.NewStorageService(db, rcloneManager)
-	statsService := services.NewStatsService(db, rcloneManager)
+	accountService := services.NewAccountService(db, rcloneManager, sealer)
+	storageService := services.NewStorageService(db, rcloneManager, sealer)
+	statsService := services.NewStatsService(db, rcloneManager, storageService)
+	oauthService := services.NewOAuthService(db, sealer)
+	importService := services.NewImportService(accountService, oauthService)
+	configService := services.NewConfigService(rcloneManager, accountService, storageService)
+	reconciler := services.NewReconciler(db, storageService)
+	drainService := services.NewDrainService(db, storageService, rcloneManager)
+	rebalanceService := services.NewRebalanceService(db, storageService, accountService, rcloneManager)
+	serveService := services.NewServeService(db, storageService, rcloneManager)
+
+	// A drain can't resume after a restart, give its account back its writes
+	// before the config is written
+	if n, err := drainService.FailInterrupted(); err != nil {
+		log.Fatal("Failed to clean up interrupted drains:", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted drain(s) as failed", n)
+	}
+	if n, err := rebalanceService.FailInterrupted(); err != nil {
+		log.Fatal("Failed to clean up interrupted rebalances:", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted rebalance(s) as failed", n)
+	}
+
+	// The database is the source of truth, write rclone.conf from it before
+	// rclone reads it
+	if err := configService.Regenerate(); err != nil {
+		log.Fatal("Failed to write rclone config:", err)
+	}
+	if err := rcloneManager.Start(); err != nil {
+		log.Fatal("Failed to start rclone:", err)
+	}
+	defer func() {
+		if err := rcloneManager.Close(); err != nil {
+			log.Printf("Failed to shut down rclone: %v", err)
+		}
+	}()
+
+	// Background workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go services.NewTokenRenewer(accountService, oauthService).Run(ctx)
+	go reconciler.Run(ctx)
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -61,10 +128,15 @@ func main() {
 
 	// Initialize API handlers
 	api.SetupAccountRoutes(apiRouter, accountService)
+	api.SetupImportRoutes(apiRouter, importService)
 	api.SetupStorageRoutes(apiRouter, storageService)
+	api.SetupReconcileRoutes(apiRouter, reconciler)
+	api.SetupDrainRoutes(apiRouter, drainService)
+	api.SetupRebalanceRoutes(apiRouter, rebalanceService)
+	api.SetupServeRoutes(apiRouter, serveService)
 	api.SetupStatsRoutes(apiRouter, statsService)
-	api.SetupOAuthRoutes(apiRouter, accountService)
-	api.SetupSettingsRoutes(apiRouter, db)
+	api.SetupOAuthRoutes(apiRouter, accountService, oauthService)
+	api.SetupSettingsRoutes(apiRouter, oauthService, configService)
 
 	// Get port from environment
 	port := os.Getenv("PORT")
@@ -72,9 +144,22 @@ func main() {
 		port = "8080"
 	}
 
+	// On SIGTERM stop serving and return from main, so the deferred Close
+	// unmounts every pool before the container goes away
+	go func() {
+		sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		<-sigCtx.Done()
+		if ctx.Err() == nil {
+			log.Println("Shutting down")
+			app.Shutdown()
+		}
+	}()
+
 	// Start server
 	log.Printf("Starting Pooled Storage Manager on port %s", port)
+	// log.Fatal would skip the deferred Close and leave the pools mounted
 	if err := app.Listen(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+		log.Println("Failed to start server:", err)
 	}
 }
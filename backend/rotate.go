@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"pooled-storage/internal/secrets"
+	"pooled-storage/internal/services"
+)
+
+// rotateKey re-encrypts every stored secret under a new master key. The new
+// key comes from NEW_MASTER_KEY or is generated. With a key file it is
+// written to the file once the database has been updated; with MASTER_KEY in
+// the environment it is printed so the deployment can be updated.
+func rotateKey(db *sql.DB) error {
+	oldKey, err := secrets.LoadKey()
+	if err != nil {
+		return err
+	}
+	oldSealer, err := secrets.NewSealer(oldKey)
+	if err != nil {
+		return err
+	}
+
+	var newKey []byte
+	if encoded := os.Getenv("NEW_MASTER_KEY"); encoded != "" {
+		newKey, err = secrets.DecodeKey(encoded)
+	} else {
+		newKey, err = secrets.GenerateKey()
+	}
+	if err != nil {
+		return err
+	}
+	newSealer, err := secrets.NewSealer(newKey)
+	if err != nil {
+		return err
+	}
+
+	// Keep the new key on disk before touching the database so a crash in
+	// between never leaves rows sealed with a key that exists nowhere.
+	keyFile := secrets.KeyFilePath()
+	pending := keyFile + ".new"
+	if err := secrets.WriteKeyFile(pending, newKey); err != nil {
+		return err
+	}
+
+	n, err := services.ResealSecrets(db, oldSealer, newSealer)
+	if err != nil {
+		os.Remove(pending)
+		return err
+	}
+	log.Printf("Re-encrypted %d secrets", n)
+
+	if os.Getenv("MASTER_KEY") != "" {
+		os.Remove(pending)
+		fmt.Printf("Set MASTER_KEY to the new key and restart:\n%s\n", secrets.EncodeKey(newKey))
+		return nil
+	}
+
+	if err := os.Rename(pending, keyFile); err != nil {
+		return fmt.Errorf("database uses the new key but %s could not be replaced (new key is in %s): %w",
+			keyFile, pending, err)
+	}
+	log.Printf("New master key written to %s", keyFile)
+	return nil
+}
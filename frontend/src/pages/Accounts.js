@@ -23,11 +23,44 @@ import {
   MenuItem,
   Tabs,
   Tab,
+  Checkbox,
+  List,
+  ListItem,
+  ListItemIcon,
+  ListItemText,
 } from '@mui/material';
 import DeleteIcon from '@mui/icons-material/Delete';
 import RefreshIcon from '@mui/icons-material/Refresh';
 import AddIcon from '@mui/icons-material/Add';
-import { getAccounts, createAccount, deleteAccount, refreshAccount, startOAuth } from '../services/api';
+import {
+  getAccounts,
+  createAccount,
+  deleteAccount,
+  refreshAccount,
+  startOAuth,
+  previewImport,
+  importRemotes,
+} from '../services/api';
+
+const PROVIDERS = [
+  { id: 'google', label: 'Google Drive' },
+  { id: 'microsoft', label: 'Microsoft OneDrive' },
+  { id: 'dropbox', label: 'Dropbox' },
+  { id: 'box', label: 'Box' },
+  { id: 'pcloud', label: 'pCloud' },
+];
+
+// Non-OAuth backends and the credential fields each one takes
+const SERVER_TYPES = {
+  s3: {
+    label: 'S3 / MinIO',
+    fields: ['endpoint', 'region', 'access_key_id', 'secret_access_key', 'bucket', 'path'],
+  },
+  webdav: { label: 'WebDAV', fields: ['url', 'vendor', 'user', 'password'] },
+  sftp: { label: 'SFTP', fields: ['host', 'port', 'user', 'password', 'private_key', 'path'] },
+};
+
+const SECRET_FIELDS = ['secret_access_key', 'password'];
 
 export default function Accounts() {
   const [accounts, setAccounts] = useState([]);
@@ -53,10 +86,16 @@ export default function Accounts() {
     loadAccounts();
   }, []);
 
+  const [serverData, setServerData] = useState({ name: '', type: 's3', capacity: '', credentials: {} });
+
+  const [importData, setImportData] = useState({ config: '', password: '', remotes: [], selected: [] });
+
   const handleOpen = () => setOpen(true);
   const handleClose = () => {
     setOpen(false);
     setFormData({ name: '', type: 'google', email: '', token: '' });
+    setServerData({ name: '', type: 's3', capacity: '', credentials: {} });
+    setImportData({ config: '', password: '', remotes: [], selected: [] });
   };
 
   const handleOAuthLogin = async (provider) => {
@@ -69,6 +108,8 @@ export default function Accounts() {
         if (event.data.type === 'oauth-success') {
           await loadAccounts();
           handleClose();
+        } else if (event.data.type === 'oauth-error') {
+          alert(event.data.error);
         }
       });
     } catch (error) {
@@ -88,6 +129,61 @@ export default function Accounts() {
     }
   };
 
+  const handleServerSubmit = async () => {
+    const credentials = { ...serverData.credentials };
+    if (credentials.port) credentials.port = parseInt(credentials.port, 10);
+    try {
+      await createAccount({
+        name: serverData.name,
+        type: serverData.type,
+        credentials,
+        capacity: serverData.capacity ? Math.round(parseFloat(serverData.capacity) * 1024 ** 3) : 0,
+      });
+      await loadAccounts();
+      handleClose();
+    } catch (error) {
+      console.error('Failed to create account:', error);
+      alert(error.response?.data?.error || 'Failed to create account');
+    }
+  };
+
+  const handlePreviewImport = async () => {
+    try {
+      const response = await previewImport(importData.config, importData.password);
+      const remotes = response.data;
+      setImportData({
+        ...importData,
+        remotes,
+        selected: remotes.filter((r) => r.status === 'importable').map((r) => r.remote),
+      });
+    } catch (error) {
+      alert(error.response?.data?.error || 'Failed to read rclone config');
+    }
+  };
+
+  const toggleImportRemote = (name) => {
+    const selected = importData.selected.includes(name)
+      ? importData.selected.filter((n) => n !== name)
+      : [...importData.selected, name];
+    setImportData({ ...importData, selected });
+  };
+
+  const handleImport = async () => {
+    try {
+      const response = await importRemotes(importData.config, importData.password, importData.selected);
+      await loadAccounts();
+      const failed = response.data.filter((r) => r.status !== 'imported');
+      if (failed.length > 0) {
+        alert(failed.map((r) => `${r.remote}: ${r.reason || r.status}`).join('\n'));
+        setImportData({ ...importData, remotes: response.data, selected: [] });
+      } else {
+        handleClose();
+      }
+    } catch (error) {
+      alert(error.response?.data?.error || 'Failed to import remotes');
+    }
+  };
+
   const handleDelete = async (id) => {
     if (window.confirm('Are you sure you want to delete this account?')) {
       try {
@@ -148,6 +244,12 @@ export default function Accounts() {
                     color={account.type === 'google' ? 'primary' : 'secondary'}
                     size="small"
                   />
+                  {account.auth_method === 'service_account' && (
+                    <Chip label="service account" variant="outlined" size="small" sx={{ ml: 1 }} />
+                  )}
+                  {account.shared_drive_id && (
+                    <Chip label="shared drive" variant="outlined" size="small" sx={{ ml: 1 }} />
+                  )}
                 </TableCell>
                 <TableCell>{account.email}</TableCell>
                 <TableCell>{formatBytes(account.quota_total)}</TableCell>
@@ -179,6 +281,8 @@ export default function Accounts() {
           <Tabs value={tabValue} onChange={(e, v) => setTabValue(v)} sx={{ mb: 2 }}>
             <Tab label="OAuth Login" />
             <Tab label="Manual Token" />
+            <Tab label="Storage Server" />
+            <Tab label="Import rclone.conf" />
           </Tabs>
 
           {tabValue === 0 && (
@@ -186,22 +290,18 @@ export default function Accounts() {
               <Typography variant="body2" color="text.secondary" sx={{ mb: 2 }}>
                 Authenticate with your cloud provider using OAuth
               </Typography>
-              <Box display="flex" gap={2}>
-                <Button
-                  variant="contained"
-                  fullWidth
-                  onClick={() => handleOAuthLogin('google')}
-                >
-                  Login with Google
-                </Button>
-                <Button
-                  variant="contained"
-                  color="secondary"
-                  fullWidth
-                  onClick={() => handleOAuthLogin('microsoft')}
-                >
-                  Login with Microsoft
-                </Button>
+              <Box display="flex" flexWrap="wrap" gap={2}>
+                {PROVIDERS.map((provider) => (
+                  <Button
+                    key={provider.id}
+                    variant="contained"
+                    color={provider.id === 'google' ? 'primary' : 'secondary'}
+                    sx={{ flex: '1 1 45%' }}
+                    onClick={() => handleOAuthLogin(provider.id)}
+                  >
+                    Login with {provider.label}
+                  </Button>
+                ))}
               </Box>
             </Box>
           )}
@@ -222,8 +322,9 @@ export default function Accounts() {
                   onChange={(e) => setFormData({ ...formData, type: e.target.value })}
                   label="Type"
                 >
-                  <MenuItem value="google">Google Drive</MenuItem>
-                  <MenuItem value="microsoft">Microsoft OneDrive</MenuItem>
+                  {PROVIDERS.map((provider) => (
+                    <MenuItem key={provider.id} value={provider.id}>{provider.label}</MenuItem>
+                  ))}
                 </Select>
               </FormControl>
               <TextField
@@ -244,6 +345,95 @@ export default function Accounts() {
               />
             </Box>
           )}
+
+          {tabValue === 2 && (
+            <Box sx={{ pt: 2 }}>
+              <TextField
+                fullWidth
+                label="Name"
+                value={serverData.name}
+                onChange={(e) => setServerData({ ...serverData, name: e.target.value })}
+                margin="normal"
+              />
+              <FormControl fullWidth margin="normal">
+                <InputLabel>Type</InputLabel>
+                <Select
+                  value={serverData.type}
+                  onChange={(e) => setServerData({ ...serverData, type: e.target.value, credentials: {} })}
+                  label="Type"
+                >
+                  {Object.entries(SERVER_TYPES).map(([id, type]) => (
+                    <MenuItem key={id} value={id}>{type.label}</MenuItem>
+                  ))}
+                </Select>
+              </FormControl>
+              {SERVER_TYPES[serverData.type].fields.map((field) => (
+                <TextField
+                  key={field}
+                  fullWidth
+                  label={field.replace(/_/g, ' ')}
+                  type={SECRET_FIELDS.includes(field) ? 'password' : 'text'}
+                  value={serverData.credentials[field] || ''}
+                  onChange={(e) => setServerData({
+                    ...serverData,
+                    credentials: { ...serverData.credentials, [field]: e.target.value },
+                  })}
+                  margin="normal"
+                  multiline={field === 'private_key'}
+                  rows={field === 'private_key' ? 4 : undefined}
+                />
+              ))}
+              <TextField
+                fullWidth
+                label="Capacity (GB)"
+                helperText="Used when the server does not report a quota"
+                type="number"
+                value={serverData.capacity}
+                onChange={(e) => setServerData({ ...serverData, capacity: e.target.value })}
+                margin="normal"
+              />
+            </Box>
+          )}
+
+          {tabValue === 3 && (
+            <Box sx={{ pt: 2 }}>
+              <TextField
+                fullWidth
+                label="rclone.conf contents"
+                value={importData.config}
+                onChange={(e) => setImportData({ ...importData, config: e.target.value, remotes: [], selected: [] })}
+                margin="normal"
+                multiline
+                rows={6}
+              />
+              <TextField
+                fullWidth
+                label="Config password (if encrypted)"
+                type="password"
+                value={importData.password}
+                onChange={(e) => setImportData({ ...importData, password: e.target.value })}
+                margin="normal"
+              />
+              <List dense>
+                {importData.remotes.map((remote) => (
+                  <ListItem key={remote.remote} disablePadding>
+                    <ListItemIcon>
+                      <Checkbox
+                        edge="start"
+                        checked={importData.selected.includes(remote.remote)}
+                        disabled={remote.status !== 'importable'}
+                        onChange={() => toggleImportRemote(remote.remote)}
+                      />
+                    </ListItemIcon>
+                    <ListItemText
+                      primary={`${remote.remote} (${remote.rclone_type || 'unknown'})${remote.email ? ' - ' + remote.email : ''}`}
+                      secondary={remote.reason || remote.status}
+                    />
+                  </ListItem>
+                ))}
+              </List>
+            </Box>
+          )}
         </DialogContent>
         <DialogActions>
           <Button onClick={handleClose}>Cancel</Button>
@@ -252,6 +442,21 @@ export default function Accounts() {
               Add Account
             </Button>
           )}
+          {tabValue === 2 && (
+            <Button onClick={handleServerSubmit} variant="contained">
+              Add Account
+            </Button>
+          )}
+          {tabValue === 3 && (
+            <>
+              <Button onClick={handlePreviewImport} disabled={!importData.config}>
+                Preview
+              </Button>
+              <Button onClick={handleImport} variant="contained" disabled={importData.selected.length === 0}>
+                Import Selected
+              </Button>
+            </>
+          )}
         </DialogActions>
       </Dialog>
     </Box>
@@ -75,27 +75,18 @@ export default function Settings() {
           OAuth credentials should be configured via environment variables or the .env file.
         </Alert>
 
-        <Box sx={{ mb: 2 }}>
-          <Typography variant="subtitle2" gutterBottom>
-            Google Drive
-          </Typography>
-          <Alert severity={oauthSettings?.google?.configured ? 'success' : 'warning'}>
-            {oauthSettings?.google?.configured 
-              ? 'Google OAuth is configured' 
-              : 'Google OAuth is not configured'}
-          </Alert>
-        </Box>
-
-        <Box>
-          <Typography variant="subtitle2" gutterBottom>
-            Microsoft OneDrive
-          </Typography>
-          <Alert severity={oauthSettings?.microsoft?.configured ? 'success' : 'warning'}>
-            {oauthSettings?.microsoft?.configured 
-              ? 'Microsoft OAuth is configured' 
-              : 'Microsoft OAuth is not configured'}
-          </Alert>
-        </Box>
+        {Object.values(oauthSettings || {}).map((provider) => (
+          <Box sx={{ mb: 2 }} key={provider.provider}>
+            <Typography variant="subtitle2" gutterBottom>
+              {provider.label}
+            </Typography>
+            <Alert severity={provider.configured ? 'success' : 'warning'}>
+              {provider.configured
+                ? `${provider.label} OAuth is configured`
+                : `${provider.label} OAuth is not configured`}
+            </Alert>
+          </Box>
+        ))}
       </Paper>
 
       <Paper sx={{ p: 3 }}>
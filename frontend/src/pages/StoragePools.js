@@ -29,6 +29,12 @@ import StopIcon from '@mui/icons-material/Stop';
 import AddIcon from '@mui/icons-material/Add';
 import { getPools, createPool, deletePool, startPool, stopPool, getAccounts } from '../services/api';
 
+// Policies rclone's union backend accepts, for the custom strategy
+const UNION_POLICIES = [
+  'all', 'epall', 'epff', 'eplfs', 'eplno', 'eplus', 'epmfs', 'eprand',
+  'ff', 'lfs', 'lno', 'lus', 'mfs', 'newest', 'rand',
+];
+
 export default function StoragePools() {
   const [pools, setPools] = useState([]);
   const [accounts, setAccounts] = useState([]);
@@ -36,9 +42,23 @@ export default function StoragePools() {
   const [formData, setFormData] = useState({
     name: '',
     strategy: 'union',
+    action_policy: 'epall',
+    create_policy: 'epmfs',
+    search_policy: 'ff',
+    encryption: '',
+    filename_encryption: 'standard',
+    enable_compression: false,
+    compression_level: -1,
+    compression_ram_cache_limit: '20M',
     enable_chunker: false,
     allow_large_files: false,
     chunk_size: '100M',
+    chunker_mode: 'upstream',
+    chunker_hash_type: 'md5',
+    chunker_name_format: '*.rclone_chunk.###',
+    chunker_fail_hard: false,
+    auto_start: true,
+    mount_profile: 'default',
     account_ids: [],
   });
 
@@ -71,16 +91,32 @@ export default function StoragePools() {
     setFormData({
       name: '',
       strategy: 'union',
+      action_policy: 'epall',
+      create_policy: 'epmfs',
+      search_policy: 'ff',
+      encryption: '',
+      filename_encryption: 'standard',
+      enable_compression: false,
+      compression_level: -1,
+      compression_ram_cache_limit: '20M',
       enable_chunker: false,
       allow_large_files: false,
       chunk_size: '100M',
+      chunker_mode: 'upstream',
+      chunker_hash_type: 'md5',
+      chunker_name_format: '*.rclone_chunk.###',
+      chunker_fail_hard: false,
+      auto_start: true,
+      mount_profile: 'default',
       account_ids: [],
     });
   };
 
   const handleSubmit = async () => {
     try {
-      await createPool(formData);
+      // Explicit policies override a preset's, so only custom pools send them
+      const { action_policy, create_policy, search_policy, ...preset } = formData;
+      await createPool(formData.strategy === 'custom' ? formData : preset);
       await loadPools();
       handleClose();
     } catch (error) {
@@ -151,7 +187,7 @@ export default function StoragePools() {
                   />
                 </Box>
                 <Typography variant="body2" color="text.secondary" gutterBottom>
-                  Strategy: {pool.strategy}
+                  Strategy: {pool.strategy} ({pool.action_policy}/{pool.create_policy}/{pool.search_policy})
                 </Typography>
                 <Typography variant="body2" color="text.secondary" gutterBottom>
                   Accounts: {pool.accounts?.length || 0}
@@ -160,13 +196,31 @@ export default function StoragePools() {
                   <Chip label={`Chunker: ${pool.chunk_size}`} size="small" sx={{ mt: 1, mr: 1 }} />
                 )}
                 {pool.allow_large_files && (
-                  <Chip label="Large Files" size="small" sx={{ mt: 1 }} />
+                  <Chip label="Large Files" size="small" sx={{ mt: 1, mr: 1 }} />
+                )}
+                {pool.auto_start && (
+                  <Chip label="Auto-start" size="small" sx={{ mt: 1 }} />
+                )}
+                {pool.mount_profile && pool.mount_profile !== 'default' && (
+                  <Chip label={`Profile: ${pool.mount_profile}`} size="small" sx={{ mt: 1, ml: 1 }} />
+                )}
+                {pool.encryption && (
+                  <Chip label="Encrypted" size="small" sx={{ mt: 1, ml: 1 }} />
+                )}
+                {pool.enable_compression && (
+                  <Chip label="Compressed" size="small" sx={{ mt: 1, ml: 1 }} />
                 )}
                 {pool.mount_path && (
                   <Typography variant="caption" display="block" sx={{ mt: 1 }}>
                     Mounted at: {pool.mount_path}
                   </Typography>
                 )}
+                {pool.serves && pool.serves.map((serve) => (
+                  <Typography key={serve.id} variant="caption" display="block">
+                    {serve.protocol.toUpperCase()}: {serve.url} ({serve.status}
+                    {serve.read_only ? ', read-only' : ''})
+                  </Typography>
+                ))}
               </CardContent>
               <CardActions>
                 {pool.status === 'running' ? (
@@ -209,9 +263,94 @@ export default function StoragePools() {
               <MenuItem value="eplus">Most Free Space</MenuItem>
               <MenuItem value="epff">First Drive First</MenuItem>
               <MenuItem value="mirror">Mirror (Redundancy)</MenuItem>
+              <MenuItem value="custom">Custom Policies</MenuItem>
             </Select>
           </FormControl>
 
+          {formData.strategy === 'custom' && (
+            <Box sx={{ display: 'flex', gap: 2 }}>
+              {[
+                ['action_policy', 'Action Policy'],
+                ['create_policy', 'Create Policy'],
+                ['search_policy', 'Search Policy'],
+              ].map(([field, label]) => (
+                <FormControl key={field} fullWidth margin="normal">
+                  <InputLabel>{label}</InputLabel>
+                  <Select
+                    value={formData[field]}
+                    onChange={(e) => setFormData({ ...formData, [field]: e.target.value })}
+                    label={label}
+                  >
+                    {UNION_POLICIES.map((policy) => (
+                      <MenuItem key={policy} value={policy}>{policy}</MenuItem>
+                    ))}
+                  </Select>
+                </FormControl>
+              ))}
+            </Box>
+          )}
+
+          <FormControl fullWidth margin="normal">
+            <InputLabel>Encryption</InputLabel>
+            <Select
+              value={formData.encryption}
+              onChange={(e) => setFormData({ ...formData, encryption: e.target.value })}
+              label="Encryption"
+            >
+              <MenuItem value="">None</MenuItem>
+              <MenuItem value="union">Encrypt the pool</MenuItem>
+              <MenuItem value="upstream">Encrypt each account</MenuItem>
+            </Select>
+          </FormControl>
+
+          {formData.encryption && (
+            <FormControl fullWidth margin="normal">
+              <InputLabel>File Name Encryption</InputLabel>
+              <Select
+                value={formData.filename_encryption}
+                onChange={(e) => setFormData({ ...formData, filename_encryption: e.target.value })}
+                label="File Name Encryption"
+              >
+                <MenuItem value="standard">Standard</MenuItem>
+                <MenuItem value="obfuscate">Obfuscate</MenuItem>
+                <MenuItem value="off">Off</MenuItem>
+              </Select>
+            </FormControl>
+          )}
+
+          <FormControlLabel
+            control={
+              <Switch
+                checked={formData.enable_compression}
+                onChange={(e) => setFormData({ ...formData, enable_compression: e.target.checked })}
+              />
+            }
+            label="Enable Compression (gzip)"
+          />
+
+          {formData.enable_compression && (
+            <>
+              <TextField
+                fullWidth
+                type="number"
+                label="Compression Level"
+                value={formData.compression_level}
+                onChange={(e) => setFormData({ ...formData, compression_level: Number(e.target.value) })}
+                margin="normal"
+                inputProps={{ min: -2, max: 9 }}
+                helperText="-2 (fastest) to 9 (smallest), -1 for the gzip default"
+              />
+              <TextField
+                fullWidth
+                label="RAM Cache Limit"
+                value={formData.compression_ram_cache_limit}
+                onChange={(e) => setFormData({ ...formData, compression_ram_cache_limit: e.target.value })}
+                margin="normal"
+                helperText="Files up to this size are compressed in memory, e.g., 20M"
+              />
+            </>
+          )}
+
           <FormControlLabel
             control={
               <Switch
@@ -233,6 +372,51 @@ export default function StoragePools() {
             />
           )}
 
+          {formData.enable_chunker && (
+            <>
+              <FormControl fullWidth margin="normal">
+                <InputLabel>Chunk Placement</InputLabel>
+                <Select
+                  value={formData.chunker_mode}
+                  onChange={(e) => setFormData({ ...formData, chunker_mode: e.target.value })}
+                  label="Chunk Placement"
+                >
+                  <MenuItem value="upstream">Keep a file's chunks on one account</MenuItem>
+                  <MenuItem value="union">Spread chunks over accounts</MenuItem>
+                </Select>
+              </FormControl>
+              <FormControl fullWidth margin="normal">
+                <InputLabel>Chunk Hash Type</InputLabel>
+                <Select
+                  value={formData.chunker_hash_type}
+                  onChange={(e) => setFormData({ ...formData, chunker_hash_type: e.target.value })}
+                  label="Chunk Hash Type"
+                >
+                  {['none', 'md5', 'sha1', 'md5all', 'sha1all', 'md5quick', 'sha1quick'].map((type) => (
+                    <MenuItem key={type} value={type}>{type}</MenuItem>
+                  ))}
+                </Select>
+              </FormControl>
+              <TextField
+                fullWidth
+                label="Chunk Name Format"
+                value={formData.chunker_name_format}
+                onChange={(e) => setFormData({ ...formData, chunker_name_format: e.target.value })}
+                margin="normal"
+                helperText="* is the file name, ### the chunk number"
+              />
+              <FormControlLabel
+                control={
+                  <Switch
+                    checked={formData.chunker_fail_hard}
+                    onChange={(e) => setFormData({ ...formData, chunker_fail_hard: e.target.checked })}
+                  />
+                }
+                label="Fail on Broken Chunks"
+              />
+            </>
+          )}
+
           <FormControlLabel
             control={
               <Switch
@@ -243,6 +427,29 @@ export default function StoragePools() {
             label="Allow files larger than drive size"
           />
 
+          <FormControl fullWidth margin="normal">
+            <InputLabel>Mount Profile</InputLabel>
+            <Select
+              value={formData.mount_profile}
+              onChange={(e) => setFormData({ ...formData, mount_profile: e.target.value })}
+              label="Mount Profile"
+            >
+              <MenuItem value="default">Default (cache writes)</MenuItem>
+              <MenuItem value="media">Media (cache whole files, read ahead)</MenuItem>
+              <MenuItem value="backup">Backup (cache writes, fresh listings)</MenuItem>
+            </Select>
+          </FormControl>
+
+          <FormControlLabel
+            control={
+              <Switch
+                checked={formData.auto_start}
+                onChange={(e) => setFormData({ ...formData, auto_start: e.target.checked })}
+              />
+            }
+            label="Remount automatically after restarts"
+          />
+
           <Typography variant="subtitle1" sx={{ mt: 2, mb: 1 }}>
             Select Accounts
           </Typography>
@@ -13,26 +13,58 @@ const api = axios.create({
 export const getAccounts = () => api.get('/accounts');
 export const getAccount = (id) => api.get(`/accounts/${id}`);
 export const createAccount = (data) => api.post('/accounts', data);
+export const createServiceAccount = (data) => api.post('/accounts/service', data);
+export const previewImport = (config, password) =>
+  api.post('/accounts/import/preview', { config, password });
+export const importRemotes = (config, password, remotes) =>
+  api.post('/accounts/import', { config, password, remotes });
 export const deleteAccount = (id) => api.delete(`/accounts/${id}`);
 export const refreshAccount = (id) => api.post(`/accounts/${id}/refresh`);
 export const updateAccountStatus = (id, status) => api.put(`/accounts/${id}/status`, { status });
+export const getSharedDrives = (id) => api.get(`/accounts/${id}/shared-drives`);
+export const addSharedDrives = (id, driveIds, capacity) =>
+  api.post(`/accounts/${id}/shared-drives`, { drive_ids: driveIds, capacity });
 
 // Storage Pools
 export const getPools = () => api.get('/pools');
 export const getPool = (id) => api.get(`/pools/${id}`);
 export const createPool = (data) => api.post('/pools', data);
+export const updatePool = (id, data) => api.put(`/pools/${id}`, data);
 export const deletePool = (id) => api.delete(`/pools/${id}`);
 export const startPool = (id) => api.post(`/pools/${id}/start`);
 export const stopPool = (id) => api.post(`/pools/${id}/stop`);
+export const exportCryptKeys = (id) => api.get(`/pools/${id}/crypt-keys`);
+export const getPoolLogs = (id) => api.get(`/pools/${id}/logs`);
+export const getMountProfiles = () => api.get('/mount-profiles');
+export const getPoolServes = (poolId) => api.get(`/pools/${poolId}/serves`);
+export const createServe = (poolId, data) => api.post(`/pools/${poolId}/serves`, data);
+export const getServe = (id) => api.get(`/serves/${id}`);
+export const deleteServe = (id) => api.delete(`/serves/${id}`);
 export const addAccountToPool = (poolId, accountId) => 
   api.post(`/pools/${poolId}/accounts`, { account_id: accountId });
-export const removeAccountFromPool = (poolId, accountId) => 
-  api.delete(`/pools/${poolId}/accounts/${accountId}`);
+export const removeAccountFromPool = (poolId, accountId, force = false) => 
+  api.delete(`/pools/${poolId}/accounts/${accountId}`, { params: force ? { force: true } : {} });
+export const drainAccount = (poolId, accountId) =>
+  api.post(`/pools/${poolId}/accounts/${accountId}/drain`);
+export const getPoolDrains = (poolId) => api.get(`/pools/${poolId}/drains`);
+export const getDrain = (id) => api.get(`/drains/${id}`);
+export const cancelDrain = (id) => api.post(`/drains/${id}/cancel`);
+export const planRebalance = (poolId, options = {}) =>
+  api.post(`/pools/${poolId}/rebalance`, options);
+export const getPoolRebalances = (poolId) => api.get(`/pools/${poolId}/rebalances`);
+export const getRebalance = (id) => api.get(`/rebalances/${id}`);
+export const startRebalance = (id) => api.post(`/rebalances/${id}/start`);
+export const cancelRebalance = (id) => api.post(`/rebalances/${id}/cancel`);
+export const getDriftEvents = (poolId) =>
+  api.get('/reconcile/events', { params: poolId ? { pool_id: poolId } : {} });
+export const reconcilePools = () => api.post('/reconcile');
 
 // Stats
 export const getStats = () => api.get('/stats');
 export const getAccountStats = () => api.get('/stats/accounts');
 export const getPoolStats = () => api.get('/stats/pools');
+export const getPoolSize = (id) => api.get(`/stats/pools/${id}/size`);
+export const getTransferStats = () => api.get('/stats/transfers');
 export const refreshStats = () => api.post('/stats/refresh');
 
 // OAuth
@@ -42,7 +74,11 @@ export const getOAuthStatus = () => api.get('/oauth/test');
 
 // Settings
 export const getOAuthSettings = () => api.get('/settings/oauth');
+export const saveOAuthSettings = (provider, data) => api.put(`/settings/oauth/${provider}`, data);
+export const deleteOAuthSettings = (provider) => api.delete(`/settings/oauth/${provider}`);
 export const getSystemSettings = () => api.get('/settings/system');
+export const getRcloneConfig = () => api.get('/settings/rclone-config');
+export const regenerateRcloneConfig = () => api.post('/settings/rclone-config/regenerate');
 
 // Health
 export const checkHealth = () => api.get('/health');